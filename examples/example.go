@@ -190,7 +190,7 @@ func Examples() {
 
 	err = userTx.WithTransaction(func(sessCtx mongo.SessionContext) error {
 		// 在交易中建立使用者
-		if err := userTx.Create(); err != nil {
+		if err := userTx.WithContext(sessCtx).Create(); err != nil {
 			return err
 		}
 		// 在交易中更新使用者名稱
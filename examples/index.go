@@ -0,0 +1,35 @@
+package examples
+
+import (
+	"context"
+	"fmt"
+	examples "godm/examples/model"
+	"godm/pkg/odm"
+	"log"
+)
+
+// 索引透過模型上的 index tag 宣告（見 model/user.go 的 Email 與 model/post.go 的 UserID），
+// 在啟動時（或部署指令中）以 EnsureIndexes 同步。
+// Indexes are declared with index tags on the models (User.Email and Post.UserID) and synced with
+// EnsureIndexes at startup or from a deploy command.
+
+func IndexExample() {
+	report, err := odm.EnsureIndexes(context.TODO(), examples.NewUser(), examples.NewPost())
+	if err != nil {
+		log.Println("同步索引錯誤:", err)
+		return
+	}
+	fmt.Println("建立的索引 (created):", report.Created)
+	for _, drift := range report.Drifted {
+		fmt.Printf("索引不一致 (drifted) %s.%s: %s\n", drift.Collection, drift.Name, drift.Reason)
+	}
+
+	// 只檢查差異，包含已不再宣告的索引 / only report the differences, undeclared indexes included
+	report, err = odm.SyncIndexes(context.TODO(), odm.IndexSyncOptions{DropUndeclared: true, DryRun: true},
+		examples.NewUser(), examples.NewPost())
+	if err != nil {
+		log.Println("檢查索引錯誤:", err)
+		return
+	}
+	fmt.Println("未宣告的索引 (undeclared):", report.Dropped)
+}
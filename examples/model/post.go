@@ -10,12 +10,11 @@ import (
 type Post struct {
 	odm.GODM `bson:"-"`
 	ID       primitive.ObjectID `bson:"_id,omitempty"`
-	UserID   primitive.ObjectID `bson:"user_id"`
+	UserID   primitive.ObjectID `bson:"user_id" index:"asc"`
 	Title    string             `bson:"title"`
 	Body     string             `bson:"body"`
 
-	User *User `bson:"user,omitempty"` // 新增 User 欄位
-
+	User *User `bson:"user,omitempty" odm:"belongsTo,localKey=user_id"` // 新增 User 欄位
 }
 
 // NewUser 建立一個新的 User 實例，並初始化 ODM。
@@ -13,9 +13,9 @@ type User struct {
 	odm.GODM `bson:"-"`
 	ID       primitive.ObjectID `bson:"_id,omitempty"`
 	Name     string             `bson:"name"`
-	Email    string             `bson:"email"`
+	Email    string             `bson:"email" index:"asc"`
 
-	Posts []Post `bson:"posts,omitempty"`
+	Posts []Post `bson:"posts,omitempty" odm:"hasMany,foreignKey=user_id"`
 }
 
 // NewUser 建立一個新的 User 實例，並初始化 ODM。
@@ -3,93 +3,49 @@ package examples
 import (
 	"fmt"
 	examples "godm/examples/model"
-	"godm/pkg/odm"
 	"log"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// NewUserModel 建立具備 with 關聯設定的 User 模型
-func NewUserModel() *odm.GODM {
-	user := examples.NewUser()
-	return user.SetRelationConfig(map[string]odm.RelationConfig{
-		"posts": {
-			From:         "posts",
-			LocalField:   "_id",
-			ForeignField: "user_id",
-			As:           "posts",
-			IsArray:      true,
-		},
-	})
-}
-
-func NewPostModel() *odm.GODM {
-	post := examples.NewPost()
-	return post.SetRelationConfig(map[string]odm.RelationConfig{
-		"user": {
-			From:         "users",
-			LocalField:   "user_id",
-			ForeignField: "_id",
-			As:           "user",
-			IsArray:      false,
-		},
-	})
-}
+// 關聯透過模型上的 struct tag 宣告（見 model/user.go 的 Posts 與 model/post.go 的 User），
+// 不需要再以 SetRelationConfig 手動設定。
+// Relations are declared with struct tags on the models (User.Posts and Post.User),
+// so no SetRelationConfig setup is needed.
 
 func RelationExample() {
-	// 初始化 ODM 用戶模型
-	userModel := NewUserModel()
-
-	// 建立測試資料
-	userID := primitive.NewObjectID()
-	post1 := examples.Post{
-		ID:     primitive.NewObjectID(),
-		UserID: userID,
-		Title:  "Post 1",
-		Body:   "This is the first post.",
-	}
-	post2 := examples.Post{
-		ID:     primitive.NewObjectID(),
-		UserID: userID,
-		Title:  "Post 2",
-		Body:   "This is the second post.",
-	}
-
 	// 插入使用者
-	userModel.Model = &examples.User{
-		ID:    userID,
-		Name:  "With Tester",
-		Email: "with@test.com",
-	}
-	err := userModel.Create()
-	if err != nil {
+	newUser := examples.NewUser()
+	newUser.ID = primitive.NewObjectID()
+	newUser.Name = "With Tester"
+	newUser.Email = "with@test.com"
+	if err := newUser.Create(); err != nil {
 		log.Println("插入使用者錯誤:", err)
 		return
 	}
-	// 插入貼文
-	postModel := NewPostModel()
-	postModel.Model = &post1
-	err = postModel.Create()
-	if err != nil {
+	userID := newUser.ID
+
+	// 透過關聯插入貼文，UserID 會自動設定
+	post1 := examples.NewPost()
+	post1.Title = "Post 1"
+	post1.Body = "This is the first post."
+
+	post2 := examples.NewPost()
+	post2.Title = "Post 2"
+	post2.Body = "This is the second post."
+
+	if err := newUser.Relation("posts").CreateMany([]interface{}{post1, post2}); err != nil {
 		log.Println("插入貼文錯誤:", err)
 		return
 	}
 
-	postModel.Model = &post2
-	err = postModel.Create()
-	if err != nil {
-		log.Println("插入貼文錯誤2:", err)
-		return
-	}
 	// 查詢並預載入 posts
-	var user examples.User
-	err = userModel.WhereID(userID).With("posts").First()
-	if err != nil {
-		log.Println(userModel.ToBson())
+	user := examples.NewUser()
+	if err := user.WhereID(userID).With("posts").First(); err != nil {
+		log.Println(user.ToBson())
 		fmt.Println("查詢錯誤:", err)
 		return
 	}
-	user = *(userModel.Model.(*examples.User)) // 轉型回 user
 
 	fmt.Printf("使用者: %s\n", user.Name)
 	for _, p := range user.Posts {
@@ -97,13 +53,11 @@ func RelationExample() {
 	}
 
 	// 查詢貼文並預載入使用者
-	var post examples.Post
-	err = postModel.WhereID(post1.ID).With("user").First()
-	if err != nil {
+	post := examples.NewPost()
+	if err := post.WhereID(post1.ID).With("user").First(); err != nil {
 		fmt.Println("查詢錯誤:", err)
 		return
 	}
-	post = *(postModel.Model.(*examples.Post)) // 轉型回 post
 
 	fmt.Printf("貼文: %s\n", post.Title)
 	fmt.Printf("  使用者: %s - %s\n", post.User.Name, post.User.Email)
@@ -0,0 +1,441 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrate.go - 版本化的結構與資料遷移：以 ID 註冊的 Go 遷移，執行紀錄存放於 godm_migrations 集合，
+// 並以鎖定文檔避免同時部署重複執行
+// Versioned schema and data migrations: Go migrations registered by ID, with the applied ones recorded in the
+// godm_migrations collection and a lock document keeping concurrent deploys from running them twice.
+//
+// 範例 / Example:
+//
+//	func init() {
+//		migrate.Register(migrate.Migration{
+//			ID: "20250101_backfill_user_status",
+//			Up: func(ctx context.Context, db *mongo.Database) error {
+//				_, err := db.Collection("users").UpdateMany(ctx, bson.M{"status": bson.M{"$exists": false}},
+//					bson.M{"$set": bson.M{"status": "active"}})
+//				return err
+//			},
+//			Down: func(ctx context.Context, db *mongo.Database) error {
+//				_, err := db.Collection("users").UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"status": ""}})
+//				return err
+//			},
+//		})
+//	}
+//
+//	m := migrate.New(client.Database("app"), &migrate.Options{Transaction: true})
+//	applied, err := m.Up(ctx)
+//
+// 遷移依 ID 的字串順序執行，建議以日期時間作為前綴。
+// Migrations run in the string order of their IDs; prefix them with a timestamp.
+
+// DefaultCollection - 預設存放執行紀錄與鎖定文檔的集合
+// Default collection holding the applied records and the lock document
+const DefaultCollection = "godm_migrations"
+
+// DefaultLockTTL - 預設的鎖定有效時間，程序中斷時鎖定會在此時間後失效
+// Default lifetime of the lock; a lock left by a crashed process expires after it
+const DefaultLockTTL = 10 * time.Minute
+
+// lockID - 鎖定文檔的 _id
+// The _id of the lock document
+const lockID = "__lock"
+
+// ErrLocked - 其他程序正在執行遷移時回傳
+// Returned while another process is running migrations
+var ErrLocked = errors.New("migrations are locked by another process")
+
+// ErrLockLost - 執行期間鎖定未能續期（例如已過期並被其他程序取得）時回傳，進行中的遷移會收到已取消的 context
+// Returned when the lock could not be renewed while running (it expired and another process took it, say);
+// the running migration sees its context cancelled
+var ErrLockLost = errors.New("migration lock was lost")
+
+// Migration - 一個版本化的遷移
+// A versioned migration
+type Migration struct {
+	ID   string                                              // 唯一且可排序的 ID / unique, sortable ID
+	Up   func(ctx context.Context, db *mongo.Database) error // 套用遷移 / applies the migration
+	Down func(ctx context.Context, db *mongo.Database) error // 撤銷遷移，可為 nil（無法回滾）/ reverts it; nil when it cannot be rolled back
+
+	// NoTransaction 為 true 時，即使 Options.Transaction 開啟也不在交易中執行（例如建立索引）
+	// When true the migration never runs in a transaction, even with Options.Transaction (index builds, say)
+	NoTransaction bool
+}
+
+// Options - Migrator 的選項
+// Options of a Migrator
+type Options struct {
+	Collection  string        // 執行紀錄的集合，預設 DefaultCollection / collection of the records, DefaultCollection by default
+	Transaction bool          // 在交易中執行每個遷移與其紀錄 / run every migration and its record in a transaction
+	LockTTL     time.Duration // 鎖定有效時間（每 LockTTL/3 續期），預設 DefaultLockTTL / lifetime of the lock (renewed every LockTTL/3), DefaultLockTTL by default
+	Owner       string        // 鎖定擁有者名稱，預設為主機名稱與 PID / lock owner, the host name and PID by default
+}
+
+// Status - 單一遷移的狀態
+// The state of a single migration
+type Status struct {
+	ID        string
+	Applied   bool
+	AppliedAt time.Time // 套用時間，未套用時為零值 / when it was applied, zero when pending
+	Missing   bool      // 已套用但程式中沒有註冊 / applied but not registered in the code
+}
+
+// record - godm_migrations 中的執行紀錄
+// An applied record in godm_migrations
+type record struct {
+	ID        string    `bson:"_id"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Migration{}
+)
+
+// Register 註冊遷移，通常在 init 中呼叫；ID 為空、Up 為 nil 或 ID 重複時 panic。
+// Register registers a migration, usually from init; it panics on an empty ID, a nil Up or a duplicate ID.
+func Register(m Migration) {
+	if m.ID == "" || m.ID == lockID {
+		panic(fmt.Sprintf("migrate: invalid migration ID %q", m.ID))
+	}
+	if m.Up == nil {
+		panic(fmt.Sprintf("migrate: migration %s has no Up", m.ID))
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[m.ID]; ok {
+		panic(fmt.Sprintf("migrate: migration %s registered twice", m.ID))
+	}
+	registry[m.ID] = m
+}
+
+// Registered 依 ID 排序回傳所有已註冊的遷移。
+// Registered returns every registered migration, sorted by ID.
+func Registered() []Migration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	migrations := make([]Migration, 0, len(registry))
+	for _, m := range registry {
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].ID < migrations[j].ID })
+	return migrations
+}
+
+// Migrator - 在資料庫上執行已註冊的遷移，由 New 建立
+// Runs the registered migrations on a database; created by New
+type Migrator struct {
+	db         *mongo.Database
+	opts       Options
+	migrations []Migration
+}
+
+// New 建立在 db 上執行的 Migrator，使用建立當下已註冊的遷移。
+// New creates a Migrator for db using the migrations registered at that point.
+func New(db *mongo.Database, opts ...*Options) *Migrator {
+	m := &Migrator{db: db, migrations: Registered()}
+	for _, opt := range opts {
+		if opt != nil {
+			m.opts = *opt
+		}
+	}
+	if m.opts.Collection == "" {
+		m.opts.Collection = DefaultCollection
+	}
+	if m.opts.LockTTL <= 0 {
+		m.opts.LockTTL = DefaultLockTTL
+	}
+	if m.opts.Owner == "" {
+		host, _ := os.Hostname()
+		m.opts.Owner = host + ":" + strconv.Itoa(os.Getpid())
+	}
+	return m
+}
+
+// Up 依序套用所有尚未套用的遷移，回傳本次套用的 ID；遇到錯誤時停止，已套用的遷移保留。
+// Up applies every pending migration in order and returns the IDs applied; it stops at the first error,
+// keeping the migrations applied before it.
+func (m *Migrator) Up(ctx context.Context) ([]string, error) {
+	var done []string
+	err := m.withLock(ctx, func(ctx context.Context) error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.ID]; ok {
+				continue
+			}
+			if err := m.run(ctx, migration, true); err != nil {
+				return err
+			}
+			done = append(done, migration.ID)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down 依套用時間由新到舊撤銷最後 n 個遷移，回傳本次撤銷的 ID。
+// Down reverts the last n applied migrations, most recently applied first, and returns the IDs reverted.
+func (m *Migrator) Down(ctx context.Context, n int) ([]string, error) {
+	var done []string
+	err := m.withLock(ctx, func(ctx context.Context) error {
+		last, err := m.lastApplied(ctx, n)
+		if err != nil {
+			return err
+		}
+		for _, migration := range last {
+			if err := m.run(ctx, migration, false); err != nil {
+				return err
+			}
+			done = append(done, migration.ID)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Redo 撤銷並重新套用最後套用的遷移，回傳其 ID；沒有已套用的遷移時回傳空字串。
+// Redo reverts and reapplies the most recently applied migration and returns its ID; empty when nothing is applied.
+func (m *Migrator) Redo(ctx context.Context) (string, error) {
+	var id string
+	err := m.withLock(ctx, func(ctx context.Context) error {
+		last, err := m.lastApplied(ctx, 1)
+		if err != nil || len(last) == 0 {
+			return err
+		}
+		if err := m.run(ctx, last[0], false); err != nil {
+			return err
+		}
+		if err := m.run(ctx, last[0], true); err != nil {
+			return err
+		}
+		id = last[0].ID
+		return nil
+	})
+	return id, err
+}
+
+// Status 依 ID 排序回傳所有已註冊與已套用遷移的狀態。
+// Status returns the state of every registered or applied migration, sorted by ID.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []Status
+	for _, migration := range m.migrations {
+		at, ok := applied[migration.ID]
+		statuses = append(statuses, Status{ID: migration.ID, Applied: ok, AppliedAt: at})
+		delete(applied, migration.ID)
+	}
+	for id, at := range applied {
+		statuses = append(statuses, Status{ID: id, Applied: true, AppliedAt: at, Missing: true})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses, nil
+}
+
+func (m *Migrator) collection() *mongo.Collection {
+	return m.db.Collection(m.opts.Collection)
+}
+
+// applied 回傳已套用遷移的 ID 與套用時間。
+// applied returns the IDs of the applied migrations with the time they were applied.
+func (m *Migrator) applied(ctx context.Context) (map[string]time.Time, error) {
+	cursor, err := m.collection().Find(ctx, bson.M{"_id": bson.M{"$ne": lockID}})
+	if err != nil {
+		return nil, fmt.Errorf("migration status error: %w", err)
+	}
+	defer cursor.Close(ctx)
+	var records []record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("migration status error: %w", err)
+	}
+	applied := make(map[string]time.Time, len(records))
+	for _, r := range records {
+		applied[r.ID] = r.AppliedAt
+	}
+	return applied, nil
+}
+
+// lastApplied 依套用時間由新到舊（時間相同時 ID 由大到小）回傳最後 n 個已套用的遷移；已套用但未註冊的遷移無法撤銷。
+// lastApplied returns the last n applied migrations, most recently applied first (highest ID first on equal
+// times); an applied migration that is not registered cannot be reverted.
+func (m *Migrator) lastApplied(ctx context.Context, n int) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(applied))
+	for id := range applied {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if !applied[ids[i]].Equal(applied[ids[j]]) {
+			return applied[ids[i]].After(applied[ids[j]])
+		}
+		return ids[i] > ids[j]
+	})
+	if n < 0 {
+		n = 0
+	}
+	if n < len(ids) {
+		ids = ids[:n]
+	}
+	byID := make(map[string]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		byID[migration.ID] = migration
+	}
+	last := make([]Migration, 0, len(ids))
+	for _, id := range ids {
+		migration, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("migration %s is applied but not registered", id)
+		}
+		if migration.Down == nil {
+			return nil, fmt.Errorf("migration %s has no Down", id)
+		}
+		last = append(last, migration)
+	}
+	return last, nil
+}
+
+// run 套用（up 為 true）或撤銷遷移並更新執行紀錄；開啟交易時兩者在同一個交易中。
+// run applies (up is true) or reverts a migration and updates its record; with transactions on, both happen in
+// one transaction.
+func (m *Migrator) run(ctx context.Context, migration Migration, up bool) error {
+	step := func(ctx context.Context) error {
+		if up {
+			if err := migration.Up(ctx, m.db); err != nil {
+				return err
+			}
+			_, err := m.collection().InsertOne(ctx, record{ID: migration.ID, AppliedAt: time.Now().UTC()})
+			return err
+		}
+		if err := migration.Down(ctx, m.db); err != nil {
+			return err
+		}
+		_, err := m.collection().DeleteOne(ctx, bson.M{"_id": migration.ID})
+		return err
+	}
+
+	var err error
+	if m.opts.Transaction && !migration.NoTransaction {
+		err = m.inTransaction(ctx, step)
+	} else {
+		err = step(ctx)
+	}
+	if err != nil {
+		direction := "up"
+		if !up {
+			direction = "down"
+		}
+		return fmt.Errorf("migration %s %s error: %w", migration.ID, direction, err)
+	}
+	return nil
+}
+
+// inTransaction 以驅動程式的 WithTransaction 在交易中執行 fn（暫時性錯誤時會重試）。
+// inTransaction runs fn in a transaction with the driver's WithTransaction, which retries transient errors.
+func (m *Migrator) inTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := m.db.Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("start session error: %w", err)
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+// withLock 取得鎖定文檔後執行 fn，結束後釋放；其他程序持有未過期的鎖定時回傳 ErrLocked。
+// 執行期間每 LockTTL/3 續期一次，續期失敗時取消傳給 fn 的 context 並回傳 ErrLockLost。
+// withLock runs fn while holding the lock document and releases it afterwards; it returns ErrLocked while
+// another process holds a lock that has not expired. The lock is renewed every LockTTL/3 while fn runs; when a
+// renewal finds the lock gone, the context given to fn is cancelled and ErrLockLost is returned.
+func (m *Migrator) withLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	now := time.Now().UTC()
+	filter := bson.M{"_id": lockID, "$or": []bson.M{
+		{"locked": false},
+		{"expires_at": bson.M{"$lt": now}},
+	}}
+	update := bson.M{"$set": bson.M{"locked": true, "owner": m.opts.Owner, "locked_at": now, "expires_at": now.Add(m.opts.LockTTL)}}
+	_, err := m.collection().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrLocked
+	}
+	if err != nil {
+		return fmt.Errorf("migration lock error: %w", err)
+	}
+
+	lockCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		m.renewLock(lockCtx, stop, cancel)
+	}()
+	fnErr := fn(lockCtx)
+	close(stop)
+	wg.Wait()
+	if errors.Is(context.Cause(lockCtx), ErrLockLost) {
+		if fnErr == nil {
+			return ErrLockLost
+		}
+		return fmt.Errorf("%w: %w", ErrLockLost, fnErr)
+	}
+
+	_, err = m.collection().UpdateOne(context.WithoutCancel(ctx), bson.M{"_id": lockID, "owner": m.opts.Owner},
+		bson.M{"$set": bson.M{"locked": false}})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return fmt.Errorf("migration unlock error: %w", err)
+	}
+	return nil
+}
+
+// renewLock 每 LockTTL/3 延長鎖定的有效時間直到 stop 關閉；鎖定已不屬於本程序時以 ErrLockLost 取消 ctx。
+// 續期時的其他錯誤會在下一次重試，鎖定在過期前仍然有效。
+// renewLock extends the lock every LockTTL/3 until stop is closed, cancelling ctx with ErrLockLost once the lock
+// no longer belongs to this process. Other renewal errors are retried on the next tick, as the lock still holds
+// until it expires.
+func (m *Migrator) renewLock(ctx context.Context, stop <-chan struct{}, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(m.opts.LockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			res, err := m.collection().UpdateOne(ctx, bson.M{"_id": lockID, "owner": m.opts.Owner, "locked": true},
+				bson.M{"$set": bson.M{"expires_at": time.Now().UTC().Add(m.opts.LockTTL)}})
+			if err == nil && res.MatchedCount == 0 {
+				cancel(ErrLockLost)
+				return
+			}
+		}
+	}
+}
@@ -3,6 +3,7 @@ package odm
 import (
 	"fmt"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -17,3 +18,32 @@ func (o *GODM) Aggregate(pipeline mongo.Pipeline, results interface{}) error {
 
 	return cursor.All(o.getContext(), results)
 }
+
+// relationPipeline 產生過濾條件、關聯存在條件、預載入關聯與聚合欄位的階段，不含排序與分頁。
+// relationPipeline builds the filter, relation condition, eager loading and aggregate stages, without sorting or paging.
+func (o *GODM) relationPipeline() []bson.M {
+	filters, aggregates := o.buildRelationQueryStages()
+	pipeline := []bson.M{
+		{"$match": o.buildFinalFilter()},
+	}
+	pipeline = append(pipeline, filters...)
+	pipeline = append(pipeline, o.buildRelationStages()...)
+	return append(pipeline, aggregates...)
+}
+
+// ToPipeline 回傳 All 在預載入關聯或使用關聯條件時所執行的聚合管道。
+// ToPipeline returns the aggregation pipeline All runs when relations are eager loaded or queried.
+func (o *GODM) ToPipeline() []bson.M {
+	pipeline := o.relationPipeline()
+
+	if len(o.SortFields) > 0 {
+		pipeline = append(pipeline, bson.M{"$sort": o.SortFields})
+	}
+	if o.SkipCount > 0 {
+		pipeline = append(pipeline, bson.M{"$skip": o.SkipCount})
+	}
+	if o.LimitCount > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": o.LimitCount})
+	}
+	return pipeline
+}
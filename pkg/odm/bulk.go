@@ -0,0 +1,355 @@
+package odm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// bulk.go - 混合寫入的 BulkWrite：以查詢的過濾語法排入新增、更新、取代與刪除，達到批次大小時自動送出
+// Mixed BulkWrite: queues inserts, updates, replaces and deletes using the builder's filter syntax and flushes
+// automatically once a batch is full.
+//
+// 範例 / Example:
+//
+//	res, err := user.Bulk(&odm.BulkOptions{BatchSize: 500}).
+//		InsertOne(newUser).
+//		UpdateOne(func(q *odm.GODM) { q.Where("email", "=", "a@example.com") }, bson.M{"name": "A"}).
+//		UpdateMany(func(q *odm.GODM) { q.Where("age", "<", 18) }, bson.M{"minor": true}).
+//		Upsert(func(q *odm.GODM) { q.WhereID(id) }, bson.M{"name": "B"}).
+//		DeleteMany(func(q *odm.GODM) { q.Where("deleted", "=", true) }).
+//		Execute()
+//
+// 更新內容與 Update 相同以 $set 寫入並排除關聯欄位；Bulk 不觸發觀察者事件，
+// 也不會在 savepoint 範圍（PropagationNested）中記錄補償動作，需要時請以 OnRollback 登記。
+// Updates are written with $set without the relation fields, like Update. Bulk fires no observer events and
+// records no compensating actions in a savepoint scope (PropagationNested); register them with OnRollback when needed.
+
+// DefaultBulkBatchSize - 預設的批次大小，排入的操作達到此數量時自動送出
+// Default batch size; queued operations are flushed automatically once this many are pending
+const DefaultBulkBatchSize = 1000
+
+// BulkOptions - Bulk 的選項
+// Options of Bulk
+type BulkOptions struct {
+	// Unordered 為 true 時以無序 BulkWrite 送出：失敗的操作不會中止其餘操作
+	// When true batches are unordered: a failed operation does not stop the others
+	Unordered bool
+
+	// BatchSize 為每批的操作數量，零值時使用 DefaultBulkBatchSize
+	// Number of operations per batch; zero uses DefaultBulkBatchSize
+	BatchSize int
+}
+
+// BulkResult - 所有批次合併後的結果
+// The merged result of every batch
+type BulkResult struct {
+	InsertedCount int64
+	MatchedCount  int64
+	ModifiedCount int64
+	DeletedCount  int64
+	UpsertedCount int64
+
+	// UpsertedIDs 以操作的排入順序（從 0 開始）對應 upsert 新增的 _id
+	// The _id inserted by each upsert, keyed by the operation's position in the queue (starting at 0)
+	UpsertedIDs map[int]interface{}
+}
+
+// BulkOperationError - 單一操作的寫入錯誤，Index 為操作的排入順序（從 0 開始）
+// The write error of a single operation; Index is the operation's position in the queue (starting at 0)
+type BulkOperationError struct {
+	Index   int
+	Code    int
+	Message string
+	Err     error // 有序模式下因先前失敗而未執行時為 ErrWriteSkipped / ErrWriteSkipped when not run after an earlier failure in ordered mode
+}
+
+func (e BulkOperationError) Error() string {
+	return fmt.Sprintf("operation %d: %s (code %d)", e.Index, e.Message, e.Code)
+}
+
+// Unwrap 回傳 Err，供 errors.Is(err, ErrWriteSkipped) 使用。
+// Unwrap returns Err, for errors.Is(err, ErrWriteSkipped).
+func (e BulkOperationError) Unwrap() error {
+	return e.Err
+}
+
+// BulkError - 有操作寫入失敗時 Execute 回傳的錯誤；有序模式下失敗之後未執行的操作以 ErrWriteSkipped 列入
+// Returned by Execute when some operations failed; in ordered mode the operations that did not run after a
+// failure are listed with ErrWriteSkipped
+type BulkError struct {
+	Failures []BulkOperationError
+}
+
+func (e *BulkError) Error() string {
+	messages := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		messages[i] = f.Error()
+	}
+	return fmt.Sprintf("bulk write: %d operation(s) failed: %s", len(e.Failures), strings.Join(messages, "; "))
+}
+
+// Unwrap 回傳每個失敗操作的錯誤，供 errors.As 使用。
+// Unwrap returns the error of every failed operation, for errors.As.
+func (e *BulkError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f
+	}
+	return errs
+}
+
+// BulkBuilder - 排入混合的寫入操作並分批送出，由 Bulk 建立
+// Queues mixed write operations and sends them in batches; created by Bulk
+type BulkBuilder struct {
+	parent    *GODM
+	ordered   bool
+	batchSize int
+
+	pending  []mongo.WriteModel
+	flushed  int // 已送出的操作數量 / number of operations already sent
+	stopped  bool
+	result   *BulkResult
+	failures []BulkOperationError
+	err      error
+}
+
+// Bulk 建立此查詢集合上的混合寫入，操作以 Execute（或達到批次大小時自動）送出。
+// Bulk starts a mixed write on this builder's collection; operations are sent by Execute, or automatically
+// whenever a batch is full.
+func (o *GODM) Bulk(opts ...*BulkOptions) *BulkBuilder {
+	b := &BulkBuilder{parent: o, ordered: true, batchSize: DefaultBulkBatchSize, result: &BulkResult{UpsertedIDs: map[int]interface{}{}}}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		b.ordered = !opt.Unordered
+		if opt.BatchSize > 0 {
+			b.batchSize = opt.BatchSize
+		}
+	}
+	return b
+}
+
+// InsertOne 排入新增 model 的操作，關聯欄位不會被寫入。
+// InsertOne queues an insert of model; relation fields are left out.
+func (b *BulkBuilder) InsertOne(model interface{}) *BulkBuilder {
+	doc, err := b.parent.insertDocument(model)
+	if err != nil {
+		return b.fail(err)
+	}
+	return b.add(mongo.NewInsertOneModel().SetDocument(doc))
+}
+
+// UpdateOne 排入以 $set 更新第一個符合 fn 過濾條件的文檔的操作。
+// UpdateOne queues a $set update of the first document matching the filter built by fn.
+func (b *BulkBuilder) UpdateOne(fn func(q *GODM), updates bson.M) *BulkBuilder {
+	filter, err := b.filter(fn)
+	if err != nil {
+		return b.fail(err)
+	}
+	return b.add(mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(b.set(updates)))
+}
+
+// UpdateMany 排入以 $set 更新所有符合 fn 過濾條件的文檔的操作。
+// UpdateMany queues a $set update of every document matching the filter built by fn.
+func (b *BulkBuilder) UpdateMany(fn func(q *GODM), updates bson.M) *BulkBuilder {
+	filter, err := b.filter(fn)
+	if err != nil {
+		return b.fail(err)
+	}
+	return b.add(mongo.NewUpdateManyModel().SetFilter(filter).SetUpdate(b.set(updates)))
+}
+
+// Upsert 排入以 $set 更新第一個符合 fn 過濾條件的文檔、不存在時新增的操作。
+// Upsert queues a $set update of the first document matching the filter built by fn, inserting one when none matches.
+func (b *BulkBuilder) Upsert(fn func(q *GODM), updates bson.M) *BulkBuilder {
+	filter, err := b.filter(fn)
+	if err != nil {
+		return b.fail(err)
+	}
+	return b.add(mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(b.set(updates)).SetUpsert(true))
+}
+
+// ReplaceOne 排入以 replacement 取代第一個符合 fn 過濾條件的文檔的操作，關聯欄位不會被寫入。
+// ReplaceOne queues a replacement of the first document matching the filter built by fn; relation fields are left out.
+func (b *BulkBuilder) ReplaceOne(fn func(q *GODM), replacement interface{}) *BulkBuilder {
+	return b.replace(fn, replacement, false)
+}
+
+// UpsertReplace 與 ReplaceOne 相同，但沒有符合的文檔時新增 replacement。
+// UpsertReplace is ReplaceOne that inserts replacement when no document matches.
+func (b *BulkBuilder) UpsertReplace(fn func(q *GODM), replacement interface{}) *BulkBuilder {
+	return b.replace(fn, replacement, true)
+}
+
+// DeleteOne 排入刪除第一個符合 fn 過濾條件的文檔的操作。
+// DeleteOne queues a delete of the first document matching the filter built by fn.
+func (b *BulkBuilder) DeleteOne(fn func(q *GODM)) *BulkBuilder {
+	filter, err := b.filter(fn)
+	if err != nil {
+		return b.fail(err)
+	}
+	return b.add(mongo.NewDeleteOneModel().SetFilter(filter))
+}
+
+// DeleteMany 排入刪除所有符合 fn 過濾條件的文檔的操作。
+// DeleteMany queues a delete of every document matching the filter built by fn.
+func (b *BulkBuilder) DeleteMany(fn func(q *GODM)) *BulkBuilder {
+	filter, err := b.filter(fn)
+	if err != nil {
+		return b.fail(err)
+	}
+	return b.add(mongo.NewDeleteManyModel().SetFilter(filter))
+}
+
+// Pending 回傳尚未送出的寫入操作。
+// Pending returns the write operations not sent yet.
+func (b *BulkBuilder) Pending() []mongo.WriteModel {
+	return b.pending
+}
+
+// Execute 送出剩餘的操作並回傳合併後的結果；有操作寫入失敗時另外回傳 *BulkError（結果仍包含成功的部分）。
+// 寫入關注錯誤（write concern error）與其他錯誤會與 *BulkError 一併回傳。
+// Execute sends the remaining operations and returns the merged result. When some operations failed it also
+// returns a *BulkError; the result still covers the operations that succeeded. A write concern error, or any
+// other error, is returned together with the *BulkError.
+func (b *BulkBuilder) Execute() (*BulkResult, error) {
+	if b.err == nil {
+		b.flush()
+	}
+	if len(b.failures) == 0 {
+		return b.result, b.err
+	}
+	bulkErr := &BulkError{Failures: b.failures}
+	if b.err != nil {
+		return b.result, errors.Join(bulkErr, b.err)
+	}
+	return b.result, bulkErr
+}
+
+func (b *BulkBuilder) fail(err error) *BulkBuilder {
+	if b.err == nil {
+		b.err = fmt.Errorf("bulk write error: %w", err)
+	}
+	return b
+}
+
+// add 排入操作，達到批次大小時送出。
+// add queues an operation and flushes once the batch is full.
+func (b *BulkBuilder) add(model mongo.WriteModel) *BulkBuilder {
+	if b.err != nil {
+		return b
+	}
+	b.pending = append(b.pending, model)
+	if len(b.pending) >= b.batchSize {
+		b.flush()
+	}
+	return b
+}
+
+func (b *BulkBuilder) replace(fn func(q *GODM), replacement interface{}, upsert bool) *BulkBuilder {
+	filter, err := b.filter(fn)
+	if err != nil {
+		return b.fail(err)
+	}
+	doc, err := b.parent.insertDocument(replacement)
+	if err != nil {
+		return b.fail(err)
+	}
+	return b.add(mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(doc).SetUpsert(upsert))
+}
+
+// set 以 $set 包裝更新內容並排除關聯欄位。
+// set wraps the updates in $set without the relation fields.
+func (b *BulkBuilder) set(updates bson.M) bson.M {
+	return bson.M{"$set": b.parent.withoutRelationFields(updates)}
+}
+
+// filter 以 fn 在本模型的空白查詢上建立過濾條件，fn 為 nil 時符合所有文檔。
+// 關聯條件（Has、WhereHas、DoesntHave 等）需要聚合，無法用於批次寫入；條件無效（例如 WhereID 的 ID）時回傳錯誤。
+// filter builds a filter by running fn on an empty builder of this model; a nil fn matches every document.
+// Relation conditions (Has, WhereHas, DoesntHave, ...) need an aggregation and cannot filter bulk writes; an
+// invalid condition (such as the ID given to WhereID) returns an error.
+func (b *BulkBuilder) filter(fn func(q *GODM)) (bson.D, error) {
+	q := &GODM{Model: b.parent.Model, Filter: bson.D{}}
+	if fn != nil {
+		fn(q)
+	}
+	if q.queryErr != nil {
+		return nil, q.queryErr
+	}
+	if len(q.relationQueries) > 0 {
+		return nil, errors.New("relation conditions cannot filter bulk writes")
+	}
+	return q.buildFinalFilter(), nil
+}
+
+// flush 送出目前的批次並合併結果；有序模式下出現失敗後，之後的操作不再送出，並以 ErrWriteSkipped 列入失敗。
+// flush sends the current batch and merges its result. In ordered mode, once an operation has failed the later
+// ones are never sent and are reported as failures with ErrWriteSkipped.
+func (b *BulkBuilder) flush() {
+	batch := b.pending
+	base := b.flushed
+	b.pending = nil
+	b.flushed += len(batch)
+	if len(batch) == 0 {
+		return
+	}
+	if b.stopped {
+		b.skip(base, b.flushed)
+		return
+	}
+
+	o := b.parent
+	res, err := o.Collection.BulkWrite(o.getContext(), batch, options.BulkWrite().SetOrdered(b.ordered))
+	var bulkErr mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bulkErr) {
+		b.fail(err)
+		return
+	}
+	// 寫入錯誤時驅動程式仍會回傳成功部分的結果 / on write errors the driver still returns the partial result
+	b.merge(res, base)
+	if err == nil {
+		return
+	}
+	for _, we := range bulkErr.WriteErrors {
+		b.failures = append(b.failures, BulkOperationError{Index: base + we.Index, Code: we.Code, Message: we.Message})
+	}
+	if b.ordered && len(bulkErr.WriteErrors) > 0 {
+		// 有序模式在第一個失敗處停止 / ordered mode stops at the first failure
+		b.stopped = true
+		b.skip(base+bulkErr.WriteErrors[0].Index+1, b.flushed)
+	}
+	if bulkErr.WriteConcernError != nil {
+		b.fail(bulkErr.WriteConcernError)
+	}
+}
+
+// skip 將位置 from 到 to（不含）的操作以 ErrWriteSkipped 列入失敗。
+// skip reports the operations at positions from up to (excluding) to as failures with ErrWriteSkipped.
+func (b *BulkBuilder) skip(from, to int) {
+	for i := from; i < to; i++ {
+		b.failures = append(b.failures, BulkOperationError{Index: i, Message: ErrWriteSkipped.Error(), Err: ErrWriteSkipped})
+	}
+}
+
+// merge 將一個批次的結果併入總結果，upsert 的位置加上批次的起始位置。
+// merge adds the result of one batch to the summary, offsetting upsert positions by the batch's start.
+func (b *BulkBuilder) merge(res *mongo.BulkWriteResult, base int) {
+	if res == nil {
+		return
+	}
+	b.result.InsertedCount += res.InsertedCount
+	b.result.MatchedCount += res.MatchedCount
+	b.result.ModifiedCount += res.ModifiedCount
+	b.result.DeletedCount += res.DeletedCount
+	b.result.UpsertedCount += res.UpsertedCount
+	for i, id := range res.UpsertedIDs {
+		b.result.UpsertedIDs[base+int(i)] = id
+	}
+}
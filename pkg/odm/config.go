@@ -2,7 +2,6 @@ package odm
 
 import (
 	"reflect"
-	"strings"
 
 	"go.mongodb.org/mongo-driver/bson"
 )
@@ -16,13 +15,12 @@ func (o *GODM) Use(model interface{}) *GODM {
 	} else if o.DBName == "" {
 		o.DBName = DBName
 	}
-	modelType := reflect.TypeOf(model).Elem()
-	defaultCollectionName := strings.ToLower(modelType.Name()) + "s"
+	collectionName := defaultCollectionName(reflect.TypeOf(model))
 	if o.CollectionName != "" {
-		defaultCollectionName = o.CollectionName
+		collectionName = o.CollectionName
 	}
 	o.Model = model
-	o.Collection = MongoClient.Database(o.DBName).Collection(defaultCollectionName)
+	o.Collection = MongoClient.Database(o.DBName).Collection(collectionName)
 	o.Filter = bson.D{}
 	o.OrFilter = []bson.M{}
 	return o
@@ -1,7 +1,9 @@
 package odm
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -11,72 +13,173 @@ import (
 // crud.go - 封裝對 MongoDB 的基本操作（Create、Read、Update、Delete）與 Observer 整合
 // Encapsulates basic MongoDB operations (Create, Read, Update, Delete) with integrated observer support.
 
-// Create inserts the current model as a document into the collection.
-// 創建將當前模型作為文檔插入集合中。
-func (o *GODM) Create() error {
-	if m, ok := o.Model.(ObservedModel); ok {
-		o.Observers = append(o.Observers, m.Observers()...)
+// CreateOptions - Create 的選項
+// Options of Create
+type CreateOptions struct {
+	Relations []string // 在同一個交易中一併保存這些關聯欄位中的模型，規則同 SaveWithRelations / relations whose models are persisted in the same transaction, following SaveWithRelations
+}
+
+// Create inserts the current model as a document into the collection; relation fields are left out.
+// The generated _id is written back to the model before the created / saved events fire.
+// With CreateOptions.Relations the models held in those relation fields are persisted as well, in one transaction.
+// 創建將當前模型作為文檔插入集合中；關聯欄位不會被寫入。
+// 產生的 _id 會在觸發 created / saved 事件前寫回模型。
+// 指定 CreateOptions.Relations 時，會在同一個交易中一併保存這些關聯欄位中的模型。
+func (o *GODM) Create(opts ...*CreateOptions) error {
+	var relations []string
+	for _, opt := range opts {
+		if opt != nil {
+			relations = append(relations, opt.Relations...)
+		}
 	}
-	if err := o.notifyCreating(); err != nil {
-		return fmt.Errorf("observer creating error: %w", err)
+	if len(relations) > 0 {
+		return o.persistWithRelations(relations, func(q *GODM) error { return q.Create() })
 	}
 
-	_, err := o.Collection.InsertOne(o.getContext(), o.Model)
+	if err := o.fire("saving", o.Model); err != nil {
+		return err
+	}
+	if err := o.fire("creating", o.Model); err != nil {
+		return err
+	}
+
+	doc, err := o.insertDocument(o.Model)
+	if err != nil {
+		return fmt.Errorf("create error: %w", err)
+	}
+	res, err := o.Collection.InsertOne(o.getContext(), doc)
 	if err != nil {
 		return fmt.Errorf("create error: %w", err)
 	}
+	setInsertedID(o.Model, res.InsertedID)
+	o.compensateInsert(res.InsertedID)
 
-	if err := o.notifyCreated(); err != nil {
-		return fmt.Errorf("observer created error: %w", err)
+	if err := o.fire("created", o.Model); err != nil {
+		return err
+	}
+	if err := o.fire("saved", o.Model); err != nil {
+		return err
 	}
 	return nil
 }
 
-// BulkCreate inserts multiple documents into the collection.
-// BulkCreate 將多個文檔插入集合中。
-func (o *GODM) BulkCreate(models []interface{}) error {
+// BulkCreate inserts multiple documents into the collection; the generated IDs are written back to the models.
+// It behaves like BulkCreateWithIDs without returning the IDs.
+// BulkCreate 將多個文檔插入集合中，產生的 ID 會寫回模型；行為與 BulkCreateWithIDs 相同，但不回傳 ID。
+func (o *GODM) BulkCreate(models []interface{}, opts ...*BulkOptions) error {
+	_, err := o.BulkCreateWithIDs(models, opts...)
+	return err
+}
+
+// BulkCreateWithIDs inserts multiple documents into the collection and returns their _id values, in the order of
+// models; the generated IDs are written back to the models as well.
+// Fires bulkCreating / bulkCreated and, depending on BulkEvents, creating / created for every model.
+// Only Unordered of opts applies. When some inserts fail a *BulkCreateError reports their indexes; the returned IDs
+// then hold nil for every model that was not inserted, and the after-events still fire for the inserted models
+// (bulkCreated with Affected set to how many were inserted).
+// BulkCreateWithIDs 將多個文檔插入集合中，並依 models 的順序回傳它們的 _id；產生的 ID 也會寫回模型。
+// 依 BulkEvents 設定觸發 bulkCreating / bulkCreated，以及每個模型的 creating / created。
+// opts 只有 Unordered 生效。部分新增失敗時回傳 *BulkCreateError 指出失敗的索引，未新增的模型在回傳的 ID 中為 nil，
+// 已新增的模型仍會觸發後置事件（bulkCreated 的 Affected 為成功新增的數量）。
+func (o *GODM) BulkCreateWithIDs(models []interface{}, opts ...*BulkOptions) ([]interface{}, error) {
 	if len(models) == 0 {
-		return nil
+		return nil, nil
 	}
-	_, err := o.Collection.InsertMany(o.getContext(), models)
-	if err != nil {
-		return fmt.Errorf("bulk create error: %w", err)
+	event := &BulkEvent{Models: models}
+	if err := o.notifyBulk("bulkCreating", event); err != nil {
+		return nil, fmt.Errorf("observer bulkCreating error: %w", err)
+	}
+	if o.BulkEvents == BulkEventsPerModel {
+		for _, model := range models {
+			if err := o.fire("creating", model); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	docs := make([]interface{}, len(models))
+	for i, model := range models {
+		doc, err := o.insertDocument(model)
+		if err != nil {
+			return nil, fmt.Errorf("bulk create error: %w", err)
+		}
+		docs[i] = doc
+	}
+	ordered := true
+	for _, opt := range opts {
+		if opt != nil {
+			ordered = !opt.Unordered
+		}
+	}
+	res, err := o.Collection.InsertMany(o.getContext(), docs, options.InsertMany().SetOrdered(ordered))
+	if res == nil {
+		return nil, fmt.Errorf("bulk create error: %w", err)
+	}
+	ids, failures := insertedIDs(len(models), res.InsertedIDs, err, ordered)
+	var inserted []interface{}
+	for i, id := range ids {
+		if id != nil {
+			setInsertedID(models[i], id)
+			inserted = append(inserted, id)
+		}
+	}
+	o.compensateInsert(inserted...)
+	var createErr error
+	if len(failures) > 0 {
+		createErr = &BulkCreateError{Failures: failures, Inserted: len(inserted)}
+	} else if err != nil {
+		return ids, fmt.Errorf("bulk create error: %w", err)
+	}
+	event.Affected = int64(len(inserted))
+
+	// 部分失敗時只為成功新增的模型觸發 created / on a partial failure only the inserted models fire created
+	if err := o.bulkCreated(models, ids, event); err != nil {
+		if createErr != nil {
+			return ids, errors.Join(createErr, err)
+		}
+		return ids, err
+	}
+	return ids, createErr
+}
+
+// bulkCreated 為已新增（ids 中不為 nil）的模型觸發 created，再觸發 bulkCreated。
+// bulkCreated fires created for the models that were inserted (whose id is not nil), then bulkCreated.
+func (o *GODM) bulkCreated(models, ids []interface{}, event *BulkEvent) error {
+	if o.BulkEvents == BulkEventsPerModel {
+		for i, model := range models {
+			if ids[i] == nil {
+				continue
+			}
+			if err := o.fire("created", model); err != nil {
+				return err
+			}
+		}
+	}
+	if err := o.notifyBulk("bulkCreated", event); err != nil {
+		return fmt.Errorf("observer bulkCreated error: %w", err)
 	}
 	return nil
 }
 
+// SetBulkEventMode sets how bulk operations fire events.
+// SetBulkEventMode 設定批次操作觸發事件的方式。
+func (o *GODM) SetBulkEventMode(mode BulkEventMode) *GODM {
+	o.BulkEvents = mode
+	return o
+}
+
 // First retrieves the first document matching the filter.
 // First 根據過濾條件檢索第一個文檔。
 func (o *GODM) First() error {
-	if len(o.WithRelations) > 0 {
-		pipeline := []bson.M{
-			{"$match": o.buildFinalFilter()},
+	if o.usesAggregation() {
+		if err := o.checkRelationQueries(); err != nil {
+			return err
 		}
-
-		for _, rel := range o.WithRelations {
-			conf, ok := o.RelationConfigs[rel]
-			if !ok {
-				continue
-			}
-			pipeline = append(pipeline, bson.M{
-				"$lookup": bson.M{
-					"from":         conf.From,
-					"localField":   conf.LocalField,
-					"foreignField": conf.ForeignField,
-					"as":           conf.As,
-				},
-			})
-			if !conf.IsArray {
-				pipeline = append(pipeline, bson.M{
-					"$unwind": bson.M{
-						"path":                       "$" + conf.As,
-						"preserveNullAndEmptyArrays": true,
-					},
-				})
-			}
+		if err := o.checkLookupDatabases(); err != nil {
+			return err
 		}
+		pipeline := append(o.relationPipeline(), bson.M{"$limit": 1})
 
-		pipeline = append(pipeline, bson.M{"$limit": 1})
 		cursor, err := o.Collection.Aggregate(o.getContext(), pipeline)
 		if err != nil {
 			return fmt.Errorf("aggregate error: %w", err)
@@ -87,7 +190,7 @@ func (o *GODM) First() error {
 			if err := cursor.Decode(o.Model); err != nil {
 				return fmt.Errorf("decode error: %w (type = %T)", err, o.Model)
 			}
-			return nil
+			return o.afterRetrieveAll(o.Model)
 		}
 		return mongo.ErrNoDocuments
 	}
@@ -97,47 +200,190 @@ func (o *GODM) First() error {
 		findOptions.SetProjection(o.Projection)
 	}
 
-	return o.Collection.FindOne(o.getContext(), o.buildFinalFilter()).Decode(o.Model)
+	if err := o.Collection.FindOne(o.getContext(), o.buildFinalFilter(), findOptions).Decode(o.Model); err != nil {
+		return err
+	}
+	return o.afterRetrieveAll(o.Model)
 }
 
 // Update applies the updates to the first document matching the filter.
 // Update 將更新應用於第一個符合過濾條件的文檔。
 func (o *GODM) Update(updates bson.M) error {
-	if m, ok := o.Model.(ObservedModel); ok {
-		o.Observers = append(o.Observers, m.Observers()...)
+	if err := o.fire("saving", o.Model); err != nil {
+		return err
 	}
-	if err := o.notifyUpdating(); err != nil {
-		return fmt.Errorf("observer updating error: %w", err)
+	if err := o.fire("updating", o.Model); err != nil {
+		return err
 	}
 
-	_, err := o.Collection.UpdateOne(o.getContext(), o.buildFinalFilter(), bson.M{"$set": updates})
+	snapshot, err := o.snapshot(o.buildFinalFilter(), false)
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	_, err = o.Collection.UpdateOne(o.getContext(), o.buildFinalFilter(), bson.M{"$set": o.withoutRelationFields(updates)})
 	if err != nil {
 		return fmt.Errorf("update error: %w", err)
 	}
+	o.compensateRestore(snapshot)
 
-	if err := o.notifyUpdated(); err != nil {
-		return fmt.Errorf("observer updated error: %w", err)
+	if err := o.fire("updated", o.Model); err != nil {
+		return err
+	}
+	if err := o.fire("saved", o.Model); err != nil {
+		return err
 	}
 	return nil
 }
 
-// Delete removes the first document matching the filter.
-// Delete 刪除第一個符合過濾條件的文檔。
+// Upsert applies the updates to the first document matching the filter, inserting it when none matches.
+// Upsert 將更新應用於第一個符合過濾條件的文檔，若不存在則新增。
+func (o *GODM) Upsert(updates bson.M) error {
+	if err := o.fire("saving", o.Model); err != nil {
+		return err
+	}
+	if err := o.fire("upserting", o.Model); err != nil {
+		return err
+	}
+
+	snapshot, err := o.snapshot(o.buildFinalFilter(), false)
+	if err != nil {
+		return fmt.Errorf("upsert error: %w", err)
+	}
+	opts := options.Update().SetUpsert(true)
+	res, err := o.Collection.UpdateOne(o.getContext(), o.buildFinalFilter(), bson.M{"$set": o.withoutRelationFields(updates)}, opts)
+	if err != nil {
+		return fmt.Errorf("upsert error: %w", err)
+	}
+	if res.UpsertedID != nil {
+		o.compensateInsert(res.UpsertedID)
+	} else {
+		o.compensateRestore(snapshot)
+	}
+
+	if err := o.fire("upserted", o.Model); err != nil {
+		return err
+	}
+	if err := o.fire("saved", o.Model); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Delete removes the first document matching the filter, applying the OnDelete actions of its relations in a transaction.
+// Delete 刪除第一個符合過濾條件的文檔，並在交易中執行關聯的 OnDelete 參照動作。
 func (o *GODM) Delete() error {
-	if m, ok := o.Model.(ObservedModel); ok {
-		o.Observers = append(o.Observers, m.Observers()...)
+	if relations := o.deleteActions(); len(relations) > 0 {
+		return o.withinTransaction(func(q *GODM) error {
+			return q.deleteOne(relations)
+		})
+	}
+	return o.deleteOne(nil)
+}
+
+// deleteOne 刪除第一個符合條件的文檔，並先對其執行 relations 的參照動作。
+// deleteOne deletes the first matching document after running the referential actions of relations on it.
+func (o *GODM) deleteOne(relations []string) error {
+	if err := o.fire("deleting", o.Model); err != nil {
+		return err
 	}
-	if err := o.notifyDeleting(); err != nil {
-		return fmt.Errorf("observer deleting error: %w", err)
+
+	filter := interface{}(o.buildFinalFilter())
+	if len(relations) > 0 {
+		parents, err := o.deleteParents(filter, true)
+		if err != nil {
+			return fmt.Errorf("delete error: %w", err)
+		}
+		if err := o.applyDeleteActions(parents, relations); err != nil {
+			return err
+		}
+		if len(parents) > 0 {
+			filter = bson.D{{Key: "_id", Value: parents[0]["_id"]}}
+		}
 	}
 
-	_, err := o.Collection.DeleteOne(o.getContext(), o.buildFinalFilter())
+	snapshot, err := o.snapshot(filter, false)
 	if err != nil {
 		return fmt.Errorf("delete error: %w", err)
 	}
+	if _, err := o.Collection.DeleteOne(o.getContext(), filter); err != nil {
+		return fmt.Errorf("delete error: %w", err)
+	}
+	o.compensateRestore(snapshot)
 
-	if err := o.notifyDeleted(); err != nil {
-		return fmt.Errorf("observer deleted error: %w", err)
+	if err := o.fire("deleted", o.Model); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateMany applies the updates to every document matching the filter and fires bulkUpdating / bulkUpdated.
+// UpdateMany 將更新應用於所有符合過濾條件的文檔，並觸發 bulkUpdating / bulkUpdated。
+func (o *GODM) UpdateMany(updates bson.M) error {
+	event := &BulkEvent{Filter: o.buildFinalFilter(), Update: o.withoutRelationFields(updates)}
+	if err := o.notifyBulk("bulkUpdating", event); err != nil {
+		return fmt.Errorf("observer bulkUpdating error: %w", err)
+	}
+
+	snapshot, err := o.snapshot(event.Filter, true)
+	if err != nil {
+		return fmt.Errorf("update many error: %w", err)
+	}
+	res, err := o.Collection.UpdateMany(o.getContext(), event.Filter, bson.M{"$set": event.Update})
+	if err != nil {
+		return fmt.Errorf("update many error: %w", err)
+	}
+	o.compensateRestore(snapshot)
+	event.Affected = res.ModifiedCount
+
+	if err := o.notifyBulk("bulkUpdated", event); err != nil {
+		return fmt.Errorf("observer bulkUpdated error: %w", err)
+	}
+	return nil
+}
+
+// DeleteMany removes every document matching the filter and fires bulkDeleting / bulkDeleted;
+// the OnDelete actions of its relations run in the same transaction.
+// DeleteMany 刪除所有符合過濾條件的文檔，並觸發 bulkDeleting / bulkDeleted；關聯的 OnDelete 參照動作在同一個交易中執行。
+func (o *GODM) DeleteMany() error {
+	if relations := o.deleteActions(); len(relations) > 0 {
+		return o.withinTransaction(func(q *GODM) error {
+			return q.deleteMany(relations)
+		})
+	}
+	return o.deleteMany(nil)
+}
+
+// deleteMany 刪除所有符合條件的文檔，並先對它們執行 relations 的參照動作。
+// deleteMany deletes every matching document after running the referential actions of relations on them.
+func (o *GODM) deleteMany(relations []string) error {
+	event := &BulkEvent{Filter: o.buildFinalFilter()}
+	if err := o.notifyBulk("bulkDeleting", event); err != nil {
+		return fmt.Errorf("observer bulkDeleting error: %w", err)
+	}
+
+	if len(relations) > 0 {
+		parents, err := o.deleteParents(event.Filter, false)
+		if err != nil {
+			return fmt.Errorf("delete many error: %w", err)
+		}
+		if err := o.applyDeleteActions(parents, relations); err != nil {
+			return err
+		}
+	}
+
+	snapshot, err := o.snapshot(event.Filter, true)
+	if err != nil {
+		return fmt.Errorf("delete many error: %w", err)
+	}
+	res, err := o.Collection.DeleteMany(o.getContext(), event.Filter)
+	if err != nil {
+		return fmt.Errorf("delete many error: %w", err)
+	}
+	o.compensateRestore(snapshot)
+	event.Affected = res.DeletedCount
+
+	if err := o.notifyBulk("bulkDeleted", event); err != nil {
+		return fmt.Errorf("observer bulkDeleted error: %w", err)
 	}
 	return nil
 }
@@ -145,6 +391,15 @@ func (o *GODM) Delete() error {
 // Count returns the number of documents matching the filter.
 // Count 返回符合過濾條件的文檔數量。
 func (o *GODM) Count() (int64, error) {
+	if err := o.checkRelationQueries(); err != nil {
+		return 0, err
+	}
+	if filters, _ := o.buildRelationQueryStages(); len(filters) > 0 {
+		if err := o.checkLookupDatabases(); err != nil {
+			return 0, err
+		}
+		return o.countRelationQuery(filters)
+	}
 	count, err := o.Collection.CountDocuments(o.getContext(), o.buildFinalFilter())
 	if err != nil {
 		return 0, fmt.Errorf("count error: %w", err)
@@ -155,51 +410,23 @@ func (o *GODM) Count() (int64, error) {
 // All retrieves all documents matching the filter.
 // All 根據過濾條件檢索所有文檔。
 func (o *GODM) All(results interface{}) error {
-	if len(o.WithRelations) > 0 {
-		pipeline := []bson.M{
-			{"$match": o.buildFinalFilter()},
+	if o.usesAggregation() {
+		if err := o.checkRelationQueries(); err != nil {
+			return err
 		}
-
-		for _, rel := range o.WithRelations {
-			conf, ok := o.RelationConfigs[rel]
-			if !ok {
-				continue
-			}
-			pipeline = append(pipeline, bson.M{
-				"$lookup": bson.M{
-					"from":         conf.From,
-					"localField":   conf.LocalField,
-					"foreignField": conf.ForeignField,
-					"as":           conf.As,
-				},
-			})
-			if !conf.IsArray {
-				pipeline = append(pipeline, bson.M{
-					"$unwind": bson.M{
-						"path":                       "$" + conf.As,
-						"preserveNullAndEmptyArrays": true,
-					},
-				})
-			}
+		if err := o.checkLookupDatabases(); err != nil {
+			return err
 		}
-
-		if len(o.SortFields) > 0 {
-			pipeline = append(pipeline, bson.M{"$sort": o.SortFields})
-		}
-		if o.SkipCount > 0 {
-			pipeline = append(pipeline, bson.M{"$skip": o.SkipCount})
-		}
-		if o.LimitCount > 0 {
-			pipeline = append(pipeline, bson.M{"$limit": o.LimitCount})
-		}
-
-		cursor, err := o.Collection.Aggregate(o.getContext(), pipeline)
+		cursor, err := o.Collection.Aggregate(o.getContext(), o.ToPipeline())
 		if err != nil {
 			return fmt.Errorf("aggregate error: %w", err)
 		}
 		defer cursor.Close(o.getContext())
 
-		return cursor.All(o.getContext(), results)
+		if err := cursor.All(o.getContext(), results); err != nil {
+			return err
+		}
+		return o.afterRetrieveAll(results)
 	}
 
 	// fallback to regular Find
@@ -222,7 +449,57 @@ func (o *GODM) All(results interface{}) error {
 	}
 	defer cursor.Close(o.getContext())
 
-	return cursor.All(o.getContext(), results)
+	if err := cursor.All(o.getContext(), results); err != nil {
+		return err
+	}
+	return o.afterRetrieveAll(results)
+}
+
+// afterRetrieve 在單筆文檔解碼後解析多型關聯、呼叫 AfterFind 鉤子並觸發 retrieved 事件（包含預載入的關聯文檔）。
+// afterRetrieve resolves polymorphic relations, runs the AfterFind hook and fires the retrieved event after a
+// document is decoded, including its eager-loaded relations.
+func (o *GODM) afterRetrieve(model interface{}, tree []*relationNode) error {
+	if err := resolveMorphs(model, tree); err != nil {
+		return err
+	}
+	if err := o.runHook("retrieved", model); err != nil {
+		return err
+	}
+	if err := o.notifyRetrieved(model); err != nil {
+		return fmt.Errorf("observer retrieved error: %w", err)
+	}
+	return o.retrievedRelations(model, tree)
+}
+
+// retrievedRelations 對模型中已載入的關聯文檔呼叫 AfterFind 鉤子並觸發 retrieved 事件。
+// retrievedRelations runs the AfterFind hook and fires the retrieved event for the loaded relations of model.
+func (o *GODM) retrievedRelations(model interface{}, tree []*relationNode) error {
+	for _, related := range collectRelationModels(model, tree) {
+		if err := o.runHook("retrieved", related); err != nil {
+			return err
+		}
+		if err := o.notifyRetrieved(related); err != nil {
+			return fmt.Errorf("observer retrieved error: %w", err)
+		}
+	}
+	return nil
+}
+
+// afterRetrieveAll 載入 Preload 策略的關聯後，對結果（單一模型或切片）中的每筆文檔呼叫 afterRetrieve。
+// afterRetrieveAll loads the relations using the Preload strategy, then calls afterRetrieve for every document
+// in results (a single model or a slice).
+func (o *GODM) afterRetrieveAll(results interface{}) error {
+	tree := o.relationTree()
+	models := elementPointers(reflect.ValueOf(results))
+	if err := o.preloadRelations(models, tree); err != nil {
+		return err
+	}
+	for _, model := range models {
+		if err := o.afterRetrieve(model, tree); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Exists 檢查是否存在符合過濾條件的文檔。
@@ -0,0 +1,178 @@
+package odm
+
+import (
+	"context"
+	"fmt"
+)
+
+// hooks.go - 定義模型自身的生命週期鉤子（BeforeCreate、AfterUpdate 等）
+// Defines lifecycle hook methods implemented directly on model structs (BeforeCreate, AfterUpdate, ...).
+//
+// 執行順序：同一階段中，模型鉤子總是先於全域與 ObservedModel 觀察者執行，
+// 例如 Create 的順序為：
+//   BeforeSave → saving → BeforeCreate → creating → InsertOne → AfterCreate → created → AfterSave → saved
+// Before* 鉤子回傳錯誤時會中止操作；After* 鉤子回傳錯誤時操作已完成，但錯誤會回傳給呼叫者。
+//
+// Execution order: within a stage, the model hook always runs before global and ObservedModel observers,
+// e.g. Create runs:
+//   BeforeSave → saving → BeforeCreate → creating → InsertOne → AfterCreate → created → AfterSave → saved
+// An error from a Before* hook aborts the operation; an error from an After* hook is returned to the
+// caller after the write has already happened.
+
+// BeforeCreateHook - 在插入文檔前呼叫
+// Called before the document is inserted
+type BeforeCreateHook interface {
+	BeforeCreate(ctx context.Context) error
+}
+
+// AfterCreateHook - 在插入文檔後呼叫
+// Called after the document is inserted
+type AfterCreateHook interface {
+	AfterCreate(ctx context.Context) error
+}
+
+// BeforeUpdateHook - 在更新文檔前呼叫
+// Called before the document is updated
+type BeforeUpdateHook interface {
+	BeforeUpdate(ctx context.Context) error
+}
+
+// AfterUpdateHook - 在更新文檔後呼叫
+// Called after the document is updated
+type AfterUpdateHook interface {
+	AfterUpdate(ctx context.Context) error
+}
+
+// BeforeSaveHook - 在 Create、Update 或 Upsert 前呼叫
+// Called before Create, Update or Upsert
+type BeforeSaveHook interface {
+	BeforeSave(ctx context.Context) error
+}
+
+// AfterSaveHook - 在 Create、Update 或 Upsert 後呼叫
+// Called after Create, Update or Upsert
+type AfterSaveHook interface {
+	AfterSave(ctx context.Context) error
+}
+
+// BeforeUpsertHook - 在 Upsert 前呼叫
+// Called before Upsert
+type BeforeUpsertHook interface {
+	BeforeUpsert(ctx context.Context) error
+}
+
+// AfterUpsertHook - 在 Upsert 後呼叫
+// Called after Upsert
+type AfterUpsertHook interface {
+	AfterUpsert(ctx context.Context) error
+}
+
+// BeforeDeleteHook - 在刪除文檔前呼叫
+// Called before the document is deleted
+type BeforeDeleteHook interface {
+	BeforeDelete(ctx context.Context) error
+}
+
+// AfterDeleteHook - 在刪除文檔後呼叫
+// Called after the document is deleted
+type AfterDeleteHook interface {
+	AfterDelete(ctx context.Context) error
+}
+
+// AfterFindHook - 在 First / All 解碼每筆文檔後呼叫（包含預載入的關聯文檔）
+// Called after First / All decode each document (including eager-loaded relations)
+type AfterFindHook interface {
+	AfterFind(ctx context.Context) error
+}
+
+// runHook 依照階段呼叫模型上對應的鉤子方法，模型未實作時直接返回。
+// runHook calls the hook method matching stage on model, doing nothing when it is not implemented.
+func (o *GODM) runHook(stage string, model interface{}) error {
+	ctx := o.getContext()
+	var err error
+	switch stage {
+	case "creating":
+		if h, ok := model.(BeforeCreateHook); ok {
+			err = h.BeforeCreate(ctx)
+		}
+	case "created":
+		if h, ok := model.(AfterCreateHook); ok {
+			err = h.AfterCreate(ctx)
+		}
+	case "updating":
+		if h, ok := model.(BeforeUpdateHook); ok {
+			err = h.BeforeUpdate(ctx)
+		}
+	case "updated":
+		if h, ok := model.(AfterUpdateHook); ok {
+			err = h.AfterUpdate(ctx)
+		}
+	case "saving":
+		if h, ok := model.(BeforeSaveHook); ok {
+			err = h.BeforeSave(ctx)
+		}
+	case "saved":
+		if h, ok := model.(AfterSaveHook); ok {
+			err = h.AfterSave(ctx)
+		}
+	case "upserting":
+		if h, ok := model.(BeforeUpsertHook); ok {
+			err = h.BeforeUpsert(ctx)
+		}
+	case "upserted":
+		if h, ok := model.(AfterUpsertHook); ok {
+			err = h.AfterUpsert(ctx)
+		}
+	case "deleting":
+		if h, ok := model.(BeforeDeleteHook); ok {
+			err = h.BeforeDelete(ctx)
+		}
+	case "deleted":
+		if h, ok := model.(AfterDeleteHook); ok {
+			err = h.AfterDelete(ctx)
+		}
+	case "retrieved":
+		if h, ok := model.(AfterFindHook); ok {
+			err = h.AfterFind(ctx)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook error: %w", stage, err)
+	}
+	return nil
+}
+
+// fire 先呼叫模型上的鉤子方法，再依優先順序通知觀察者。
+// fire runs the model hook for stage first and then notifies the observers.
+func (o *GODM) fire(stage string, model interface{}) error {
+	if err := o.runHook(stage, model); err != nil {
+		return err
+	}
+	var err error
+	switch stage {
+	case "creating":
+		err = o.notifyCreating(model)
+	case "created":
+		err = o.notifyCreated(model)
+	case "updating":
+		err = o.notifyUpdating(model)
+	case "updated":
+		err = o.notifyUpdated(model)
+	case "saving":
+		err = o.notifySaving(model)
+	case "saved":
+		err = o.notifySaved(model)
+	case "upserting":
+		err = o.notifyUpserting(model)
+	case "upserted":
+		err = o.notifyUpserted(model)
+	case "deleting":
+		err = o.notifyDeleting(model)
+	case "deleted":
+		err = o.notifyDeleted(model)
+	}
+	if err != nil {
+		return fmt.Errorf("observer %s error: %w", stage, err)
+	}
+	return nil
+}
@@ -0,0 +1,539 @@
+package odm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// index.go - 以 struct tag 或 Indexes() 宣告索引，並以 EnsureIndexes / SyncIndexes 同步到資料庫
+// Index declarations in struct tags or an Indexes() method, synced to the database by EnsureIndexes / SyncIndexes.
+//
+// 範例 / Example:
+//
+//	type User struct {
+//		Email     string    `bson:"email" index:"asc,unique,locale=en,strength=2"`
+//		Bio       string    `bson:"bio" index:"text"`
+//		Location  bson.M    `bson:"location" index:"2dsphere,sparse"`
+//		TenantID  string    `bson:"tenant_id" index:"asc,name=tenant_created"`
+//		CreatedAt time.Time `bson:"created_at" index:"desc,name=tenant_created"` // 與 tenant_id 組成複合索引 / compound with tenant_id
+//		ExpiresAt time.Time `bson:"expires_at" index:"asc,ttl=3600"`
+//	}
+//
+//	func (User) Indexes() []odm.Index {
+//		return []odm.Index{{
+//			Keys:          bson.D{{Key: "status", Value: 1}},
+//			PartialFilter: bson.M{"status": bson.M{"$exists": true}},
+//		}}
+//	}
+//
+//	report, err := odm.EnsureIndexes(ctx, &User{}, &Post{})
+//
+// tag 的第一個值為索引類型（asc、desc、text、2dsphere、hashed，空白時為 asc），其餘為選項：
+// name（同名的欄位依欄位順序組成複合索引，選項取自第一個欄位）、unique、sparse、ttl（秒）、locale 與 strength（collation）。
+// The first value of the tag is the index kind (asc, desc, text, 2dsphere, hashed; empty means asc), the rest are
+// options: name (fields sharing a name form a compound index in field order, taking the options of the first
+// field), unique, sparse, ttl (seconds), locale and strength (collation).
+
+// indexTagKey - 索引宣告使用的 struct tag 名稱
+// The struct tag key used for index declarations
+const indexTagKey = "index"
+
+// Index - 一個索引的宣告
+// The declaration of one index
+type Index struct {
+	Name          string             // 索引名稱，空白時依鍵產生（例如 "email_1"）/ index name, generated from the keys when empty (e.g. "email_1")
+	Keys          bson.D             // 索引鍵，值為 1、-1、"text"、"2dsphere" 或 "hashed" / keys, valued 1, -1, "text", "2dsphere" or "hashed"
+	Unique        bool               // 唯一索引 / unique index
+	Sparse        bool               // 稀疏索引 / sparse index
+	PartialFilter interface{}        // 部分索引的過濾條件 / filter of a partial index
+	ExpireAfter   *time.Duration     // TTL 索引的存活時間（以秒為單位）/ lifetime of a TTL index (whole seconds)
+	Collation     *options.Collation // 排序規則 / collation
+}
+
+// IndexedModel - 以方法宣告索引的模型（可與 struct tag 並用）
+// A model declaring indexes with a method (struct tags can be used as well)
+type IndexedModel interface {
+	Indexes() []Index
+}
+
+// IndexSyncOptions - SyncIndexes 的選項
+// Options of SyncIndexes
+type IndexSyncOptions struct {
+	DropUndeclared bool // 刪除資料庫中有、但已不再宣告的索引（_id 索引除外）/ drop indexes no longer declared (except _id)
+	DryRun         bool // 只回報差異，不建立或刪除索引 / only report the differences, creating and dropping nothing
+}
+
+// IndexDrift - 宣告與資料庫中現有索引不一致的索引
+// An index whose declaration differs from the one in the database
+type IndexDrift struct {
+	Collection string // 集合名稱 / collection name
+	Name       string // 宣告的索引名稱 / declared index name
+	Existing   string // 資料庫中對應索引的名稱 / name of the matching index in the database
+	Reason     string // 差異說明 / what differs
+}
+
+// IndexReport - 同步索引的結果，索引以 "<collection>.<name>" 表示
+// The result of an index sync; indexes are written as "<collection>.<name>"
+type IndexReport struct {
+	Created []string     // 新建立（DryRun 時為缺少）的索引 / indexes created (missing, on a dry run)
+	Dropped []string     // 已刪除（DryRun 時為未宣告）的索引 / indexes dropped (undeclared, on a dry run)
+	Drifted []IndexDrift // 與宣告不一致、未被修改的索引 / indexes that differ from their declaration, left untouched
+}
+
+// EnsureIndexes 建立 models 宣告但資料庫中缺少的索引，並回報與宣告不一致的索引；適合在啟動時或指令中執行。
+// EnsureIndexes creates the indexes declared by models that are missing from the database and reports the ones
+// that drifted; run it at startup or from a command.
+func EnsureIndexes(ctx context.Context, models ...interface{}) (*IndexReport, error) {
+	return SyncIndexes(ctx, IndexSyncOptions{}, models...)
+}
+
+// SyncIndexes 與 EnsureIndexes 相同，但可刪除已不再宣告的索引或只回報差異。
+// 宣告同一集合的多個模型會合併處理；與宣告不一致的索引只回報，不會自動重建。
+// SyncIndexes is EnsureIndexes that can also drop indexes no longer declared, or only report the differences.
+// Models sharing a collection are handled together; drifted indexes are only reported, never rebuilt.
+func SyncIndexes(ctx context.Context, opts IndexSyncOptions, models ...interface{}) (*IndexReport, error) {
+	report := &IndexReport{}
+	var order []string
+	collections := map[string]*mongo.Collection{}
+	declared := map[string][]Index{}
+	for _, model := range models {
+		indexes, err := IndexesOf(model)
+		if err != nil {
+			return report, err
+		}
+		q := modelQuery(ctx, model)
+		key := q.Collection.Database().Name() + "." + q.Collection.Name()
+		if _, ok := collections[key]; !ok {
+			collections[key] = q.Collection
+			order = append(order, key)
+		}
+		declared[key] = append(declared[key], indexes...)
+	}
+	for _, key := range order {
+		if err := syncCollectionIndexes(ctx, collections[key], declared[key], opts, report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// IndexesOf 回傳模型以 struct tag 與 Indexes() 宣告的所有索引，名稱已補齊。
+// IndexesOf returns every index declared by the model with struct tags and Indexes(), with names filled in.
+func IndexesOf(model interface{}) ([]Index, error) {
+	indexes, err := parseIndexTags(reflect.TypeOf(model))
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := model.(IndexedModel); ok {
+		indexes = append(indexes, m.Indexes()...)
+	}
+	seen := map[string]bool{}
+	for i := range indexes {
+		if len(indexes[i].Keys) == 0 {
+			return nil, fmt.Errorf("index error: %T declares an index without keys", model)
+		}
+		if indexes[i].Name == "" {
+			indexes[i].Name = indexName(indexes[i].Keys)
+		}
+		if seen[indexes[i].Name] {
+			return nil, fmt.Errorf("index error: %T declares index %q twice", model, indexes[i].Name)
+		}
+		seen[indexes[i].Name] = true
+	}
+	return indexes, nil
+}
+
+// parseIndexTags 解析模型型別上的 index tag，同名的欄位合併為複合索引；
+// 集合只能有一個文字索引，因此所有 text 欄位合併為同一個文字索引。
+// parseIndexTags parses the index tags of the model type, merging fields that share a name into compound indexes.
+// A collection can only have one text index, so every text field is merged into a single one.
+func parseIndexTags(typ reflect.Type) ([]Index, error) {
+	typ = indirectType(typ)
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, nil
+	}
+	var indexes []Index
+	byName := map[string]int{}
+	textAt := -1
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag, ok := field.Tag.Lookup(indexTagKey)
+		if !ok || field.PkgPath != "" {
+			continue
+		}
+		index, err := parseIndexTag(bsonFieldName(field), tag)
+		if err != nil {
+			return nil, fmt.Errorf("index tag error on %s.%s: %w", typ.Name(), field.Name, err)
+		}
+		if index.Keys[0].Value == "text" {
+			if textAt >= 0 {
+				indexes[textAt].Keys = append(indexes[textAt].Keys, index.Keys...)
+				if indexes[textAt].Name == "" {
+					indexes[textAt].Name = index.Name
+				}
+				continue
+			}
+			textAt = len(indexes)
+		}
+		if index.Name != "" {
+			if at, ok := byName[index.Name]; ok {
+				indexes[at].Keys = append(indexes[at].Keys, index.Keys...)
+				continue
+			}
+			byName[index.Name] = len(indexes)
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// parseIndexTag 將單一欄位的 index tag 解析為 Index。
+// parseIndexTag parses the index tag of a single field into an Index.
+func parseIndexTag(field, tag string) (Index, error) {
+	parts := strings.Split(tag, ",")
+	var value interface{}
+	switch kind := strings.TrimSpace(parts[0]); kind {
+	case "", "asc":
+		value = 1
+	case "desc":
+		value = -1
+	case "text", "2dsphere", "hashed":
+		value = kind
+	default:
+		return Index{}, fmt.Errorf("unknown index kind %q", kind)
+	}
+
+	index := Index{Keys: bson.D{{Key: field, Value: value}}}
+	for _, part := range parts[1:] {
+		key, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "name":
+			index.Name = val
+		case "unique":
+			index.Unique = true
+		case "sparse":
+			index.Sparse = true
+		case "ttl":
+			seconds, err := strconv.Atoi(val)
+			if err != nil {
+				return Index{}, fmt.Errorf("invalid ttl %q", val)
+			}
+			ttl := time.Duration(seconds) * time.Second
+			index.ExpireAfter = &ttl
+		case "locale":
+			if index.Collation == nil {
+				index.Collation = &options.Collation{}
+			}
+			index.Collation.Locale = val
+		case "strength":
+			strength, err := strconv.Atoi(val)
+			if err != nil {
+				return Index{}, fmt.Errorf("invalid strength %q", val)
+			}
+			if index.Collation == nil {
+				index.Collation = &options.Collation{}
+			}
+			index.Collation.Strength = strength
+		default:
+			return Index{}, fmt.Errorf("unknown index option %q", key)
+		}
+	}
+	if index.Collation != nil && index.Collation.Locale == "" {
+		return Index{}, fmt.Errorf("collation strength needs a locale")
+	}
+	return index, nil
+}
+
+// indexName 依 MongoDB 的規則由鍵產生索引名稱，例如 {"a": 1, "b": -1} 為 "a_1_b_-1"。
+// indexName builds the index name from its keys the way MongoDB does, e.g. {"a": 1, "b": -1} is "a_1_b_-1".
+func indexName(keys bson.D) string {
+	parts := make([]string, 0, len(keys)*2)
+	for _, key := range keys {
+		parts = append(parts, key.Key, fmt.Sprint(key.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+// model 轉換為驅動程式的 IndexModel。
+// model converts the declaration into the driver's IndexModel.
+func (i Index) model() mongo.IndexModel {
+	opts := options.Index().SetName(i.Name)
+	if i.Unique {
+		opts.SetUnique(true)
+	}
+	if i.Sparse {
+		opts.SetSparse(true)
+	}
+	if !emptyDocument(i.PartialFilter) {
+		opts.SetPartialFilterExpression(i.PartialFilter)
+	}
+	if i.ExpireAfter != nil {
+		opts.SetExpireAfterSeconds(int32(i.ExpireAfter.Seconds()))
+	}
+	if i.Collation != nil {
+		opts.SetCollation(i.Collation)
+	}
+	return mongo.IndexModel{Keys: i.Keys, Options: opts}
+}
+
+// syncCollectionIndexes 比對單一集合宣告與現有的索引，建立缺少的、回報不一致的，並依選項刪除未宣告的索引。
+// syncCollectionIndexes compares the declared and existing indexes of one collection: it creates the missing
+// ones, reports the drifted ones and, when asked, drops the undeclared ones.
+func syncCollectionIndexes(ctx context.Context, coll *mongo.Collection, declared []Index, opts IndexSyncOptions, report *IndexReport) error {
+	existing, err := listIndexes(ctx, coll)
+	if err != nil {
+		return err
+	}
+	matched := map[string]bool{"_id_": true}
+	var missing []mongo.IndexModel
+	for _, index := range declared {
+		current, ok := existing[index.Name]
+		if !ok {
+			current, ok = existingByKeys(existing, index)
+		}
+		if !ok {
+			missing = append(missing, index.model())
+			report.Created = append(report.Created, coll.Name()+"."+index.Name)
+			continue
+		}
+		matched[current.Name] = true
+		if reason := indexDrift(index, current); reason != "" {
+			report.Drifted = append(report.Drifted, IndexDrift{Collection: coll.Name(), Name: index.Name, Existing: current.Name, Reason: reason})
+		}
+	}
+
+	if len(missing) > 0 && !opts.DryRun {
+		if _, err := coll.Indexes().CreateMany(ctx, missing); err != nil {
+			return fmt.Errorf("create index error: %w", err)
+		}
+	}
+
+	if !opts.DropUndeclared {
+		return nil
+	}
+	names := make([]string, 0, len(existing))
+	for name := range existing {
+		if !matched[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !opts.DryRun {
+			if _, err := coll.Indexes().DropOne(ctx, name); err != nil {
+				return fmt.Errorf("drop index error: %w", err)
+			}
+		}
+		report.Dropped = append(report.Dropped, coll.Name()+"."+name)
+	}
+	return nil
+}
+
+// namespaceNotFoundCode - 集合不存在時 listIndexes 回傳的錯誤碼
+// Error code listIndexes returns when the collection does not exist
+const namespaceNotFoundCode = 26
+
+// indexSpec - listIndexes 回傳的現有索引
+// An existing index as returned by listIndexes
+type indexSpec struct {
+	Name                    string `bson:"name"`
+	Key                     bson.D `bson:"key"`
+	Unique                  bool   `bson:"unique"`
+	Sparse                  bool   `bson:"sparse"`
+	ExpireAfterSeconds      *int64 `bson:"expireAfterSeconds"`
+	PartialFilterExpression bson.M `bson:"partialFilterExpression"`
+	Collation               bson.M `bson:"collation"`
+	Weights                 bson.M `bson:"weights"`
+}
+
+// listIndexes 依名稱回傳集合現有的索引，集合不存在時為空。
+// listIndexes returns the existing indexes of the collection by name; none when the collection does not exist.
+func listIndexes(ctx context.Context, coll *mongo.Collection) (map[string]indexSpec, error) {
+	existing := map[string]indexSpec{}
+	cursor, err := coll.Indexes().List(ctx)
+	var server mongo.ServerError
+	if errors.As(err, &server) && server.HasErrorCode(namespaceNotFoundCode) {
+		return existing, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list indexes error: %w", err)
+	}
+	defer cursor.Close(ctx)
+	var specs []indexSpec
+	if err := cursor.All(ctx, &specs); err != nil {
+		return nil, fmt.Errorf("list indexes error: %w", err)
+	}
+	for _, spec := range specs {
+		existing[spec.Name] = spec
+	}
+	return existing, nil
+}
+
+// existingByKeys 尋找鍵與宣告相同但名稱不同的現有索引。
+// existingByKeys looks for an existing index with the declared keys under another name.
+func existingByKeys(existing map[string]indexSpec, index Index) (indexSpec, bool) {
+	want := declaredKeys(index.Keys)
+	for _, spec := range existing {
+		if spec.keys() == want {
+			return spec, true
+		}
+	}
+	return indexSpec{}, false
+}
+
+// indexDrift 比較宣告與現有索引，回傳差異說明，一致時為空字串。
+// indexDrift compares a declaration with an existing index and describes what differs; empty when they match.
+func indexDrift(index Index, spec indexSpec) string {
+	var reasons []string
+	if spec.Name != index.Name {
+		reasons = append(reasons, fmt.Sprintf("name is %q", spec.Name))
+	}
+	if want, got := declaredKeys(index.Keys), spec.keys(); want != got {
+		reasons = append(reasons, fmt.Sprintf("keys are %s, declared %s", got, want))
+	}
+	if spec.Unique != index.Unique {
+		reasons = append(reasons, fmt.Sprintf("unique is %t", spec.Unique))
+	}
+	if spec.Sparse != index.Sparse {
+		reasons = append(reasons, fmt.Sprintf("sparse is %t", spec.Sparse))
+	}
+	switch ttl := spec.ExpireAfterSeconds; {
+	case index.ExpireAfter == nil && ttl != nil:
+		reasons = append(reasons, fmt.Sprintf("ttl is %ds, not declared", *ttl))
+	case index.ExpireAfter != nil && ttl == nil:
+		reasons = append(reasons, "ttl is missing")
+	case index.ExpireAfter != nil && *ttl != int64(index.ExpireAfter.Seconds()):
+		reasons = append(reasons, fmt.Sprintf("ttl is %ds, declared %ds", *ttl, int64(index.ExpireAfter.Seconds())))
+	}
+	if !sameDocument(spec.PartialFilterExpression, index.PartialFilter) {
+		reasons = append(reasons, "partial filter differs")
+	}
+	if reason := collationDrift(index.Collation, spec.Collation); reason != "" {
+		reasons = append(reasons, reason)
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// collationDrift 比較宣告的 collation 與現有索引的 locale / strength。
+// collationDrift compares the declared collation with the locale / strength of the existing index.
+func collationDrift(want *options.Collation, spec bson.M) string {
+	if want == nil {
+		if spec != nil {
+			return "collation is set, not declared"
+		}
+		return ""
+	}
+	if spec == nil {
+		return "collation is missing"
+	}
+	if locale, _ := spec["locale"].(string); locale != want.Locale {
+		return fmt.Sprintf("collation locale is %q, declared %q", locale, want.Locale)
+	}
+	if strength, _ := toInt64(spec["strength"]); want.Strength != 0 && strength != int64(want.Strength) {
+		return fmt.Sprintf("collation strength is %d, declared %d", strength, want.Strength)
+	}
+	return ""
+}
+
+// declaredKeys 將宣告的鍵轉為可比較的字串；text 鍵依欄位名稱排序，對應資料庫中的 weights。
+// declaredKeys turns the declared keys into a comparable string; text keys are sorted by field, matching the
+// weights stored by the database.
+func declaredKeys(keys bson.D) string {
+	var parts, text []string
+	for _, key := range keys {
+		if key.Value == "text" {
+			text = append(text, key.Key+":text")
+			continue
+		}
+		parts = append(parts, key.Key+":"+keyValue(key.Value))
+	}
+	sort.Strings(text)
+	return "{" + strings.Join(append(parts, text...), ", ") + "}"
+}
+
+// keys 將現有索引的鍵轉為與 declaredKeys 相同格式的字串；text 索引的 _fts / _ftsx 以 weights 的欄位取代。
+// keys turns the keys of an existing index into the format of declaredKeys; the _fts / _ftsx keys of a text
+// index are replaced by the fields of its weights.
+func (spec indexSpec) keys() string {
+	var parts, text []string
+	for _, key := range spec.Key {
+		switch key.Key {
+		case "_fts":
+			for field := range spec.Weights {
+				text = append(text, field+":text")
+			}
+		case "_ftsx":
+		default:
+			parts = append(parts, key.Key+":"+keyValue(key.Value))
+		}
+	}
+	sort.Strings(text)
+	return "{" + strings.Join(append(parts, text...), ", ") + "}"
+}
+
+// keyValue 將鍵的值統一格式：數字轉為整數，其餘保留字串。
+// keyValue formats a key value uniformly: numbers become integers, anything else stays a string.
+func keyValue(v interface{}) string {
+	if n, ok := toInt64(v); ok {
+		return strconv.FormatInt(n, 10)
+	}
+	return fmt.Sprint(v)
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// sameDocument 以 bson 編碼比較兩個文檔（nil 或空文檔視為不存在）。
+// sameDocument compares two documents by their bson encoding (nil or empty means absent).
+func sameDocument(a, b interface{}) bool {
+	if emptyDocument(a) || emptyDocument(b) {
+		return emptyDocument(a) && emptyDocument(b)
+	}
+	ra, errA := bson.Marshal(a)
+	rb, errB := bson.Marshal(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	var da, db bson.M
+	if bson.Unmarshal(ra, &da) != nil || bson.Unmarshal(rb, &db) != nil {
+		return false
+	}
+	return fmt.Sprint(da) == fmt.Sprint(db)
+}
+
+// emptyDocument 回報 v 是否為 nil、nil 的 map 或指標，或編碼後沒有任何欄位。
+// emptyDocument reports whether v is nil, a nil map or pointer, or encodes to a document without fields.
+func emptyDocument(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Map, reflect.Ptr, reflect.Slice, reflect.Interface:
+		if rv.IsNil() {
+			return true
+		}
+	}
+	raw, err := bson.Marshal(v)
+	return err == nil && len(raw) <= 5
+}
@@ -0,0 +1,97 @@
+package odm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// insert_ids.go - 新增後將產生的 _id 寫回模型，並整理 BulkCreate 部分失敗的結果
+// Writes generated _id values back into the models after inserts and sorts out partial BulkCreate failures.
+
+// BulkCreateError - BulkCreate 部分新增失敗時回傳的錯誤，Failures 的 Index 為 models 中的索引
+// Returned by BulkCreate when some inserts failed; the Index of each failure points into models
+type BulkCreateError struct {
+	Failures []BulkOperationError
+	Inserted int // 成功新增的數量 / number of models inserted
+}
+
+func (e *BulkCreateError) Error() string {
+	return fmt.Sprintf("bulk create error: %d inserted, %s", e.Inserted, (&BulkError{Failures: e.Failures}).Error())
+}
+
+// Unwrap 回傳每個失敗新增的錯誤，供 errors.As 使用。
+// Unwrap returns the error of every failed insert, for errors.As.
+func (e *BulkCreateError) Unwrap() []error {
+	return (&BulkError{Failures: e.Failures}).Unwrap()
+}
+
+// FailedIndexes 回傳新增失敗的 models 索引。
+// FailedIndexes returns the indexes in models of the failed inserts.
+func (e *BulkCreateError) FailedIndexes() []int {
+	indexes := make([]int, len(e.Failures))
+	for i, f := range e.Failures {
+		indexes[i] = f.Index
+	}
+	return indexes
+}
+
+// insertedIDs 依 InsertMany 的錯誤將實際新增的 _id 對應回 n 個模型：驅動程式回傳的 ID 已移除失敗的文檔
+// （有序模式下還有其後的文檔），這些模型為 nil；無法判斷哪些文檔已寫入的錯誤（例如連線錯誤）則全部為 nil。
+// insertedIDs maps the _id values InsertMany actually inserted back onto the n models. The driver leaves the failed
+// documents (and, when ordered, every document after the first failure) out of ids, so those models get nil; so
+// does everything when the error does not tell which documents were written (a network error, say).
+func insertedIDs(n int, ids []interface{}, err error, ordered bool) ([]interface{}, []BulkOperationError) {
+	kept := make([]interface{}, n)
+	if err == nil {
+		copy(kept, ids)
+		return kept, nil
+	}
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) {
+		return kept, nil
+	}
+
+	stop := n
+	failed := map[int]bool{}
+	var failures []BulkOperationError
+	for _, we := range bulkErr.WriteErrors {
+		failed[we.Index] = true
+		failures = append(failures, BulkOperationError{Index: we.Index, Code: we.Code, Message: we.Message})
+		if ordered && we.Index < stop {
+			stop = we.Index
+		}
+	}
+	next := 0
+	for i := 0; i < stop && next < len(ids); i++ {
+		if failed[i] {
+			continue
+		}
+		kept[i] = ids[next]
+		next++
+	}
+	return kept, failures
+}
+
+// setInsertedID 模型的 _id 欄位為零值時寫入 id；欄位不存在或型別不符時不做任何事。
+// setInsertedID writes id into the model's _id field while it is zero; it does nothing when the field is missing
+// or its type does not fit.
+func setInsertedID(model interface{}, id interface{}) {
+	field, ok := fieldByBsonName(model, "_id")
+	if !ok || !field.CanSet() || !field.IsZero() || id == nil {
+		return
+	}
+	v := reflect.ValueOf(id)
+	switch {
+	case v.Type().AssignableTo(field.Type()):
+		field.Set(v)
+	case field.Kind() == reflect.Interface && v.Type().Implements(field.Type()):
+		field.Set(v)
+	case field.Kind() == reflect.Ptr && v.Type().AssignableTo(field.Type().Elem()):
+		ptr := reflect.New(field.Type().Elem())
+		ptr.Elem().Set(v)
+		field.Set(ptr)
+	}
+}
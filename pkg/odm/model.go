@@ -2,6 +2,7 @@ package odm
 
 import (
 	"context"
+	"reflect"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -25,18 +26,57 @@ type GODM struct {
 
 	Observers []ModelObserver // 支援多個 observer
 
+	// 批次操作的事件觸發方式（BulkCreate、UpdateMany、DeleteMany）
+	BulkEvents BulkEventMode
+
+	// WithoutEvents 設定的停用範圍
+	mute *eventMute
+
 	// 預先載入關聯的欄位名稱（例如 "posts", "comments"）
 	WithRelations []string
 
 	// 關聯欄位對應的設定，例如 localField, foreignField 等（未來可用來自定義 $lookup 行為）
 	RelationConfigs map[string]RelationConfig
+
+	// 預載入關聯的限制條件（由 WithFn 設定），鍵為關聯路徑
+	RelationConstraints map[string]func(q *GODM)
+
+	// 關聯存在條件與聚合欄位（由 Has、WhereHas、WithCount 等設定）
+	relationQueries []relationQuery
+
+	// 建立查詢條件時的錯誤（例如 WhereID 收到無效的 ID）
+	queryErr error
 }
 
 // RelationConfig 用來定義一個 $lookup 的設定
 type RelationConfig struct {
-	From         string // 關聯的 collection 名稱
-	LocalField   string // 本地欄位
-	ForeignField string // 關聯 collection 的欄位
-	As           string // 最終回傳的欄位名稱
-	IsArray      bool   // 是否為一對多（true）或一對一（false）
+	Type         RelationType // 關聯類型（hasOne、hasMany、belongsTo、belongsToMany、refMany、morphMany、morphTo）
+	From         string       // 關聯的 collection 名稱
+	LocalField   string       // 本地欄位
+	ForeignField string       // 關聯 collection 的欄位
+	As           string       // 最終回傳的欄位名稱
+	IsArray      bool         // 是否為一對多（true）或一對一（false）
+
+	// 多對多（belongsToMany）使用的中介集合設定
+	Pivot           string   // 中介 collection 名稱
+	PivotLocalKey   string   // 中介 collection 中指向本模型的欄位
+	PivotForeignKey string   // 中介 collection 中指向關聯模型的欄位
+	PivotFields     []string // 額外帶入關聯文檔 pivot 欄位的中介欄位
+
+	// ID 陣列關聯（refMany）是否移除找不到對應文檔的參照
+	DropDangling bool
+
+	// 多型關聯（morphMany、morphTo）使用的設定
+	MorphName string // 多型欄位前綴，例如 "commentable" 對應 commentable_type / commentable_id
+	MorphType string // morphMany 時本模型的類型名稱，未設定時使用 RegisterMorph 註冊的名稱
+
+	// 預載入方式與關聯 collection 所在的資料庫
+	Strategy LoadStrategy // Lookup（預設，使用 $lookup）或 Preload（父文檔取回後以 $in 另行查詢）
+	Database string       // 關聯 collection 所在的資料庫，未設定時與本模型相同（跨資料庫需使用 Preload）
+
+	// 刪除本模型時對關聯文檔採取的動作（cascade、restrict、setNull、noAction）
+	OnDelete ReferentialAction
+
+	relatedType reflect.Type // 關聯模型的型別（由 struct tag 解析時取得）
+	ownerType   reflect.Type // 宣告關聯的模型型別（由 struct tag 解析時取得）
 }
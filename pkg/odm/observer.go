@@ -1,5 +1,7 @@
 package odm
 
+import "go.mongodb.org/mongo-driver/bson"
+
 // observer.go - 定義 Observer 架構與註冊機制
 // Defines the Observer architecture and registration mechanisms.
 
@@ -14,6 +16,62 @@ type ModelObserver interface {
 	Deleted(model interface{}) error
 }
 
+// RetrievedObserver - 定義讀取事件觀察者介面（可選實作）
+// Defines the optional retrieved event observer interface
+type RetrievedObserver interface {
+	Retrieved(model interface{}) error
+}
+
+// SavingObserver - 定義儲存事件觀察者介面（可選實作，涵蓋 Create 與 Update）
+// Defines the optional saving event observer interface (wraps both Create and Update)
+type SavingObserver interface {
+	Saving(model interface{}) error
+	Saved(model interface{}) error
+}
+
+// UpsertObserver - 定義 Upsert 事件觀察者介面（可選實作）
+// Defines the optional upsert event observer interface
+type UpsertObserver interface {
+	Upserting(model interface{}) error
+	Upserted(model interface{}) error
+}
+
+// BulkEvent - 批次操作事件的內容：批次新增時為整批模型，批次更新/刪除時為過濾條件與影響筆數
+// Payload of a bulk event: the whole slice for bulk creates, the filter and affected count for bulk updates/deletes
+type BulkEvent struct {
+	Models   []interface{} // 批次新增的模型 / models being bulk created
+	Filter   bson.D        // 批次更新或刪除的過濾條件 / filter of a bulk update or delete
+	Update   bson.M        // 批次更新的內容 / update applied by a bulk update
+	Affected int64         // 完成後實際影響的筆數 / number of documents affected, set on the after-events
+}
+
+// BulkObserver - 定義批次事件觀察者介面（可選實作）
+// Defines the optional bulk event observer interface
+type BulkObserver interface {
+	BulkCreating(event *BulkEvent) error
+	BulkCreated(event *BulkEvent) error
+	BulkUpdating(event *BulkEvent) error
+	BulkUpdated(event *BulkEvent) error
+	BulkDeleting(event *BulkEvent) error
+	BulkDeleted(event *BulkEvent) error
+}
+
+// BulkEventMode - 控制 BulkCreate 等批次操作觸發事件的方式
+// Controls how bulk operations such as BulkCreate fire events
+type BulkEventMode int
+
+const (
+	// BulkEventsBatch - 僅觸發批次事件（bulkCreating / bulkCreated 等），預設值
+	// Fires batch-level events only (bulkCreating / bulkCreated, ...); the default
+	BulkEventsBatch BulkEventMode = iota
+	// BulkEventsPerModel - 另外為每個模型觸發 creating / created（含模型鉤子）
+	// Additionally fires creating / created (including model hooks) for every model
+	BulkEventsPerModel
+	// BulkEventsNone - 不觸發任何事件與鉤子，適用於大量匯入
+	// Fires no events or hooks at all, for raw-speed imports
+	BulkEventsNone
+)
+
 // EventFilter - 定義事件過濾器介面
 // Defines the event filter interface
 type EventFilter interface {
@@ -55,6 +113,14 @@ func RegisterObserverErrorHandler(handler func(err error, stage string, model in
 	observerErrorHandler = handler
 }
 
+// handleObserverError - 將觀察者錯誤交給已註冊的錯誤處理函數
+// Passes an observer error to the registered error handler
+func handleObserverError(err error, stage string, model interface{}) {
+	if observerErrorHandler != nil {
+		observerErrorHandler(err, stage, model)
+	}
+}
+
 // getObserverPriority - 獲取觀察者優先級
 // Retrieves the observer's priority
 func getObserverPriority(o ModelObserver) int {
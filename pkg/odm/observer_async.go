@@ -0,0 +1,235 @@
+package odm
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// observer_async.go - 非同步 Observer 與交易提交後（after-commit）事件的執行機制
+// Execution of asynchronous observers and of events deferred until the transaction commits.
+
+// AsyncObserver - 定義非同步觀察者介面，Async 回傳 true 時事件交由背景 worker pool 執行
+// Defines the async observer interface; when Async returns true the event runs on the background worker pool
+type AsyncObserver interface {
+	Async() bool
+}
+
+// AfterCommitObserver - 定義提交後觀察者介面，AfterCommit 回傳 true 時，
+// 交易中觸發的事件會延後到交易提交後才執行，交易中止則捨棄
+// Defines the after-commit observer interface; when AfterCommit returns true, events raised inside
+// a transaction are queued until it commits and discarded when it aborts
+type AfterCommitObserver interface {
+	AfterCommit() bool
+}
+
+// ErrObserverPoolClosed - 在 ShutdownObservers 之後提交非同步事件時回報給錯誤處理函數
+// Reported to the observer error handler when an async event is submitted after ShutdownObservers
+var ErrObserverPoolClosed = errors.New("observer worker pool is closed")
+
+const (
+	defaultObserverWorkers   = 4
+	defaultObserverQueueSize = 256
+)
+
+// observerPool - 有界的背景 worker pool
+// A bounded background worker pool
+type observerPool struct {
+	jobs   chan func()
+	wg     sync.WaitGroup
+	mu     sync.RWMutex
+	closed bool
+}
+
+var (
+	asyncPoolMu sync.Mutex
+	asyncPool   *observerPool
+	asyncClosed bool // ShutdownObservers 之後為 true，直到再次呼叫 ConfigureAsyncObservers
+)
+
+// ConfigureAsyncObservers - 設定非同步觀察者的 worker 數量與佇列長度，需在首次觸發事件前呼叫；
+// 在 ShutdownObservers 之後呼叫會重新開始接收非同步事件；新事件立即交給新的 pool，並等待舊 pool 的事件執行完畢後返回
+// Configures the number of workers and the queue size for async observers; call it before the first event fires.
+// Calling it after ShutdownObservers accepts async events again. New events go to the new pool right away, and it
+// returns once the events of the old pool have finished
+func ConfigureAsyncObservers(workers, queueSize int) {
+	asyncPoolMu.Lock()
+	old := asyncPool
+	asyncPool = newObserverPool(workers, queueSize)
+	asyncClosed = false
+	asyncPoolMu.Unlock()
+
+	// 解鎖後才等待舊 pool，其中的事件仍可觸發新事件 / wait for the old pool after unlocking, so its jobs can still fire events
+	if old != nil {
+		old.close()
+		old.wg.Wait()
+	}
+}
+
+// ShutdownObservers - 停止接收新的非同步事件，並等待佇列中的事件執行完畢或 ctx 結束；
+// 之後提交的非同步事件會以 ErrObserverPoolClosed 回報給錯誤處理函數
+// Stops accepting async events and waits for queued events to finish or for ctx to be done; async events
+// submitted afterwards are reported to the error handler as ErrObserverPoolClosed
+func ShutdownObservers(ctx context.Context) error {
+	asyncPoolMu.Lock()
+	pool := asyncPool
+	asyncPool = nil
+	asyncClosed = true
+	asyncPoolMu.Unlock()
+	if pool == nil {
+		return nil
+	}
+
+	pool.close()
+	done := make(chan struct{})
+	go func() {
+		pool.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newObserverPool 建立並啟動 worker pool。
+// newObserverPool creates and starts a worker pool.
+func newObserverPool(workers, queueSize int) *observerPool {
+	if workers <= 0 {
+		workers = defaultObserverWorkers
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	p := &observerPool{jobs: make(chan func(), queueSize)}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// submit 將工作放入佇列，佇列已滿時會阻塞直到有空位。
+// submit queues a job, blocking while the queue is full.
+func (p *observerPool) submit(job func()) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return false
+	}
+	p.jobs <- job
+	return true
+}
+
+// close 關閉佇列，worker 執行完剩餘工作後結束。
+// close closes the queue; workers exit after draining the remaining jobs.
+func (p *observerPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+}
+
+// getObserverPool 取得全域 worker pool，必要時以預設值建立；ShutdownObservers 之後返回 nil。
+// getObserverPool returns the global worker pool, creating it with defaults when needed; it returns nil after
+// ShutdownObservers.
+func getObserverPool() *observerPool {
+	asyncPoolMu.Lock()
+	defer asyncPoolMu.Unlock()
+	if asyncClosed {
+		return nil
+	}
+	if asyncPool == nil {
+		asyncPool = newObserverPool(defaultObserverWorkers, defaultObserverQueueSize)
+	}
+	return asyncPool
+}
+
+// txEventsKey - 交易中延後事件佇列在 context 中的鍵
+// Context key of the deferred event queue of a transaction
+type txEventsKey struct{}
+
+// txEvents - 交易提交前暫存的事件
+// Events held back until the transaction commits
+type txEvents struct {
+	mu   sync.Mutex
+	jobs []func()
+}
+
+// withTxEvents 在 context 中附加新的延後事件佇列。
+// withTxEvents attaches a new deferred event queue to ctx.
+func withTxEvents(ctx context.Context) (context.Context, *txEvents) {
+	events := &txEvents{}
+	return context.WithValue(ctx, txEventsKey{}, events), events
+}
+
+// txEventsFromContext 取得 context 中的延後事件佇列，不在交易中時返回 nil。
+// txEventsFromContext returns the deferred event queue of ctx, or nil outside a transaction.
+func txEventsFromContext(ctx context.Context) *txEvents {
+	events, _ := ctx.Value(txEventsKey{}).(*txEvents)
+	return events
+}
+
+func (e *txEvents) add(job func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.jobs = append(e.jobs, job)
+}
+
+// flush 於交易提交後依序執行所有暫存事件，包含執行期間新加入的事件。
+// flush runs every queued event in order after the transaction commits, including the ones queued meanwhile.
+func (e *txEvents) flush() {
+	for {
+		e.mu.Lock()
+		jobs := e.jobs
+		e.jobs = nil
+		e.mu.Unlock()
+		if len(jobs) == 0 {
+			return
+		}
+		for _, job := range jobs {
+			job()
+		}
+	}
+}
+
+// merge 將巢狀範圍（savepoint）成功結束後的事件併入外層佇列，等外層交易提交後才執行。
+// merge moves the events of a successful nested (savepoint) scope into this queue, to run after the outer commit.
+func (e *txEvents) merge(inner *txEvents) {
+	inner.mu.Lock()
+	jobs := inner.jobs
+	inner.jobs = nil
+	inner.mu.Unlock()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.jobs = append(e.jobs, jobs...)
+}
+
+// discard 於交易中止時捨棄所有暫存事件。
+// discard drops every queued event when the transaction aborts.
+func (e *txEvents) discard() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.jobs = nil
+}
+
+// runObserver 依照觀察者設定同步或交由 worker pool 執行事件。
+// runObserver runs the event inline or hands it to the worker pool, depending on the observer.
+func runObserver(observer ModelObserver, stage string, model interface{}, job func()) {
+	if a, ok := observer.(AsyncObserver); ok && a.Async() {
+		if pool := getObserverPool(); pool == nil || !pool.submit(job) {
+			handleObserverError(ErrObserverPoolClosed, stage, model)
+		}
+		return
+	}
+	job()
+}
@@ -5,152 +5,192 @@ import "sort"
 // observer_dispatch.go - 執行 Observer 通知流程，依照類型、事件與優先順序觸發
 // Executes observer notification flows, invoking by type, event, and priority.
 
-func (o *GODM) notifyCreating() error {
-	// 處理創建階段的通知
-	// Handles notifications for the creating stage.
-	observers := append(globalObservers, o.Observers...)
+// sortedObservers 合併全域、查詢與模型自身（ObservedModel）的觀察者並依優先順序排序。
+// sortedObservers merges global, builder and ObservedModel observers and sorts them by priority.
+func (o *GODM) sortedObservers(model interface{}) []ModelObserver {
+	observers := make([]ModelObserver, 0, len(globalObservers)+len(o.Observers))
+	observers = append(observers, globalObservers...)
+	observers = append(observers, o.Observers...)
+	if m, ok := model.(ObservedModel); ok {
+		observers = append(observers, m.Observers()...)
+	}
 	sort.SliceStable(observers, func(i, j int) bool {
 		pi, pj := getObserverPriority(observers[i]), getObserverPriority(observers[j])
 		return pi > pj
 	})
+	return observers
+}
 
-	for _, observer := range observers {
-		if t, ok := observer.(TypedObserver); ok && !t.Accepts(o.Model) {
+// dispatch 將指定階段的事件分派給所有符合條件的觀察者；
+// 非同步觀察者交由 worker pool 執行，提交後觀察者在交易中會延後到提交之後。
+// dispatch delivers the given stage to every observer that accepts the model and is interested in the stage;
+// async observers run on the worker pool and after-commit observers wait for the enclosing transaction to commit.
+func (o *GODM) dispatch(stage string, model interface{}, call func(observer ModelObserver) error) {
+	for _, observer := range o.sortedObservers(model) {
+		if o.silenced(observer) {
+			continue
+		}
+		if t, ok := observer.(TypedObserver); ok && !t.Accepts(model) {
 			continue
 		}
-		if f, ok := observer.(EventFilter); ok && !f.InterestedIn("creating") {
+		if f, ok := observer.(EventFilter); ok && !f.InterestedIn(stage) {
 			continue
 		}
-		if err := observer.Creating(o.Model); err != nil {
-			if observerErrorHandler != nil {
-				observerErrorHandler(err, "creating", o.Model)
+		job := func() {
+			if err := call(observer); err != nil {
+				handleObserverError(err, stage, model)
 			}
 		}
+		if c, ok := observer.(AfterCommitObserver); ok && c.AfterCommit() {
+			if events := txEventsFromContext(o.getContext()); events != nil {
+				events.add(func() { runObserver(observer, stage, model, job) })
+				continue
+			}
+		}
+		runObserver(observer, stage, model, job)
 	}
+}
+
+func (o *GODM) notifyCreating(model interface{}) error {
+	// 處理創建階段的通知
+	// Handles notifications for the creating stage.
+	o.dispatch("creating", model, func(observer ModelObserver) error {
+		return observer.Creating(model)
+	})
 	return nil
 }
 
-func (o *GODM) notifyCreated() error {
+func (o *GODM) notifyCreated(model interface{}) error {
 	// 處理創建後階段的通知
 	// Handles notifications for the created stage.
-	observers := append(globalObservers, o.Observers...)
-	sort.SliceStable(observers, func(i, j int) bool {
-		pi, pj := getObserverPriority(observers[i]), getObserverPriority(observers[j])
-		return pi > pj
+	o.dispatch("created", model, func(observer ModelObserver) error {
+		return observer.Created(model)
 	})
-
-	for _, observer := range observers {
-		if t, ok := observer.(TypedObserver); ok && !t.Accepts(o.Model) {
-			continue
-		}
-		if f, ok := observer.(EventFilter); ok && !f.InterestedIn("created") {
-			continue
-		}
-		if err := observer.Created(o.Model); err != nil {
-			if observerErrorHandler != nil {
-				observerErrorHandler(err, "created", o.Model)
-			}
-		}
-	}
 	return nil
 }
 
-func (o *GODM) notifyUpdating() error {
+func (o *GODM) notifyUpdating(model interface{}) error {
 	// 處理更新階段的通知
 	// Handles notifications for the updating stage.
-	observers := append(globalObservers, o.Observers...)
-	sort.SliceStable(observers, func(i, j int) bool {
-		pi, pj := getObserverPriority(observers[i]), getObserverPriority(observers[j])
-		return pi > pj
+	o.dispatch("updating", model, func(observer ModelObserver) error {
+		return observer.Updating(model)
 	})
-
-	for _, observer := range observers {
-		if t, ok := observer.(TypedObserver); ok && !t.Accepts(o.Model) {
-			continue
-		}
-		if f, ok := observer.(EventFilter); ok && !f.InterestedIn("updating") {
-			continue
-		}
-		if err := observer.Updating(o.Model); err != nil {
-			if observerErrorHandler != nil {
-				observerErrorHandler(err, "updating", o.Model)
-			}
-		}
-	}
 	return nil
 }
 
-func (o *GODM) notifyUpdated() error {
+func (o *GODM) notifyUpdated(model interface{}) error {
 	// 處理更新後階段的通知
 	// Handles notifications for the updated stage.
-	observers := append(globalObservers, o.Observers...)
-	sort.SliceStable(observers, func(i, j int) bool {
-		pi, pj := getObserverPriority(observers[i]), getObserverPriority(observers[j])
-		return pi > pj
+	o.dispatch("updated", model, func(observer ModelObserver) error {
+		return observer.Updated(model)
 	})
-
-	for _, observer := range observers {
-		if t, ok := observer.(TypedObserver); ok && !t.Accepts(o.Model) {
-			continue
-		}
-		if f, ok := observer.(EventFilter); ok && !f.InterestedIn("updated") {
-			continue
-		}
-		if err := observer.Updated(o.Model); err != nil {
-			if observerErrorHandler != nil {
-				observerErrorHandler(err, "updated", o.Model)
-			}
-		}
-	}
 	return nil
 }
 
-func (o *GODM) notifyDeleting() error {
+func (o *GODM) notifyDeleting(model interface{}) error {
 	// 處理刪除階段的通知
 	// Handles notifications for the deleting stage.
-	observers := append(globalObservers, o.Observers...)
-	sort.SliceStable(observers, func(i, j int) bool {
-		pi, pj := getObserverPriority(observers[i]), getObserverPriority(observers[j])
-		return pi > pj
+	o.dispatch("deleting", model, func(observer ModelObserver) error {
+		return observer.Deleting(model)
 	})
-
-	for _, observer := range observers {
-		if t, ok := observer.(TypedObserver); ok && !t.Accepts(o.Model) {
-			continue
-		}
-		if f, ok := observer.(EventFilter); ok && !f.InterestedIn("deleting") {
-			continue
-		}
-		if err := observer.Deleting(o.Model); err != nil {
-			if observerErrorHandler != nil {
-				observerErrorHandler(err, "deleting", o.Model)
-			}
-		}
-	}
 	return nil
 }
 
-func (o *GODM) notifyDeleted() error {
+func (o *GODM) notifyDeleted(model interface{}) error {
 	// 處理刪除後階段的通知
 	// Handles notifications for the deleted stage.
-	observers := append(globalObservers, o.Observers...)
-	sort.SliceStable(observers, func(i, j int) bool {
-		pi, pj := getObserverPriority(observers[i]), getObserverPriority(observers[j])
-		return pi > pj
+	o.dispatch("deleted", model, func(observer ModelObserver) error {
+		return observer.Deleted(model)
 	})
+	return nil
+}
 
-	for _, observer := range observers {
-		if t, ok := observer.(TypedObserver); ok && !t.Accepts(o.Model) {
-			continue
-		}
-		if f, ok := observer.(EventFilter); ok && !f.InterestedIn("deleted") {
-			continue
-		}
-		if err := observer.Deleted(o.Model); err != nil {
-			if observerErrorHandler != nil {
-				observerErrorHandler(err, "deleted", o.Model)
-			}
-		}
+func (o *GODM) notifySaving(model interface{}) error {
+	// 處理儲存階段的通知（Create 與 Update 共用）
+	// Handles notifications for the saving stage (shared by Create and Update).
+	o.dispatch("saving", model, func(observer ModelObserver) error {
+		if s, ok := observer.(SavingObserver); ok {
+			return s.Saving(model)
+		}
+		return nil
+	})
+	return nil
+}
+
+func (o *GODM) notifySaved(model interface{}) error {
+	// 處理儲存後階段的通知（Create 與 Update 共用）
+	// Handles notifications for the saved stage (shared by Create and Update).
+	o.dispatch("saved", model, func(observer ModelObserver) error {
+		if s, ok := observer.(SavingObserver); ok {
+			return s.Saved(model)
+		}
+		return nil
+	})
+	return nil
+}
+
+func (o *GODM) notifyUpserting(model interface{}) error {
+	// 處理 Upsert 階段的通知
+	// Handles notifications for the upserting stage.
+	o.dispatch("upserting", model, func(observer ModelObserver) error {
+		if u, ok := observer.(UpsertObserver); ok {
+			return u.Upserting(model)
+		}
+		return nil
+	})
+	return nil
+}
+
+func (o *GODM) notifyUpserted(model interface{}) error {
+	// 處理 Upsert 後階段的通知
+	// Handles notifications for the upserted stage.
+	o.dispatch("upserted", model, func(observer ModelObserver) error {
+		if u, ok := observer.(UpsertObserver); ok {
+			return u.Upserted(model)
+		}
+		return nil
+	})
+	return nil
+}
+
+// notifyRetrieved 處理讀取後階段的通知，model 為剛解碼完成的文檔。
+// notifyRetrieved handles notifications for the retrieved stage; model is the freshly decoded document.
+func (o *GODM) notifyRetrieved(model interface{}) error {
+	o.dispatch("retrieved", model, func(observer ModelObserver) error {
+		if r, ok := observer.(RetrievedObserver); ok {
+			return r.Retrieved(model)
+		}
+		return nil
+	})
+	return nil
+}
+
+// notifyBulk 處理批次事件（bulkCreating、bulkUpdated 等）的通知。
+// notifyBulk handles notifications for bulk stages (bulkCreating, bulkUpdated, ...).
+func (o *GODM) notifyBulk(stage string, event *BulkEvent) error {
+	if o.BulkEvents == BulkEventsNone {
+		return nil
 	}
+	o.dispatch(stage, o.Model, func(observer ModelObserver) error {
+		b, ok := observer.(BulkObserver)
+		if !ok {
+			return nil
+		}
+		switch stage {
+		case "bulkCreating":
+			return b.BulkCreating(event)
+		case "bulkCreated":
+			return b.BulkCreated(event)
+		case "bulkUpdating":
+			return b.BulkUpdating(event)
+		case "bulkUpdated":
+			return b.BulkUpdated(event)
+		case "bulkDeleting":
+			return b.BulkDeleting(event)
+		case "bulkDeleted":
+			return b.BulkDeleted(event)
+		}
+		return nil
+	})
 	return nil
 }
@@ -0,0 +1,109 @@
+package odm
+
+import (
+	"context"
+	"reflect"
+	"strings"
+)
+
+// observer_scope.go - 暫時停用觀察者的執行範圍（單次呼叫或整段 context）
+// Scopes that temporarily silence observers, for a single builder call or for a whole context.
+//
+// 停用只影響全域、查詢與 ObservedModel 觀察者，模型自身的鉤子方法（BeforeCreate 等）仍會執行。
+// 停用狀態存放在 GODM 或 context 上，不會修改全域註冊，可與其他 goroutine 同時使用。
+// Silencing only affects global, builder and ObservedModel observers; hook methods defined on the model
+// (BeforeCreate, ...) still run. The state lives on the GODM or the context and never touches the global
+// registry, so other goroutines keep firing events normally.
+
+// NamedObserver - 定義具名觀察者介面，名稱用於 WithoutEvents 只停用部分觀察者
+// Defines the named observer interface; the name is used by WithoutEvents to silence a subset of observers
+type NamedObserver interface {
+	ObserverName() string
+}
+
+// eventMute - 停用範圍：all 為 true 時停用全部，否則只停用 names 中的觀察者
+// A silenced scope: everything when all is true, otherwise only the observers listed in names
+type eventMute struct {
+	all   bool
+	names map[string]bool
+}
+
+// muteKey - 停用範圍在 context 中的鍵
+// Context key of the silenced scope
+type muteKey struct{}
+
+// newEventMute 依名稱建立停用範圍，未指定名稱時停用全部。
+// newEventMute builds a silenced scope for names, or for every observer when names is empty.
+func newEventMute(names []string) *eventMute {
+	m := &eventMute{all: len(names) == 0, names: map[string]bool{}}
+	for _, name := range names {
+		m.names[name] = true
+	}
+	return m
+}
+
+// merge 合併兩個停用範圍。
+// merge combines two silenced scopes.
+func (m *eventMute) merge(other *eventMute) *eventMute {
+	if m == nil {
+		return other
+	}
+	if other == nil {
+		return m
+	}
+	merged := &eventMute{all: m.all || other.all, names: map[string]bool{}}
+	for name := range m.names {
+		merged.names[name] = true
+	}
+	for name := range other.names {
+		merged.names[name] = true
+	}
+	return merged
+}
+
+// silences 判斷觀察者是否在停用範圍內。
+// silences reports whether observer is silenced by the scope.
+func (m *eventMute) silences(observer ModelObserver) bool {
+	if m == nil {
+		return false
+	}
+	return m.all || m.names[observerName(observer)]
+}
+
+// observerName 回傳觀察者名稱：實作 NamedObserver 時為 ObserverName()，否則為型別名稱（例如 "examples.UserObserver"）。
+// observerName returns ObserverName() for a NamedObserver, otherwise the type name (e.g. "examples.UserObserver").
+func observerName(observer ModelObserver) string {
+	if n, ok := observer.(NamedObserver); ok {
+		return n.ObserverName()
+	}
+	return strings.TrimPrefix(reflect.TypeOf(observer).String(), "*")
+}
+
+// WithoutEvents 回傳停用觀察者的查詢副本：只有以回傳的查詢執行的操作不觸發觀察者，原本的查詢（或模型）之後仍正常觸發；
+// 指定名稱時只停用這些觀察者。
+// WithoutEvents returns a copy of this builder with observers silenced: only operations run on the returned builder
+// skip them, while this builder (or model) keeps firing events afterwards. When names are given only those are silenced.
+func (o *GODM) WithoutEvents(names ...string) *GODM {
+	q := o.clone()
+	q.mute = o.mute.merge(newEventMute(names))
+	return q
+}
+
+// WithoutEvents 在 fn 執行期間停用觀察者：所有以 fn 收到的 ctx（WithContext）執行的 GODM 操作都不會觸發觀察者；
+// 指定名稱時只停用這些觀察者。
+// WithoutEvents runs fn with observers silenced: every GODM operation that uses the ctx passed to fn
+// (via WithContext) skips observers; when names are given only those are silenced.
+func WithoutEvents(ctx context.Context, fn func(ctx context.Context) error, names ...string) error {
+	parent, _ := ctx.Value(muteKey{}).(*eventMute)
+	return fn(context.WithValue(ctx, muteKey{}, parent.merge(newEventMute(names))))
+}
+
+// silenced 判斷觀察者在此查詢（或其 context）中是否被停用。
+// silenced reports whether observer is silenced on this builder or its context.
+func (o *GODM) silenced(observer ModelObserver) bool {
+	if o.mute.silences(observer) {
+		return true
+	}
+	scoped, _ := o.getContext().Value(muteKey{}).(*eventMute)
+	return scoped.silences(observer)
+}
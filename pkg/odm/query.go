@@ -1,6 +1,8 @@
 package odm
 
 import (
+	"errors"
+	"fmt"
 	"log"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -84,6 +86,8 @@ func (o *GODM) buildFinalFilter() bson.D {
 		}}
 	} else if len(o.OrFilter) > 0 {
 		return bson.D{{Key: "$or", Value: o.OrFilter}}
+	} else if o.Filter == nil {
+		return bson.D{}
 	}
 	return o.Filter
 }
@@ -99,13 +103,20 @@ func (o *GODM) FilterToMap() map[string]interface{} {
 
 // WhereID filters by _id field. Returns error if model does not contain a primitive.ObjectID _id field.
 func (o *GODM) WhereID(id interface{}) *GODM {
+	if o.Model == nil {
+		o.queryErr = errors.New("WhereID error: model is not set")
+		log.Println(o.queryErr)
+		return nil
+	}
 	if !o.hasObjectIDField() {
-		log.Println("WhereID error: model does not contain a _id field of type primitive.ObjectID")
+		o.queryErr = errors.New("WhereID error: model does not contain a _id field of type primitive.ObjectID")
+		log.Println(o.queryErr)
 		return nil
 	}
 	objectID, err := parseObjectID(id)
 	if err != nil {
-		log.Printf("WhereID error: %v\n", err)
+		o.queryErr = fmt.Errorf("WhereID error: %w", err)
+		log.Println(o.queryErr)
 		return nil
 	}
 	return o.Where("_id", "=", objectID)
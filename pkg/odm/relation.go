@@ -1,5 +1,12 @@
 package odm
 
+import (
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
 // With 用於指定在查詢時需要預先載入的關聯（類似 Laravel 的 with()）
 // 例如：.With("posts", "comments") 會觸發對 posts 和 comments 的 $lookup
 //
@@ -14,8 +21,30 @@ func (m *GODM) With(relations ...string) *GODM {
 	return m
 }
 
-// SetRelationConfig 設定關聯查詢的配置，用於搭配 With() 執行 $lookup
-// SetRelationConfig sets relation configurations for use with With() lookups.
+// WithFn 預載入關聯並以回呼限制關聯文檔：回呼中可使用 Where、OrderBy、Limit、Offset、Select 與 With，
+// 會編譯為 $lookup 的 let/pipeline 形式。relation 可為點號路徑（例如 "posts.comments"）。
+// 例如：.WithFn("posts", func(q *odm.GODM) { q.Where("published", "=", true).OrderBy("created_at", false).Limit(5) })
+//
+// WithFn eager-loads a relation constrained by a callback: Where, OrderBy, Limit, Offset, Select and With
+// on q apply to the related collection and compile to the let/pipeline form of $lookup.
+// relation may be a dotted path (e.g. "posts.comments").
+func (m *GODM) WithFn(relation string, fn func(q *GODM)) *GODM {
+	if m.RelationConstraints == nil {
+		m.RelationConstraints = map[string]func(q *GODM){}
+	}
+	m.RelationConstraints[relation] = fn
+	for _, rel := range m.WithRelations {
+		if rel == relation {
+			return m
+		}
+	}
+	return m.With(relation)
+}
+
+// SetRelationConfig 設定關聯查詢的配置，用於搭配 With() 執行 $lookup；
+// 會覆寫模型以 struct tag（odm:"hasMany,..."）宣告的同名關聯。
+// SetRelationConfig sets relation configurations for use with With() lookups;
+// entries override relations of the same name declared with struct tags (odm:"hasMany,...").
 func (m *GODM) SetRelationConfig(configs map[string]RelationConfig) *GODM {
 	if m.RelationConfigs == nil {
 		m.RelationConfigs = map[string]RelationConfig{}
@@ -25,3 +54,207 @@ func (m *GODM) SetRelationConfig(configs map[string]RelationConfig) *GODM {
 	}
 	return m
 }
+
+// relationNode - 預載入關聯樹的節點，path 為完整的點號路徑（例如 "posts.comments"）
+// A node of the eager-loading tree; path is the full dotted path (e.g. "posts.comments")
+type relationNode struct {
+	path       string
+	conf       RelationConfig
+	constraint func(q *GODM)
+	children   []*relationNode
+}
+
+// relationTree 將 WithRelations 中的點號路徑（例如 "posts.comments.author"）解析為關聯樹。
+// 第一層從本模型解析，之後每一層從上一層關聯模型的設定解析；也可用完整路徑作為 SetRelationConfig 的鍵。
+// relationTree resolves the dotted paths in WithRelations (e.g. "posts.comments.author") into a tree.
+// The first segment resolves against this model, every further segment against the previous related model;
+// a full path can also be used as a SetRelationConfig key.
+func (m *GODM) relationTree() []*relationNode {
+	var roots []*relationNode
+	for _, rel := range m.WithRelations {
+		nodes := &roots
+		var parent *relationNode
+		segments := strings.Split(rel, ".")
+		for i, name := range segments {
+			path := strings.Join(segments[:i+1], ".")
+			var node *relationNode
+			for _, n := range *nodes {
+				if n.path == path {
+					node = n
+					break
+				}
+			}
+			if node == nil {
+				conf, ok := m.nestedRelationConfig(parent, path, name)
+				if !ok {
+					break
+				}
+				node = &relationNode{path: path, conf: conf, constraint: m.RelationConstraints[path]}
+				*nodes = append(*nodes, node)
+			}
+			parent = node
+			nodes = &node.children
+		}
+	}
+	return roots
+}
+
+// nestedRelationConfig 依上一層節點解析 name 對應的關聯設定。
+// nestedRelationConfig resolves the relation called name below parent.
+func (m *GODM) nestedRelationConfig(parent *relationNode, path, name string) (RelationConfig, bool) {
+	if parent == nil {
+		return m.relationConfig(name)
+	}
+	if conf, ok := m.RelationConfigs[path]; ok {
+		return conf, true
+	}
+	conf, ok := relationsOf(parent.conf.relatedType)[name]
+	return conf, ok
+}
+
+// buildRelationStages 依照 WithRelations 產生 $lookup（以及一對一時的 $unwind）階段。
+// buildRelationStages builds the $lookup (and $unwind for one-to-one) stages for WithRelations.
+func (m *GODM) buildRelationStages() []bson.M {
+	var stages []bson.M
+	for _, node := range m.relationTree() {
+		stages = append(stages, node.stages()...)
+	}
+	return stages
+}
+
+// stages 產生單一關聯節點的 $lookup 階段，以及一對一的 $unwind 或 refMany 的排序階段（限制條件自行排序時不產生）；
+// Preload 策略的節點不產生階段。
+// stages builds the $lookup stages of a node, followed by $unwind for singular relations or the refMany ordering
+// stage (left out when the constraint sorts on its own). Nodes using the Preload strategy produce no stages.
+func (n *relationNode) stages() []bson.M {
+	if n.preloaded() {
+		return nil
+	}
+	conf := n.conf
+	var lookup bson.M
+	switch conf.Type {
+	case MorphTo:
+		return n.morphToStages()
+	case BelongsToMany:
+		lookup = n.pivotLookup()
+	case MorphMany:
+		lookup = n.morphManyLookup()
+	default:
+		lookup = n.lookup()
+	}
+
+	stages := []bson.M{{"$lookup": lookup}}
+	if conf.Type == RefMany {
+		if q := n.constraintQuery(); q == nil || len(q.SortFields) == 0 {
+			stages = append(stages, n.refOrderStage())
+		}
+	} else if !conf.IsArray {
+		stages = append(stages, bson.M{
+			"$unwind": bson.M{
+				"path":                       "$" + conf.As,
+				"preserveNullAndEmptyArrays": true,
+			},
+		})
+	}
+	return stages
+}
+
+// lookup 產生一般關聯的 $lookup；有限制條件或巢狀關聯時使用 let/pipeline 形式並遞迴產生子階段。
+// lookup builds the $lookup of a plain relation; constraints and nested relations use the let/pipeline form recursively.
+func (n *relationNode) lookup() bson.M {
+	conf := n.conf
+	sub := n.subPipeline()
+	if sub == nil {
+		return bson.M{
+			"from":         conf.From,
+			"localField":   conf.LocalField,
+			"foreignField": conf.ForeignField,
+			"as":           conf.As,
+		}
+	}
+
+	match := bson.M{"$eq": bson.A{"$" + conf.ForeignField, "$$local"}}
+	if conf.Type == RefMany {
+		match = bson.M{"$in": bson.A{"$" + conf.ForeignField, bson.M{"$ifNull": bson.A{"$$local", bson.A{}}}}}
+	}
+	pipeline := []bson.M{
+		{"$match": bson.M{"$expr": match}},
+	}
+	return bson.M{
+		"from":     conf.From,
+		"let":      bson.M{"local": "$" + conf.LocalField},
+		"pipeline": append(pipeline, sub...),
+		"as":       conf.As,
+	}
+}
+
+// subPipeline 產生關聯集合上的子管道（限制條件、排序、分頁、巢狀關聯與投影），無需子管道時返回 nil。
+// subPipeline builds the sub-pipeline run on the related collection (constraint, sort, paging, nested
+// relations and projection), or nil when the simple localField/foreignField form is enough.
+func (n *relationNode) subPipeline() []bson.M {
+	children := n.children
+	q := n.constraintQuery()
+	if q != nil {
+		children = append(append([]*relationNode{}, children...), q.relationTree()...)
+	}
+	if q == nil && !hasLookups(children) {
+		return nil
+	}
+
+	stages := []bson.M{}
+	if q != nil {
+		if filter := q.buildFinalFilter(); len(filter) > 0 {
+			stages = append(stages, bson.M{"$match": filter})
+		}
+		if len(q.SortFields) > 0 {
+			stages = append(stages, bson.M{"$sort": q.SortFields})
+		}
+		if q.SkipCount > 0 {
+			stages = append(stages, bson.M{"$skip": q.SkipCount})
+		}
+		if q.LimitCount > 0 {
+			stages = append(stages, bson.M{"$limit": q.LimitCount})
+		}
+	}
+	for _, child := range children {
+		stages = append(stages, child.stages()...)
+	}
+	if q != nil {
+		if projection := relationProjection(q.Projection, children); projection != nil {
+			stages = append(stages, bson.M{"$project": projection})
+		}
+	}
+	return stages
+}
+
+// constraintQuery 以關聯模型建立查詢並套用 WithFn 限制條件，沒有限制條件時返回 nil。
+// constraintQuery builds a builder on the related model with the WithFn constraint applied, or nil without one.
+func (n *relationNode) constraintQuery() *GODM {
+	if n.constraint == nil {
+		return nil
+	}
+	q := &GODM{}
+	if n.conf.relatedType != nil {
+		q.Model = reflect.New(n.conf.relatedType).Interface()
+	}
+	n.constraint(q)
+	return q
+}
+
+// collectRelationModels 遞迴收集節點對應欄位中已預載入的關聯文檔（皆為指標），供 retrieved 事件使用。
+// collectRelationModels recursively collects pointers to the eager-loaded documents held by the fields of nodes,
+// used for retrieved events.
+func collectRelationModels(model interface{}, nodes []*relationNode) []interface{} {
+	var related []interface{}
+	for _, node := range nodes {
+		field, ok := fieldByBsonName(model, node.conf.As)
+		if !ok {
+			continue
+		}
+		for _, item := range elementPointers(field) {
+			related = append(related, item)
+			related = append(related, collectRelationModels(item, node.children)...)
+		}
+	}
+	return related
+}
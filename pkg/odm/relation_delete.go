@@ -0,0 +1,233 @@
+package odm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// relation_delete.go - 刪除時的參照動作：依關聯的 OnDelete 設定刪除、清空或阻止刪除關聯文檔
+// Referential actions on delete: related documents are deleted, nulled or block the delete, following the
+// OnDelete setting of each relation.
+//
+// 範例 / Example:
+//
+//	type User struct {
+//		Posts []Post `bson:"posts,omitempty" odm:"hasMany,foreignKey=user_id,onDelete=cascade"`
+//		Roles []Role `bson:"roles,omitempty" odm:"belongsToMany,onDelete=cascade"` // 只刪除中介文檔 / only the pivot documents
+//	}
+//
+// 只有外鍵存放在關聯集合的關聯（hasOne、hasMany、morphMany、belongsToMany 的中介文檔）會套用參照動作；
+// 有設定參照動作時，Delete / DeleteMany 會在交易中執行（已在交易中時沿用該交易）。
+// Only relations whose foreign key lives on the related side (hasOne, hasMany, morphMany and the pivot documents
+// of belongsToMany) take referential actions. When any is set, Delete / DeleteMany run inside a transaction
+// (joining the current one when the context already carries a session).
+
+// ReferentialAction - 刪除本模型時對關聯文檔採取的動作
+// What happens to related documents when this model is deleted
+type ReferentialAction string
+
+const (
+	// Cascade - 一併刪除關聯文檔（會觸發其 deleting / deleted 事件與它們自己的參照動作）
+	// Delete the related documents as well (firing their deleting / deleted events and their own referential actions)
+	Cascade ReferentialAction = "cascade"
+	// Restrict - 仍有關聯文檔時以 RestrictedDeleteError 拒絕刪除
+	// Refuse the delete with a RestrictedDeleteError while related documents exist
+	Restrict ReferentialAction = "restrict"
+	// SetNull - 將關聯文檔的外鍵設為 null（belongsToMany 則移除中介文檔）
+	// Set the foreign key of the related documents to null (belongsToMany removes the pivot documents)
+	SetNull ReferentialAction = "setNull"
+	// NoAction - 不處理關聯文檔（預設）
+	// Leave the related documents alone (the default)
+	NoAction ReferentialAction = "noAction"
+)
+
+// RestrictedDeleteError - 關聯設定為 Restrict 且仍有關聯文檔時，Delete / DeleteMany 回傳的錯誤
+// Returned by Delete / DeleteMany when a Restrict relation still has related documents
+type RestrictedDeleteError struct {
+	Model    string // 被刪除的模型型別 / type of the model being deleted
+	Relation string // 阻止刪除的關聯名稱 / the relation that blocked the delete
+	Count    int64  // 仍存在的關聯文檔數量 / number of related documents left
+}
+
+func (e *RestrictedDeleteError) Error() string {
+	return fmt.Sprintf("cannot delete %s: relation %q still has %d related document(s)", e.Model, e.Relation, e.Count)
+}
+
+// deleteActions 回傳設定了參照動作的關聯名稱（依名稱排序）。
+// deleteActions returns the names of the relations with a referential action, sorted by name.
+func (o *GODM) deleteActions() []string {
+	if o.Model == nil {
+		return nil
+	}
+	var names []string
+	for _, name := range o.relationNames() {
+		conf, _ := o.relationConfig(name)
+		if conf.OnDelete == "" || conf.OnDelete == NoAction {
+			continue
+		}
+		switch conf.Type {
+		case HasOne, HasMany, MorphMany, BelongsToMany:
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// withinTransaction 在交易中執行 fn：context 已在交易中時直接沿用，否則以 WithTransaction 開啟新交易。
+// withinTransaction runs fn inside a transaction: the transaction of the context is reused when active,
+// otherwise WithTransaction starts a new one.
+func (o *GODM) withinTransaction(fn func(q *GODM) error) error {
+	if activeSession(o.getContext()) != nil {
+		return fn(o)
+	}
+	return o.WithTransaction(func(sessCtx mongo.SessionContext) error {
+		q := *o
+		q.Ctx = sessCtx
+		return fn(&q)
+	})
+}
+
+// deleteParents 取回即將刪除的文檔，供參照動作取得本模型的鍵值。
+// deleteParents fetches the documents about to be deleted, so referential actions can read their keys.
+func (o *GODM) deleteParents(filter interface{}, single bool) ([]bson.M, error) {
+	var parents []bson.M
+	if single {
+		var doc bson.M
+		err := o.Collection.FindOne(o.getContext(), filter).Decode(&doc)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return append(parents, doc), nil
+	}
+	cursor, err := o.Collection.Find(o.getContext(), filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(o.getContext())
+	if err := cursor.All(o.getContext(), &parents); err != nil {
+		return nil, err
+	}
+	return parents, nil
+}
+
+// applyDeleteActions 對即將刪除的文檔執行每個關聯的參照動作。
+// applyDeleteActions runs the referential action of every relation for the documents about to be deleted.
+func (o *GODM) applyDeleteActions(parents []bson.M, relations []string) error {
+	for _, name := range relations {
+		conf, _ := o.relationConfig(name)
+		seen := map[interface{}]bool{}
+		var keys []interface{}
+		for _, parent := range parents {
+			if key, ok := normalizeKey(parent[conf.LocalField]); ok && !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		if err := o.applyDeleteAction(name, conf, keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyDeleteAction 對單一關聯中指向 keys 的關聯文檔（或中介文檔）執行參照動作。
+// applyDeleteAction runs the referential action on the related (or pivot) documents of one relation pointing at keys.
+func (o *GODM) applyDeleteAction(name string, conf RelationConfig, keys []interface{}) error {
+	if conf.Type == BelongsToMany {
+		pivots := o.pivotCollection(conf)
+		filter := bson.M{conf.PivotLocalKey: bson.M{"$in": keys}}
+		if conf.OnDelete == Restrict {
+			return o.restrictDelete(name, pivots, filter)
+		}
+		if _, err := pivots.DeleteMany(o.getContext(), filter); err != nil {
+			return fmt.Errorf("delete %s pivot error: %w", name, err)
+		}
+		return nil
+	}
+
+	var model interface{}
+	if conf.relatedType != nil {
+		model = reflect.New(conf.relatedType).Interface()
+	}
+	r := o.Relation(name)
+	q := r.query(model)
+	q.Filter = bson.D{{Key: conf.ForeignField, Value: bson.M{"$in": keys}}}
+	if conf.Type == MorphMany {
+		q.Filter = append(q.Filter, bson.E{Key: conf.MorphName + "_type", Value: conf.morphTypeName()})
+	}
+
+	switch conf.OnDelete {
+	case Restrict:
+		return o.restrictDelete(name, q.Collection, q.Filter)
+	case SetNull:
+		set := bson.M{conf.ForeignField: nil}
+		if conf.Type == MorphMany {
+			set[conf.MorphName+"_type"] = nil
+		}
+		return q.UpdateMany(set)
+	case Cascade:
+		if model == nil {
+			return q.DeleteMany()
+		}
+		return r.cascadeDelete(q)
+	}
+	return nil
+}
+
+// restrictDelete 仍有符合 filter 的文檔時回傳 RestrictedDeleteError。
+// restrictDelete returns a RestrictedDeleteError when documents matching filter still exist.
+func (o *GODM) restrictDelete(name string, collection *mongo.Collection, filter interface{}) error {
+	count, err := collection.CountDocuments(o.getContext(), filter)
+	if err != nil {
+		return fmt.Errorf("delete %s check error: %w", name, err)
+	}
+	if count > 0 {
+		return &RestrictedDeleteError{Model: fmt.Sprintf("%T", o.Model), Relation: name, Count: count}
+	}
+	return nil
+}
+
+// cascadeDelete 逐筆刪除 q 找到的關聯文檔，觸發它們的 deleting / deleted 事件與巢狀的參照動作。
+// cascadeDelete deletes the related documents found by q one by one, firing their deleting / deleted events and
+// their own referential actions.
+func (r *RelationBuilder) cascadeDelete(q *GODM) error {
+	cursor, err := q.Collection.Find(q.getContext(), q.Filter)
+	if err != nil {
+		return fmt.Errorf("delete %s error: %w", r.name, err)
+	}
+	var children []interface{}
+	var ids []interface{}
+	for cursor.Next(q.getContext()) {
+		child := reflect.New(r.conf.relatedType).Interface()
+		if err := cursor.Decode(child); err != nil {
+			cursor.Close(q.getContext())
+			return fmt.Errorf("decode error: %w (type = %T)", err, child)
+		}
+		children = append(children, child)
+		ids = append(ids, cursor.Current.Lookup("_id"))
+	}
+	err = cursor.Err()
+	cursor.Close(q.getContext())
+	if err != nil {
+		return fmt.Errorf("delete %s error: %w", r.name, err)
+	}
+
+	for i, child := range children {
+		cq := r.query(child)
+		cq.Filter = bson.D{{Key: "_id", Value: ids[i]}}
+		if err := cq.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
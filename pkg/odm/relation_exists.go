@@ -0,0 +1,236 @@
+package odm
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// relation_exists.go - 關聯存在查詢（Has / WhereHas / DoesntHave）與關聯聚合欄位（WithCount / WithSum / WithMax ...）
+// Relation existence queries (Has / WhereHas / DoesntHave) and relation aggregate fields (WithCount / WithSum / WithMax ...).
+//
+// 範例 / Example:
+//
+//	// 至少有 3 篇已發佈文章的使用者，並附上 posts_count
+//	odm.Use(&User{}).
+//		WhereHas("posts", func(q *odm.GODM) { q.Where("published", "=", true) }).
+//		Has("posts", ">=", 3).
+//		WithCount("posts").
+//		All(&users)
+//
+// 皆會編譯為 $lookup 到暫存欄位，再以 $size / $sum / $max 計算，最後移除暫存欄位。
+// Every call compiles to a $lookup into a temporary field, evaluated with $size / $sum / $max, and the
+// temporary field is removed afterwards.
+
+// relationTempPrefix - 關聯存在查詢與聚合欄位使用的暫存欄位前綴
+// Prefix of the temporary fields used by relation existence queries and aggregates
+const relationTempPrefix = "__rel_"
+
+// relationQuery - 單一關聯存在條件或聚合欄位
+// A single relation existence condition or aggregate field
+type relationQuery struct {
+	relation   string
+	constraint func(q *GODM)
+	op         string // 存在條件的比較運算子，聚合欄位為空 / comparison operator of an existence condition, empty for aggregates
+	count      int
+	accumulate string // 聚合運算子（$size、$sum、$max、$min、$avg） / aggregate operator ($size, $sum, $max, $min, $avg)
+	field      string // 被聚合的關聯欄位 / the related field being aggregated
+	as         string // 輸出欄位名稱 / output field name
+}
+
+// relationOperators - Has 支援的比較運算子
+// Comparison operators supported by Has
+var relationOperators = map[string]string{
+	"=":  "$eq",
+	"!=": "$ne",
+	">":  "$gt",
+	">=": "$gte",
+	"<":  "$lt",
+	"<=": "$lte",
+}
+
+// Has 依關聯文檔數量過濾本模型，例如 .Has("posts", ">=", 3)；op 可為 =、!=、>、>=、<、<=。
+// Has filters this model by the number of related documents, e.g. .Has("posts", ">=", 3); op is one of =, !=, >, >=, <, <=.
+func (o *GODM) Has(relation, op string, count int) *GODM {
+	return o.addRelationQuery(relationQuery{relation: relation, op: op, count: count})
+}
+
+// WhereHas 只保留至少有一筆符合回呼條件之關聯文檔的本模型；回呼中可使用 Where 等條件方法。
+// WhereHas keeps the models with at least one related document matching the callback; use Where and friends on q.
+func (o *GODM) WhereHas(relation string, fn func(q *GODM)) *GODM {
+	return o.addRelationQuery(relationQuery{relation: relation, constraint: fn, op: ">=", count: 1})
+}
+
+// DoesntHave 只保留沒有任何關聯文檔的本模型。
+// DoesntHave keeps the models without any related document.
+func (o *GODM) DoesntHave(relation string) *GODM {
+	return o.addRelationQuery(relationQuery{relation: relation, op: "=", count: 0})
+}
+
+// WhereDoesntHave 只保留沒有任何符合回呼條件之關聯文檔的本模型。
+// WhereDoesntHave keeps the models without any related document matching the callback.
+func (o *GODM) WhereDoesntHave(relation string, fn func(q *GODM)) *GODM {
+	return o.addRelationQuery(relationQuery{relation: relation, constraint: fn, op: "=", count: 0})
+}
+
+// WithCount 以 <relation>_count 欄位附上關聯文檔數量，例如 posts_count；可選擇以回呼限制計算的文檔。
+// WithCount adds the number of related documents as <relation>_count (e.g. posts_count); an optional callback
+// restricts which documents are counted.
+func (o *GODM) WithCount(relation string, fn ...func(q *GODM)) *GODM {
+	return o.addRelationQuery(relationQuery{
+		relation: relation, constraint: firstConstraint(fn), accumulate: "$size", as: relation + "_count",
+	})
+}
+
+// WithSum 以 <relation>_sum_<field> 欄位附上關聯文檔欄位的總和，例如 posts_sum_views。
+// WithSum adds the sum of a related field as <relation>_sum_<field> (e.g. posts_sum_views).
+func (o *GODM) WithSum(relation, field string, fn ...func(q *GODM)) *GODM {
+	return o.withAggregate(relation, "sum", field, fn)
+}
+
+// WithMax 以 <relation>_max_<field> 欄位附上關聯文檔欄位的最大值。
+// WithMax adds the maximum of a related field as <relation>_max_<field>.
+func (o *GODM) WithMax(relation, field string, fn ...func(q *GODM)) *GODM {
+	return o.withAggregate(relation, "max", field, fn)
+}
+
+// WithMin 以 <relation>_min_<field> 欄位附上關聯文檔欄位的最小值。
+// WithMin adds the minimum of a related field as <relation>_min_<field>.
+func (o *GODM) WithMin(relation, field string, fn ...func(q *GODM)) *GODM {
+	return o.withAggregate(relation, "min", field, fn)
+}
+
+// WithAvg 以 <relation>_avg_<field> 欄位附上關聯文檔欄位的平均值。
+// WithAvg adds the average of a related field as <relation>_avg_<field>.
+func (o *GODM) WithAvg(relation, field string, fn ...func(q *GODM)) *GODM {
+	return o.withAggregate(relation, "avg", field, fn)
+}
+
+func (o *GODM) withAggregate(relation, fn, field string, constraint []func(q *GODM)) *GODM {
+	return o.addRelationQuery(relationQuery{
+		relation:   relation,
+		constraint: firstConstraint(constraint),
+		accumulate: "$" + fn,
+		field:      field,
+		as:         relation + "_" + fn + "_" + field,
+	})
+}
+
+func (o *GODM) addRelationQuery(q relationQuery) *GODM {
+	o.relationQueries = append(o.relationQueries, q)
+	return o
+}
+
+func firstConstraint(fns []func(q *GODM)) func(q *GODM) {
+	if len(fns) > 0 {
+		return fns[0]
+	}
+	return nil
+}
+
+// usesAggregation 判斷查詢是否需要以聚合管道執行（預載入關聯、關聯存在條件或聚合欄位）。
+// usesAggregation reports whether the query has to run as an aggregation (eager loading, relation conditions or aggregates).
+func (o *GODM) usesAggregation() bool {
+	return len(o.WithRelations) > 0 || len(o.relationQueries) > 0
+}
+
+// checkRelationQueries 確認關聯存在條件與聚合欄位使用的關聯都已定義，且不是 morphTo（其集合依文檔而定，無法以 $lookup 查詢）。
+// checkRelationQueries makes sure the relations of the existence conditions and aggregates are defined and are not
+// morphTo, whose collection depends on each document and cannot be queried with $lookup.
+func (o *GODM) checkRelationQueries() error {
+	for _, rq := range o.relationQueries {
+		conf, ok := o.relationConfig(rq.relation)
+		if !ok {
+			return fmt.Errorf("relation %q is not defined", rq.relation)
+		}
+		if conf.Type == MorphTo {
+			return fmt.Errorf("relation %q is a morphTo relation, which relation conditions and aggregates cannot query", rq.relation)
+		}
+	}
+	return nil
+}
+
+// buildRelationQueryStages 產生關聯存在條件的階段（filters）與聚合欄位的階段（aggregates），兩者共用暫存欄位的清除階段。
+// 存在條件放在預載入之前以儘早過濾，聚合欄位放在之後以便排序使用。checkRelationQueries 不接受的關聯不產生階段，First、All 與 Count 會回傳其錯誤。
+// buildRelationQueryStages builds the stages of the existence conditions (filters) and of the aggregate fields
+// (aggregates). Conditions run before eager loading to filter early; aggregates run after it so they can be sorted on.
+// Relations rejected by checkRelationQueries are left out; First, All and Count return its error instead.
+func (o *GODM) buildRelationQueryStages() (filters, aggregates []bson.M) {
+	cleanup := bson.M{}
+	for i, rq := range o.relationQueries {
+		conf, ok := o.relationConfig(rq.relation)
+		if !ok || conf.Type == MorphTo {
+			continue
+		}
+		temp := fmt.Sprintf("%s%d", relationTempPrefix, i)
+		lookup := rq.lookupStages(conf, temp)
+		cleanup[temp] = 0
+
+		if rq.accumulate == "" {
+			operator, ok := relationOperators[rq.op]
+			if !ok {
+				operator = "$eq"
+			}
+			filters = append(filters, lookup...)
+			filters = append(filters, bson.M{"$match": bson.M{"$expr": bson.M{
+				operator: bson.A{bson.M{"$size": "$" + temp}, rq.count},
+			}}})
+			continue
+		}
+
+		value := bson.M{"$size": "$" + temp}
+		if rq.accumulate != "$size" {
+			value = bson.M{rq.accumulate: "$" + temp + "." + rq.field}
+		}
+		aggregates = append(aggregates, lookup...)
+		aggregates = append(aggregates, bson.M{"$addFields": bson.M{rq.as: value}})
+	}
+	if len(cleanup) == 0 {
+		return nil, nil
+	}
+	return filters, append(aggregates, bson.M{"$project": cleanup})
+}
+
+// lookupStages 產生把關聯文檔載入暫存欄位的 $lookup，只投影計算所需的欄位。
+// lookupStages builds the $lookup loading the related documents into temp, projecting only the fields needed.
+func (rq relationQuery) lookupStages(conf RelationConfig, temp string) []bson.M {
+	conf.As = temp
+	conf.IsArray = true
+	conf.Strategy = Lookup
+	constraint := rq.constraint
+	keep := "_id"
+	if rq.field != "" {
+		keep = rq.field
+	}
+	node := &relationNode{path: rq.relation, conf: conf, constraint: func(q *GODM) {
+		if constraint != nil {
+			constraint(q)
+		}
+		q.WithRelations = nil
+		q.Projection = bson.M{keep: 1, conf.ForeignField: 1}
+	}}
+	return node.stages()
+}
+
+// countRelationQuery 以聚合管道計算符合關聯存在條件的文檔數量。
+// countRelationQuery counts the documents matching the relation conditions with an aggregation.
+func (o *GODM) countRelationQuery(filters []bson.M) (int64, error) {
+	pipeline := append([]bson.M{{"$match": o.buildFinalFilter()}}, filters...)
+	pipeline = append(pipeline, bson.M{"$count": "count"})
+
+	cursor, err := o.Collection.Aggregate(o.getContext(), pipeline)
+	if err != nil {
+		return 0, fmt.Errorf("count error: %w", err)
+	}
+	defer cursor.Close(o.getContext())
+
+	var result struct {
+		Count int64 `bson:"count"`
+	}
+	if cursor.Next(o.getContext()) {
+		if err := cursor.Decode(&result); err != nil {
+			return 0, fmt.Errorf("count error: %w", err)
+		}
+	}
+	return result.Count, cursor.Err()
+}
@@ -0,0 +1,79 @@
+package odm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// relation_fields.go - 寫入時排除關聯欄位：預載入的關聯文檔只用於解碼，不會被 Create / Update 寫回本集合
+// Keeps relation fields out of writes: eager-loaded documents are only decoded, never written back to this
+// collection by Create / Update.
+
+// relationFields 回傳模型的關聯欄位（bson 名稱），包含 struct tag 宣告與 SetRelationConfig 設定的關聯。
+// relationFields returns the relation fields (bson keys) of model, declared with struct tags or set with SetRelationConfig.
+func (o *GODM) relationFields(model interface{}) map[string]bool {
+	fields := map[string]bool{}
+	for name := range relationsOf(reflect.TypeOf(model)) {
+		fields[name] = true
+	}
+	for name, conf := range o.RelationConfigs {
+		if strings.Contains(name, ".") {
+			continue
+		}
+		if conf.As != "" {
+			fields[conf.As] = true
+		} else {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// insertDocument 回傳要插入的文檔：模型沒有關聯欄位時為模型本身，否則為移除關聯欄位後的 bson.D。
+// insertDocument returns the document to insert: the model itself when it has no relation fields, otherwise
+// a bson.D without them.
+func (o *GODM) insertDocument(model interface{}) (interface{}, error) {
+	fields := o.relationFields(model)
+	if len(fields) == 0 {
+		return model, nil
+	}
+	raw, err := bson.Marshal(model)
+	if err != nil {
+		return nil, fmt.Errorf("encode error: %w", err)
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("encode error: %w", err)
+	}
+	kept := make(bson.D, 0, len(doc))
+	for _, e := range doc {
+		if !fields[e.Key] {
+			kept = append(kept, e)
+		}
+	}
+	return kept, nil
+}
+
+// withoutRelationFields 移除更新內容中指向關聯欄位的鍵（包含 "posts.0.title" 這類路徑），不修改傳入的 map。
+// withoutRelationFields drops the keys of updates that target relation fields (paths such as "posts.0.title"
+// included), leaving the given map untouched.
+func (o *GODM) withoutRelationFields(updates bson.M) bson.M {
+	if o.Model == nil {
+		return updates
+	}
+	fields := o.relationFields(o.Model)
+	if len(fields) == 0 {
+		return updates
+	}
+	kept := bson.M{}
+	for key, value := range updates {
+		root, _, _ := strings.Cut(key, ".")
+		if !fields[root] {
+			kept[key] = value
+		}
+	}
+	return kept
+}
@@ -0,0 +1,135 @@
+package odm
+
+import (
+	"fmt"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// relation_load.go - 延遲載入：為已取回的模型補載關聯（Load / LoadMany），以及取得限定於父模型的關聯查詢（Related）
+// Lazy loading: filling relations on models that were already fetched (Load / LoadMany), and query builders
+// scoped to a parent's relation (Related).
+//
+// 範例 / Example:
+//
+//	_ = user.Where("name", "=", "Alice").First()
+//	_ = user.Load("posts", "posts.comments")
+//
+//	_ = odm.LoadMany(users, "posts") // 每個關聯只查詢一次，避免 N+1 / one query per relation, no N+1
+//
+//	q, _ := user.Related("posts")
+//	_ = q.Where("published", "=", true).All(&posts)
+
+// Load 為已解碼的模型載入關聯，relations 可為點號路徑；一律以批次預載入（$in 查詢）執行，
+// 並觸發被載入文檔的 retrieved 事件。WithFn 設定的限制條件同樣適用。
+// Load fills relations on the already decoded model; relations may be dotted paths. Loading always uses the
+// batched strategy ($in queries) and fires retrieved events for the loaded documents. Constraints set with
+// WithFn apply as well.
+func (o *GODM) Load(relations ...string) error {
+	if o.Model == nil {
+		return fmt.Errorf("load error: model is not set")
+	}
+	return o.load(elementPointers(reflect.ValueOf(o.Model)), relations)
+}
+
+// LoadMany 為已解碼的模型切片（結構或結構指標的切片，或其指標）載入關聯，每個關聯只執行一次查詢。
+// 資料庫與關聯設定取自第一個元素內嵌的 GODM，未內嵌時使用全域 DBName 與 struct tag 宣告的關聯。
+// LoadMany fills relations on a slice of decoded models (structs or struct pointers, or a pointer to such a
+// slice) with one query per relation. The database and relation configs come from the GODM embedded in the
+// first element, or the global DBName and struct tag relations when it has none.
+func LoadMany(models interface{}, relations ...string) error {
+	items := elementPointers(reflect.ValueOf(models))
+	if len(items) == 0 {
+		return nil
+	}
+	base := &GODM{DBName: DBName}
+	if g := embeddedGODM(items[0]); g != nil {
+		copied := *g
+		base = &copied
+		if base.DBName == "" {
+			base.DBName = DBName
+		}
+	}
+	base.Model = items[0]
+	return base.load(items, relations)
+}
+
+// load 以批次預載入為 models 載入 relations，並解析多型關聯、觸發被載入文檔的 retrieved 事件。
+// load fills relations on models with the batched strategy, resolves polymorphic relations and fires
+// retrieved events for the loaded documents.
+func (o *GODM) load(models []interface{}, relations []string) error {
+	q := *o
+	q.WithRelations = relations
+	tree := q.relationTree()
+	for _, node := range tree {
+		node.conf.Strategy = Preload
+	}
+	if err := q.preloadRelations(models, tree); err != nil {
+		return err
+	}
+	for _, model := range models {
+		if err := resolveMorphs(model, tree); err != nil {
+			return err
+		}
+		if err := q.retrievedRelations(model, tree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Related 回傳關聯集合的查詢，已限定為此模型的關聯文檔；可繼續串接 Where、OrderBy 等方法。
+// Related returns a query on the related collection already scoped to this model's related documents;
+// Where, OrderBy and friends can be chained on it.
+func (o *GODM) Related(relation string) (*GODM, error) {
+	conf, ok := o.relationConfig(relation)
+	if !ok {
+		return nil, fmt.Errorf("relation %q is not defined", relation)
+	}
+	keys := relationKeys([]interface{}{o.Model}, conf.LocalField)
+
+	from, relatedType := conf.From, conf.relatedType
+	if conf.Type == MorphTo {
+		typeField, ok := fieldByBsonName(o.Model, conf.MorphName+"_type")
+		if !ok || typeField.Kind() != reflect.String {
+			return nil, fmt.Errorf("model %T has no field %q", o.Model, conf.MorphName+"_type")
+		}
+		morphMu.RLock()
+		entry, ok := morphByAlias[typeField.String()]
+		morphMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("morph type %q is not registered", typeField.String())
+		}
+		from, relatedType = entry.collection, entry.typ
+	}
+	if conf.Type == BelongsToMany {
+		related, err := o.pivotRelatedKeys(relation)
+		if err != nil {
+			return nil, err
+		}
+		keys = related
+	}
+
+	db := o.relatedDatabase(conf)
+	q := &GODM{
+		DBName:         db,
+		CollectionName: from,
+		Collection:     MongoClient.Database(db).Collection(from),
+		Filter:         bson.D{},
+		OrFilter:       []bson.M{},
+		Ctx:            o.Ctx,
+	}
+	if relatedType != nil {
+		q.Model = reflect.New(relatedType).Interface()
+	}
+
+	if keys == nil {
+		keys = []interface{}{}
+	}
+	q.WhereIn(conf.ForeignField, keys)
+	if conf.Type == MorphMany {
+		q.Where(conf.MorphName+"_type", "=", conf.morphTypeName())
+	}
+	return q, nil
+}
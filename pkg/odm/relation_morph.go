@@ -0,0 +1,289 @@
+package odm
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// relation_morph.go - 多型關聯（morphMany / morphTo）與多型類型註冊
+// Polymorphic relations (morphMany / morphTo) and the morph type registry.
+//
+// 範例 / Example:
+//
+//	odm.RegisterMorph("post", &Post{})
+//	odm.RegisterMorph("video", &Video{})
+//
+//	type Post struct {
+//		Comments []Comment `bson:"comments,omitempty" odm:"morphMany,morphName=commentable"`
+//	}
+//	type Comment struct {
+//		CommentableType string             `bson:"commentable_type"`
+//		CommentableID   primitive.ObjectID `bson:"commentable_id"`
+//		Commentable     interface{}        `bson:"commentable,omitempty" odm:"morphTo"`
+//	}
+//
+// morphTo 欄位解碼後會是對應模型的指標（例如 *Post），可用型別斷言取得。
+// After decoding, a morphTo field holds a pointer to the matching model (e.g. *Post), ready for a type switch.
+
+// morphPrefix - morphTo 查詢中暫存各類型結果的欄位前綴
+// Prefix of the temporary fields holding each type's result in a morphTo lookup
+const morphPrefix = "__morph_"
+
+// morphEntry - 已註冊的多型類型
+// A registered morph type
+type morphEntry struct {
+	typ        reflect.Type
+	collection string
+}
+
+var (
+	morphMu      sync.RWMutex
+	morphByAlias = map[string]morphEntry{}
+	morphByType  = map[reflect.Type]string{}
+)
+
+// RegisterMorph 註冊多型類型名稱與模型，名稱會存入 <morphName>_type 欄位。
+// 集合名稱使用模型上 SetCollectionName 設定的值，未設定時為預設集合名稱。
+// RegisterMorph registers a morph type name for a model; the name is what <morphName>_type stores.
+// The collection is the one set with SetCollectionName on the model, or the default collection name.
+func RegisterMorph(alias string, model interface{}) {
+	typ := indirectType(reflect.TypeOf(model))
+	collection := defaultCollectionName(typ)
+	if g := embeddedGODM(model); g != nil && g.CollectionName != "" {
+		collection = g.CollectionName
+	}
+
+	morphMu.Lock()
+	defer morphMu.Unlock()
+	morphByAlias[alias] = morphEntry{typ: typ, collection: collection}
+	morphByType[typ] = alias
+}
+
+// morphAlias 回傳模型型別註冊的多型類型名稱，未註冊時為型別名稱的 snake_case。
+// morphAlias returns the morph type name registered for typ, or its snake_case type name when unregistered.
+func morphAlias(typ reflect.Type) string {
+	typ = indirectType(typ)
+	morphMu.RLock()
+	alias, ok := morphByType[typ]
+	morphMu.RUnlock()
+	if ok {
+		return alias
+	}
+	return snakeCase(typ.Name())
+}
+
+// morphEntries 依名稱排序回傳所有已註冊的多型類型。
+// morphEntries returns every registered morph type, sorted by name.
+func morphEntries() ([]string, map[string]morphEntry) {
+	morphMu.RLock()
+	defer morphMu.RUnlock()
+	aliases := make([]string, 0, len(morphByAlias))
+	entries := make(map[string]morphEntry, len(morphByAlias))
+	for alias, entry := range morphByAlias {
+		aliases = append(aliases, alias)
+		entries[alias] = entry
+	}
+	sort.Strings(aliases)
+	return aliases, entries
+}
+
+// morphManyLookup 產生 morphMany 的 $lookup：關聯文檔的 <morphName>_id 等於本模型鍵值，且 <morphName>_type 為本模型類型。
+// morphManyLookup builds the morphMany $lookup: <morphName>_id matches the local key and <morphName>_type is this model's type.
+func (n *relationNode) morphManyLookup() bson.M {
+	conf := n.conf
+	pipeline := []bson.M{
+		{"$match": bson.M{"$expr": bson.M{"$and": bson.A{
+			bson.M{"$eq": bson.A{"$" + conf.ForeignField, "$$local"}},
+			bson.M{"$eq": bson.A{"$" + conf.MorphName + "_type", conf.morphTypeName()}},
+		}}}},
+	}
+	return bson.M{
+		"from":     conf.From,
+		"let":      bson.M{"local": "$" + conf.LocalField},
+		"pipeline": append(pipeline, n.subPipeline()...),
+		"as":       conf.As,
+	}
+}
+
+// morphTypeName 回傳 morphMany 關聯中本模型的類型名稱：MorphType，未設定時為註冊的名稱。
+// morphTypeName returns this model's type name for a morphMany relation: MorphType, or the registered name.
+func (conf RelationConfig) morphTypeName() string {
+	if conf.MorphType == "" && conf.ownerType != nil {
+		return morphAlias(conf.ownerType)
+	}
+	return conf.MorphType
+}
+
+// morphToStages 為每個已註冊的多型類型產生一個 $lookup，再將符合 <morphName>_type 的結果放入關聯欄位。
+// morphToStages builds one $lookup per registered morph type and moves the one matching <morphName>_type into the relation field.
+func (n *relationNode) morphToStages() []bson.M {
+	conf := n.conf
+	aliases, entries := morphEntries()
+	var stages []bson.M
+	candidates := bson.A{}
+	cleanup := bson.M{}
+	for _, alias := range aliases {
+		temp := morphPrefix + alias
+		stages = append(stages, bson.M{"$lookup": bson.M{
+			"from": entries[alias].collection,
+			"let":  bson.M{"id": "$" + conf.LocalField, "type": "$" + conf.MorphName + "_type"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$$type", alias}},
+					bson.M{"$eq": bson.A{"$" + conf.ForeignField, "$$id"}},
+				}}}},
+			},
+			"as": temp,
+		}})
+		candidates = append(candidates, "$"+temp)
+		cleanup[temp] = 0
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return append(stages,
+		bson.M{"$addFields": bson.M{conf.As: bson.M{"$arrayElemAt": bson.A{bson.M{"$concatArrays": candidates}, 0}}}},
+		bson.M{"$project": cleanup},
+	)
+}
+
+// resolveMorphs 將已解碼的 morphTo 欄位（bson 文檔）依 <morphName>_type 轉為註冊模型的指標，包含巢狀關聯。
+// resolveMorphs converts decoded morphTo fields (raw bson documents) into pointers to the registered model
+// named by <morphName>_type, nested relations included.
+func resolveMorphs(model interface{}, nodes []*relationNode) error {
+	for _, node := range nodes {
+		field, ok := fieldByBsonName(model, node.conf.As)
+		if !ok {
+			continue
+		}
+		if node.conf.Type != MorphTo {
+			for _, item := range elementPointers(field) {
+				if err := resolveMorphs(item, node.children); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := resolveMorph(model, field, node.conf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveMorph 轉換單一 morphTo 欄位。
+// resolveMorph converts a single morphTo field.
+func resolveMorph(model interface{}, field reflect.Value, conf RelationConfig) error {
+	if field.Kind() != reflect.Interface || field.IsNil() || !field.CanSet() || field.Elem().Kind() == reflect.Ptr {
+		return nil
+	}
+	typeField, ok := fieldByBsonName(model, conf.MorphName+"_type")
+	if !ok || typeField.Kind() != reflect.String {
+		return nil
+	}
+	morphMu.RLock()
+	entry, ok := morphByAlias[typeField.String()]
+	morphMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("morph type %q is not registered", typeField.String())
+	}
+
+	raw, err := bson.Marshal(field.Interface())
+	if err != nil {
+		return fmt.Errorf("morph %s encode error: %w", conf.As, err)
+	}
+	target := reflect.New(entry.typ)
+	if err := bson.Unmarshal(raw, target.Interface()); err != nil {
+		return fmt.Errorf("morph %s decode error: %w", conf.As, err)
+	}
+	if !target.Type().AssignableTo(field.Type()) {
+		return nil
+	}
+	field.Set(target)
+	return nil
+}
+
+// preloadMorphTo 依 <morphName>_type 將模型分組，依類型名稱的順序對每個類型的集合以一次 $in 查詢載入，並設為對應模型的指標。
+// preloadMorphTo groups models by <morphName>_type, loads each type's collection with a single $in query, in the
+// order of the type names, and sets the field to a pointer of the registered model.
+func (o *GODM) preloadMorphTo(models []interface{}, conf RelationConfig) error {
+	keys := map[string][]interface{}{}
+	for _, model := range models {
+		typeField, ok := fieldByBsonName(model, conf.MorphName+"_type")
+		if !ok || typeField.Kind() != reflect.String || typeField.String() == "" {
+			continue
+		}
+		alias := typeField.String()
+		keys[alias] = append(keys[alias], relationKeys([]interface{}{model}, conf.LocalField)...)
+	}
+
+	aliases := make([]string, 0, len(keys))
+	for alias := range keys {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	docs := map[string]map[interface{}]bson.M{}
+	for _, alias := range aliases {
+		ids := keys[alias]
+		morphMu.RLock()
+		entry, ok := morphByAlias[alias]
+		morphMu.RUnlock()
+		if !ok {
+			return fmt.Errorf("morph type %q is not registered", alias)
+		}
+		cursor, err := o.relatedCollection(conf, entry.collection).Find(o.getContext(), bson.M{conf.ForeignField: bson.M{"$in": ids}})
+		if err != nil {
+			return fmt.Errorf("preload %s error: %w", conf.As, err)
+		}
+		var found []bson.M
+		err = cursor.All(o.getContext(), &found)
+		cursor.Close(o.getContext())
+		if err != nil {
+			return fmt.Errorf("preload %s error: %w", conf.As, err)
+		}
+		docs[alias] = map[interface{}]bson.M{}
+		for _, doc := range found {
+			if key, ok := normalizeKey(doc[conf.ForeignField]); ok {
+				docs[alias][key] = doc
+			}
+		}
+	}
+
+	for _, model := range models {
+		field, ok := fieldByBsonName(model, conf.As)
+		if !ok || field.Kind() != reflect.Interface || !field.CanSet() {
+			continue
+		}
+		field.Set(reflect.Zero(field.Type()))
+		typeField, _ := fieldByBsonName(model, conf.MorphName+"_type")
+		if typeField.Kind() != reflect.String {
+			continue
+		}
+		for _, key := range relationKeys([]interface{}{model}, conf.LocalField) {
+			doc, ok := docs[typeField.String()][key]
+			if !ok {
+				continue
+			}
+			morphMu.RLock()
+			entry := morphByAlias[typeField.String()]
+			morphMu.RUnlock()
+			raw, err := bson.Marshal(doc)
+			if err != nil {
+				return fmt.Errorf("morph %s encode error: %w", conf.As, err)
+			}
+			target := reflect.New(entry.typ)
+			if err := bson.Unmarshal(raw, target.Interface()); err != nil {
+				return fmt.Errorf("morph %s decode error: %w", conf.As, err)
+			}
+			if target.Type().AssignableTo(field.Type()) {
+				field.Set(target)
+			}
+		}
+	}
+	return nil
+}
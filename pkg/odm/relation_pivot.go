@@ -0,0 +1,273 @@
+package odm
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// relation_pivot.go - 多對多（belongsToMany）關聯：透過中介集合預載入，以及 Attach / Detach / Sync / Toggle
+// Many-to-many (belongsToMany) relations: eager loading through the pivot collection and Attach / Detach / Sync / Toggle.
+
+// pivotRelatedField - 中介集合查詢中暫存關聯文檔的欄位
+// Temporary field holding the related document while joining through the pivot collection
+const pivotRelatedField = "__related"
+
+// SyncResult - Sync 與 Toggle 的結果
+// Result of Sync and Toggle
+type SyncResult struct {
+	Attached []interface{} // 新增連結的關聯鍵 / related keys that were attached
+	Detached []interface{} // 移除連結的關聯鍵 / related keys that were detached
+}
+
+// pivotLookup 產生兩段式的 $lookup：先查中介集合，再由每筆中介文檔查關聯集合，
+// 並將關聯文檔提升為根文檔，PivotFields 指定的中介欄位放在 pivot 子文檔中。
+// 限制條件與巢狀關聯接在提升之後，因此排序與筆數限制作用於整個關聯結果。
+// pivotLookup builds the two-stage $lookup: the pivot collection first, then the related collection for every
+// pivot document. The related document is promoted to the root, with the PivotFields kept in a pivot sub-document.
+// Constraints and nested relations follow the promotion, so sorting and limits apply to the whole relation.
+func (n *relationNode) pivotLookup() bson.M {
+	conf := n.conf
+	newRoot := interface{}("$" + pivotRelatedField)
+	if len(conf.PivotFields) > 0 {
+		pivot := bson.M{}
+		for _, field := range conf.PivotFields {
+			pivot[field] = "$" + field
+		}
+		newRoot = bson.M{"$mergeObjects": bson.A{"$" + pivotRelatedField, bson.M{"pivot": pivot}}}
+	}
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$" + conf.PivotLocalKey, "$$local"}}}},
+		{"$lookup": bson.M{
+			"from": conf.From,
+			"let":  bson.M{"related": "$" + conf.PivotForeignKey},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$" + conf.ForeignField, "$$related"}}}},
+			},
+			"as": pivotRelatedField,
+		}},
+		{"$unwind": "$" + pivotRelatedField},
+		{"$replaceRoot": bson.M{"newRoot": newRoot}},
+	}
+	pipeline = append(pipeline, n.subPipeline()...)
+
+	return bson.M{
+		"from":     conf.Pivot,
+		"let":      bson.M{"local": "$" + conf.LocalField},
+		"pipeline": pipeline,
+		"as":       conf.As,
+	}
+}
+
+// Attach 為多對多關聯新增中介文檔，pivot 為額外寫入的中介欄位；已存在的連結只會更新 pivot 欄位。
+// ObjectID 的十六進位字串會以該 ObjectID 寫入。
+// Attach links related keys through the pivot collection; pivot holds extra pivot fields.
+// Existing links are kept and only get their pivot fields updated. The hex string of an ObjectID is written as
+// that ObjectID.
+func (o *GODM) Attach(relation string, ids []interface{}, pivot bson.M) error {
+	conf, local, err := o.pivotRelation(relation)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	var writes []mongo.WriteModel
+	for _, id := range ids {
+		filter := bson.M{conf.PivotLocalKey: local, conf.PivotForeignKey: pivotValue(id)}
+		update := bson.M{"$setOnInsert": filter}
+		if len(pivot) > 0 {
+			update["$set"] = pivot
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true))
+	}
+	if _, err := o.pivotCollection(conf).BulkWrite(o.getContext(), writes); err != nil {
+		return fmt.Errorf("attach error: %w", err)
+	}
+	return nil
+}
+
+// Detach 移除多對多關聯的中介文檔；未指定 ids 時移除本模型的所有連結。ids 的轉換與 Attach 相同。
+// Detach removes pivot documents of the relation; without ids every link of this model is removed. ids are
+// converted like Attach does.
+func (o *GODM) Detach(relation string, ids ...interface{}) error {
+	conf, local, err := o.pivotRelation(relation)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{conf.PivotLocalKey: local}
+	if len(ids) > 0 {
+		filter[conf.PivotForeignKey] = bson.M{"$in": pivotValues(ids)}
+	}
+	if _, err := o.pivotCollection(conf).DeleteMany(o.getContext(), filter); err != nil {
+		return fmt.Errorf("detach error: %w", err)
+	}
+	return nil
+}
+
+// Sync 讓多對多關聯只連結 ids：移除不在 ids 中的連結，並新增缺少的連結；移除與新增在同一個交易中執行。
+// Sync makes the relation link exactly ids: links not in ids are detached and missing ones attached, both in one
+// transaction.
+func (o *GODM) Sync(relation string, ids []interface{}) (*SyncResult, error) {
+	var result *SyncResult
+	err := o.withinTransaction(func(q *GODM) error {
+		current, err := q.pivotRelatedKeys(relation)
+		if err != nil {
+			return err
+		}
+		result = &SyncResult{
+			Attached: keysNotIn(ids, current),
+			Detached: keysNotIn(current, ids),
+		}
+		return q.relink(relation, result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Toggle 切換多對多關聯：已連結的 ids 會被移除，未連結的會被新增；移除與新增在同一個交易中執行。
+// Toggle flips the links of ids: linked keys are detached and unlinked ones attached, both in one transaction.
+func (o *GODM) Toggle(relation string, ids []interface{}) (*SyncResult, error) {
+	var result *SyncResult
+	err := o.withinTransaction(func(q *GODM) error {
+		current, err := q.pivotRelatedKeys(relation)
+		if err != nil {
+			return err
+		}
+		result = &SyncResult{
+			Attached: keysNotIn(ids, current),
+			Detached: keysIn(current, ids),
+		}
+		return q.relink(relation, result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// relink 依 result 移除與新增中介文檔。
+// relink detaches and attaches the pivot documents listed in result.
+func (o *GODM) relink(relation string, result *SyncResult) error {
+	if len(result.Detached) > 0 {
+		if err := o.Detach(relation, result.Detached...); err != nil {
+			return err
+		}
+	}
+	return o.Attach(relation, result.Attached, nil)
+}
+
+// pivotRelation 取得多對多關聯設定與本模型的關聯鍵值。
+// pivotRelation returns the belongsToMany config and this model's local key value.
+func (o *GODM) pivotRelation(relation string) (RelationConfig, interface{}, error) {
+	conf, ok := o.relationConfig(relation)
+	if !ok {
+		return RelationConfig{}, nil, fmt.Errorf("relation %q is not defined", relation)
+	}
+	if conf.Type != BelongsToMany {
+		return RelationConfig{}, nil, fmt.Errorf("relation %q is not a belongsToMany relation", relation)
+	}
+	field, ok := fieldByBsonName(o.Model, conf.LocalField)
+	if !ok {
+		return RelationConfig{}, nil, fmt.Errorf("model %T has no field %q", o.Model, conf.LocalField)
+	}
+	return conf, field.Interface(), nil
+}
+
+// pivotCollection 回傳多對多關聯的中介集合，與關聯集合位於同一個資料庫（未設定 Database 時為本模型的資料庫）。
+// pivotCollection returns the pivot collection of a belongsToMany relation, in the database of the related
+// collection (this model's database when Database is unset).
+func (o *GODM) pivotCollection(conf RelationConfig) *mongo.Collection {
+	return o.relatedCollection(conf, conf.Pivot)
+}
+
+// pivotRelatedKeys 查詢本模型目前在中介集合中連結的關聯鍵。
+// pivotRelatedKeys returns the related keys currently linked to this model in the pivot collection.
+func (o *GODM) pivotRelatedKeys(relation string) ([]interface{}, error) {
+	conf, local, err := o.pivotRelation(relation)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := o.pivotCollection(conf).Distinct(o.getContext(), conf.PivotForeignKey, bson.M{conf.PivotLocalKey: local})
+	if err != nil {
+		return nil, fmt.Errorf("pivot query error: %w", err)
+	}
+	return keys, nil
+}
+
+// pivotKey 將關聯鍵正規化以便比較：整數統一為 int64，ObjectID 的十六進位字串視為該 ObjectID。
+// pivotKey normalizes a related key for comparison: integers become int64 and the hex string of an ObjectID
+// counts as that ObjectID.
+func pivotKey(v interface{}) (interface{}, bool) {
+	return normalizeKey(pivotValue(v))
+}
+
+// pivotValue 回傳寫入資料庫的關聯鍵：ObjectID 的十六進位字串轉為該 ObjectID，其餘不變，與 pivotKey 的比較一致。
+// pivotValue returns the related key as written to the database: the hex string of an ObjectID becomes that
+// ObjectID and anything else is kept, matching how pivotKey compares keys.
+func pivotValue(v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		if id, err := primitive.ObjectIDFromHex(s); err == nil {
+			return id
+		}
+	}
+	return v
+}
+
+// pivotValues 對每個鍵套用 pivotValue。
+// pivotValues applies pivotValue to every key.
+func pivotValues(keys []interface{}) []interface{} {
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		values[i] = pivotValue(k)
+	}
+	return values
+}
+
+// keysNotIn 回傳 keys 中不存在於 others 的鍵（依 pivotKey 比較，保留 keys 中的原值）。
+// keysNotIn returns the keys that are not present in others, compared with pivotKey and kept as given in keys.
+func keysNotIn(keys, others []interface{}) []interface{} {
+	seen := make(map[interface{}]bool, len(others))
+	for _, k := range others {
+		if nk, ok := pivotKey(k); ok {
+			seen[nk] = true
+		}
+	}
+	var diff []interface{}
+	for _, k := range keys {
+		nk, ok := pivotKey(k)
+		if !ok {
+			diff = append(diff, k)
+			continue
+		}
+		if !seen[nk] {
+			diff = append(diff, k)
+			seen[nk] = true
+		}
+	}
+	return diff
+}
+
+// keysIn 回傳 keys 中同時存在於 others 的鍵（依 pivotKey 比較，保留 keys 中的原值）。
+// keysIn returns the keys that are also present in others, compared with pivotKey and kept as given in keys.
+func keysIn(keys, others []interface{}) []interface{} {
+	seen := make(map[interface{}]bool, len(others))
+	for _, k := range others {
+		if nk, ok := pivotKey(k); ok {
+			seen[nk] = true
+		}
+	}
+	var common []interface{}
+	for _, k := range keys {
+		if nk, ok := pivotKey(k); ok && seen[nk] {
+			common = append(common, k)
+			delete(seen, nk)
+		}
+	}
+	return common
+}
@@ -0,0 +1,482 @@
+package odm
+
+import (
+	"fmt"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// relation_preload.go - 批次預載入策略：父文檔取回後，每個關聯以一次 $in 查詢載入並以反射填回欄位
+// The batched eager-loading strategy: once the parents are fetched, every relation is loaded with a single
+// $in query and stitched back into the parent structs by reflection.
+//
+// 適用於分片集合或位於其他資料庫的關聯（$lookup 無法跨資料庫）。
+// Meant for sharded collections and for relations living in another database, which $lookup cannot reach.
+//
+// 範例 / Example:
+//
+//	type Order struct {
+//		Events []Event `bson:"events,omitempty" odm:"hasMany,strategy=preload,db=analytics"`
+//	}
+//
+//	odm.Use(&Order{}).SetRelationConfig(map[string]odm.RelationConfig{
+//		"events": {From: "events", LocalField: "_id", ForeignField: "order_id", As: "events", IsArray: true,
+//			Strategy: odm.Preload, Database: "analytics"},
+//	})
+
+// LoadStrategy - 關聯的預載入方式
+// How a relation is eager loaded
+type LoadStrategy string
+
+const (
+	// Lookup - 在同一個聚合管道中以 $lookup 載入（預設）
+	// Loaded with $lookup inside the same aggregation pipeline (the default)
+	Lookup LoadStrategy = "lookup"
+	// Preload - 父文檔取回後，以一次 $in 查詢載入關聯集合（可位於其他資料庫）
+	// Loaded after the parents with one $in query on the related collection, which may live in another database
+	Preload LoadStrategy = "preload"
+)
+
+// preloaded 判斷節點是否使用批次預載入。
+// preloaded reports whether the node uses the batched strategy.
+func (n *relationNode) preloaded() bool {
+	return n.conf.Strategy == Preload
+}
+
+// hasPreloaded 判斷節點或其子節點中是否有使用批次預載入的關聯。
+// hasPreloaded reports whether any of nodes or their children uses the batched strategy.
+func hasPreloaded(nodes []*relationNode) bool {
+	for _, node := range nodes {
+		if node.preloaded() || hasPreloaded(node.children) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasLookups 判斷節點中是否有以 $lookup 載入的關聯。
+// hasLookups reports whether any of nodes is loaded through $lookup.
+func hasLookups(nodes []*relationNode) bool {
+	for _, node := range nodes {
+		if !node.preloaded() {
+			return true
+		}
+	}
+	return false
+}
+
+// preloadRelations 為已解碼的模型（皆為結構指標）載入使用 Preload 策略的關聯，並遞迴處理巢狀關聯。
+// preloadRelations loads the relations using the Preload strategy into decoded models (struct pointers),
+// recursing into nested relations.
+func (o *GODM) preloadRelations(models []interface{}, nodes []*relationNode) error {
+	if len(models) == 0 || !hasPreloaded(nodes) {
+		return nil
+	}
+	for _, node := range nodes {
+		children := node.children
+		if node.preloaded() {
+			nested, err := o.preloadNode(models, node)
+			if err != nil {
+				return err
+			}
+			children = append(append([]*relationNode{}, children...), nested...)
+		}
+		if !hasPreloaded(children) {
+			continue
+		}
+		var items []interface{}
+		for _, model := range models {
+			if field, ok := fieldByBsonName(model, node.conf.As); ok {
+				items = append(items, elementPointers(field)...)
+			}
+		}
+		// 巢狀關聯的預設資料庫為上一層關聯所在的資料庫 / nested relations default to the database of their parent relation
+		level := *o
+		level.Collection = nil
+		level.DBName = o.relatedDatabase(node.conf)
+		if err := level.preloadRelations(items, children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preloadNode 以一次 $in 查詢載入單一關聯並填回每個模型，回傳限制條件中以 With 指定的巢狀關聯。
+// preloadNode loads one relation with a single $in query and fills it into every model;
+// it returns the nested relations requested with With inside the constraint.
+func (o *GODM) preloadNode(models []interface{}, node *relationNode) ([]*relationNode, error) {
+	conf := node.conf
+	if conf.Type == MorphTo {
+		return nil, o.preloadMorphTo(models, conf)
+	}
+	q := &GODM{}
+	if conf.relatedType != nil {
+		q.Model = reflect.New(conf.relatedType).Interface()
+	}
+	if node.constraint != nil {
+		node.constraint(q)
+	}
+	nested := q.relationTree()
+
+	keys := relationKeys(models, conf.LocalField)
+	var links map[interface{}]map[interface{}]bson.M
+	if conf.Type == BelongsToMany && len(keys) > 0 {
+		var err error
+		if links, keys, err = o.preloadPivots(conf, keys); err != nil {
+			return nil, err
+		}
+	}
+
+	var docs []bson.M
+	if len(keys) > 0 {
+		var err error
+		children := append(append([]*relationNode{}, node.children...), nested...)
+		if docs, err = o.preloadQuery(conf, q, children, keys); err != nil {
+			return nil, err
+		}
+	}
+
+	byKey := map[interface{}][]bson.M{}
+	for _, doc := range docs {
+		if key, ok := normalizeKey(doc[conf.ForeignField]); ok {
+			byKey[key] = append(byKey[key], doc)
+		}
+	}
+	for _, model := range models {
+		field, ok := fieldByBsonName(model, conf.As)
+		if !ok || !field.CanSet() {
+			continue
+		}
+		var related []bson.M
+		switch conf.Type {
+		case BelongsToMany:
+			related = pivotMatches(model, conf, docs, links)
+		case RefMany:
+			related = refMatches(model, conf, docs, byKey, len(q.SortFields) > 0)
+		default:
+			for _, key := range relationKeys([]interface{}{model}, conf.LocalField) {
+				related = byKey[key]
+			}
+		}
+		if conf.IsArray {
+			related = pageDocuments(related, q.SkipCount, q.LimitCount)
+		}
+		if err := setRelationField(field, related, conf.IsArray); err != nil {
+			return nil, fmt.Errorf("preload %s decode error: %w", conf.As, err)
+		}
+	}
+	return nested, nil
+}
+
+// preloadQuery 在關聯集合上執行 $in 查詢，套用限制條件、排序與以 $lookup 載入的巢狀關聯。
+// preloadQuery runs the $in query on the related collection with the constraint, sorting and the nested
+// relations loaded through $lookup.
+func (o *GODM) preloadQuery(conf RelationConfig, q *GODM, children []*relationNode, keys []interface{}) ([]bson.M, error) {
+	match := bson.M{conf.ForeignField: bson.M{"$in": keys}}
+	if conf.Type == MorphMany {
+		match[conf.MorphName+"_type"] = conf.morphTypeName()
+	}
+	pipeline := []bson.M{{"$match": match}}
+	if filter := q.buildFinalFilter(); len(filter) > 0 {
+		pipeline = append(pipeline, bson.M{"$match": filter})
+	}
+	if len(q.SortFields) > 0 {
+		pipeline = append(pipeline, bson.M{"$sort": q.SortFields})
+	}
+	for _, child := range children {
+		pipeline = append(pipeline, child.stages()...)
+	}
+	if projection := relationProjection(q.Projection, children); projection != nil {
+		projection[conf.ForeignField] = 1
+		pipeline = append(pipeline, bson.M{"$project": projection})
+	}
+
+	cursor, err := o.relatedCollection(conf, conf.From).Aggregate(o.getContext(), pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("preload %s error: %w", conf.As, err)
+	}
+	defer cursor.Close(o.getContext())
+
+	var docs []bson.M
+	if err := cursor.All(o.getContext(), &docs); err != nil {
+		return nil, fmt.Errorf("preload %s error: %w", conf.As, err)
+	}
+	return docs, nil
+}
+
+// preloadPivots 查詢多對多關聯的中介文檔，回傳「本模型鍵 -> 關聯鍵 -> 中介文檔」的對照與所有關聯鍵。
+// preloadPivots fetches the pivot documents of a belongsToMany relation and returns the
+// local key -> related key -> pivot document links together with every related key.
+func (o *GODM) preloadPivots(conf RelationConfig, keys []interface{}) (map[interface{}]map[interface{}]bson.M, []interface{}, error) {
+	cursor, err := o.pivotCollection(conf).Find(o.getContext(), bson.M{conf.PivotLocalKey: bson.M{"$in": keys}})
+	if err != nil {
+		return nil, nil, fmt.Errorf("pivot query error: %w", err)
+	}
+	defer cursor.Close(o.getContext())
+
+	var pivots []bson.M
+	if err := cursor.All(o.getContext(), &pivots); err != nil {
+		return nil, nil, fmt.Errorf("pivot query error: %w", err)
+	}
+	links := map[interface{}]map[interface{}]bson.M{}
+	seen := map[interface{}]bool{}
+	var related []interface{}
+	for _, pivot := range pivots {
+		local, ok := normalizeKey(pivot[conf.PivotLocalKey])
+		if !ok {
+			continue
+		}
+		foreign, ok := normalizeKey(pivot[conf.PivotForeignKey])
+		if !ok {
+			continue
+		}
+		if links[local] == nil {
+			links[local] = map[interface{}]bson.M{}
+		}
+		links[local][foreign] = pivot
+		if !seen[foreign] {
+			seen[foreign] = true
+			related = append(related, foreign)
+		}
+	}
+	return links, related, nil
+}
+
+// pivotMatches 依查詢順序回傳連結到模型的關聯文檔，PivotFields 放在 pivot 子文檔中。
+// pivotMatches returns the related documents linked to model in query order, with the PivotFields in a pivot sub-document.
+func pivotMatches(model interface{}, conf RelationConfig, docs []bson.M, links map[interface{}]map[interface{}]bson.M) []bson.M {
+	var related []bson.M
+	for _, local := range relationKeys([]interface{}{model}, conf.LocalField) {
+		linked := links[local]
+		for _, doc := range docs {
+			key, ok := normalizeKey(doc[conf.ForeignField])
+			if !ok {
+				continue
+			}
+			p, ok := linked[key]
+			if !ok {
+				continue
+			}
+			if len(conf.PivotFields) > 0 {
+				merged := bson.M{}
+				for k, v := range doc {
+					merged[k] = v
+				}
+				pivot := bson.M{}
+				for _, field := range conf.PivotFields {
+					pivot[field] = p[field]
+				}
+				merged["pivot"] = pivot
+				doc = merged
+			}
+			related = append(related, doc)
+		}
+	}
+	return related
+}
+
+// relatedCollection 回傳關聯所在資料庫中的集合。
+// relatedCollection returns the named collection in the relation's database.
+func (o *GODM) relatedCollection(conf RelationConfig, name string) *mongo.Collection {
+	return MongoClient.Database(o.relatedDatabase(conf)).Collection(name)
+}
+
+// relatedDatabase 回傳關聯所在的資料庫：未設定 Database 時為本查詢集合所在的資料庫（巢狀預載入時為上一層關聯的資料庫）。
+// relatedDatabase returns the relation's database: when Database is unset, the database of this builder's
+// collection (of the parent relation, when preloading nested relations).
+func (o *GODM) relatedDatabase(conf RelationConfig) string {
+	if conf.Database != "" {
+		return conf.Database
+	}
+	return o.database()
+}
+
+// database 回傳本查詢集合所在的資料庫名稱。
+// database returns the name of the database holding this builder's collection.
+func (o *GODM) database() string {
+	if o.Collection != nil {
+		return o.Collection.Database().Name()
+	}
+	return o.DBName
+}
+
+// checkLookupDatabases 確認以 $lookup 執行的關聯（Lookup 策略的預載入、關聯存在條件與聚合欄位）與上一層位於同一個資料庫：
+// $lookup 無法跨資料庫，設定其他 Database 的關聯需使用 Preload 策略，也不能用於 Has / WhereHas / WithCount 等。
+// checkLookupDatabases makes sure every relation run through $lookup (Lookup-strategy eager loading, relation
+// conditions and aggregates) lives in the same database as its parent: $lookup cannot cross databases, so a
+// relation with another Database needs the Preload strategy and cannot be used with Has / WhereHas / WithCount.
+func (o *GODM) checkLookupDatabases() error {
+	db := o.database()
+	for _, rq := range o.relationQueries {
+		if conf, ok := o.relationConfig(rq.relation); ok && conf.Database != "" && conf.Database != db {
+			return fmt.Errorf("relation %q is in database %q, which $lookup cannot reach from %q", rq.relation, conf.Database, db)
+		}
+	}
+	return checkLookupNodes(o.relationTree(), db)
+}
+
+// checkLookupNodes 遞迴檢查關聯樹中以 $lookup 載入的節點，db 為上一層所在的資料庫。
+// checkLookupNodes recursively checks the nodes of the tree loaded with $lookup; db is the parent's database.
+func checkLookupNodes(nodes []*relationNode, db string) error {
+	for _, node := range nodes {
+		own := db
+		if node.conf.Database != "" {
+			own = node.conf.Database
+		}
+		if !node.preloaded() && own != db {
+			return fmt.Errorf("relation %q is in database %q, which $lookup cannot reach from %q; use the preload strategy",
+				node.path, own, db)
+		}
+		children := node.children
+		if q := node.constraintQuery(); q != nil {
+			children = append(append([]*relationNode{}, children...), q.relationTree()...)
+		}
+		if err := checkLookupNodes(children, own); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relationProjection 為子管道產生投影：包含投影時保留巢狀關聯欄位與批次預載入所需的鍵，未投影時返回 nil。
+// relationProjection builds the projection of a sub-pipeline; inclusive projections keep the nested relation
+// fields and the keys batched preloading needs. It returns nil when nothing is projected.
+func relationProjection(fields bson.M, children []*relationNode) bson.M {
+	if len(fields) == 0 {
+		return nil
+	}
+	projection := bson.M{}
+	inclusive := false
+	for field, v := range fields {
+		projection[field] = v
+		if v == 1 {
+			inclusive = true
+		}
+	}
+	if inclusive {
+		for _, child := range children {
+			projection[child.conf.As] = 1
+			if child.preloaded() {
+				projection[child.conf.LocalField] = 1
+			}
+		}
+	}
+	return projection
+}
+
+// relationKeys 收集模型中 field 欄位的值（陣列欄位會展開），去除重複後回傳。
+// relationKeys collects the distinct values of field across models, flattening array fields.
+func relationKeys(models []interface{}, field string) []interface{} {
+	seen := map[interface{}]bool{}
+	var keys []interface{}
+	var add func(v reflect.Value)
+	add = func(v reflect.Value) {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return
+			}
+			v = v.Elem()
+		}
+		if (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8 {
+			for i := 0; i < v.Len(); i++ {
+				add(v.Index(i))
+			}
+			return
+		}
+		if key, ok := normalizeKey(v.Interface()); ok && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	for _, model := range models {
+		if v, ok := fieldByBsonName(model, field); ok {
+			add(v)
+		}
+	}
+	return keys
+}
+
+// normalizeKey 將鍵值轉為可比較的形式（整數統一為 int64），無法作為 map 鍵時回傳 false。
+// normalizeKey turns a key into a comparable form (integers become int64); it returns false for unusable keys.
+func normalizeKey(v interface{}) (interface{}, bool) {
+	switch k := v.(type) {
+	case nil:
+		return nil, false
+	case int:
+		return int64(k), true
+	case int32:
+		return int64(k), true
+	case int64:
+		return k, true
+	}
+	if !reflect.TypeOf(v).Comparable() {
+		return nil, false
+	}
+	return v, true
+}
+
+// refMatches 回傳 refMany 關聯中模型參照的文檔：依模型 ID 陣列的順序，限制條件自行排序時則保留查詢結果的順序。
+// refMatches returns the documents a refMany model references: in the order of its ID array, or in query order
+// when the constraint sorts on its own.
+func refMatches(model interface{}, conf RelationConfig, docs []bson.M, byKey map[interface{}][]bson.M, sorted bool) []bson.M {
+	keys := relationKeys([]interface{}{model}, conf.LocalField)
+	var related []bson.M
+	if !sorted {
+		for _, key := range keys {
+			related = append(related, byKey[key]...)
+		}
+		return related
+	}
+	wanted := make(map[interface{}]bool, len(keys))
+	for _, key := range keys {
+		wanted[key] = true
+	}
+	for _, doc := range docs {
+		if key, ok := normalizeKey(doc[conf.ForeignField]); ok && wanted[key] {
+			related = append(related, doc)
+		}
+	}
+	return related
+}
+
+// pageDocuments 對單一模型的關聯文檔套用 Offset 與 Limit。
+// pageDocuments applies Offset and Limit to the related documents of a single model.
+func pageDocuments(docs []bson.M, skip, limit int64) []bson.M {
+	if skip > 0 {
+		if skip >= int64(len(docs)) {
+			return nil
+		}
+		docs = docs[skip:]
+	}
+	if limit > 0 && limit < int64(len(docs)) {
+		docs = docs[:limit]
+	}
+	return docs
+}
+
+// setRelationField 將關聯文檔解碼進欄位：陣列關聯為切片，一對一關聯為第一筆（沒有時為零值）。
+// setRelationField decodes the related documents into field: a slice for array relations, the first document
+// (or the zero value) for singular ones.
+func setRelationField(field reflect.Value, docs []bson.M, many bool) error {
+	field.Set(reflect.Zero(field.Type()))
+	var value interface{}
+	if many {
+		items := bson.A{}
+		for _, doc := range docs {
+			items = append(items, doc)
+		}
+		value = items
+	} else {
+		if len(docs) == 0 {
+			return nil
+		}
+		value = docs[0]
+	}
+	raw, err := bson.Marshal(bson.M{"v": value})
+	if err != nil {
+		return err
+	}
+	return bson.Raw(raw).Lookup("v").Unmarshal(field.Addr().Interface())
+}
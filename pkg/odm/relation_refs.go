@@ -0,0 +1,119 @@
+package odm
+
+import (
+	"fmt"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// relation_refs.go - ID 陣列關聯（refMany）：依原始 ID 順序預載入，以及 PushRef / PullRef
+// Array-of-references relations (refMany): eager loading in the order of the ID array, plus PushRef / PullRef.
+
+// refOrderStage 產生 $addFields 階段，將 $lookup 的結果依本模型 ID 陣列的順序重新排列
+// （$lookup 本身不保證順序）。找不到對應文檔的參照會成為 null，
+// 設定 DropDangling 或使用 WithFn 限制條件時則會被移除。WithFn 以 OrderBy 指定排序時不使用此階段。
+// refOrderStage builds the $addFields stage that reorders the $lookup result by the model's ID array,
+// since $lookup does not preserve it. Dangling references become null, and are dropped when DropDangling
+// is set or the relation has a WithFn constraint. It is not used when the WithFn constraint sorts with OrderBy.
+func (n *relationNode) refOrderStage() bson.M {
+	conf := n.conf
+	ordered := interface{}(bson.M{
+		"$map": bson.M{
+			"input": bson.M{"$ifNull": bson.A{"$" + conf.LocalField, bson.A{}}},
+			"as":    "ref",
+			"in": bson.M{
+				"$let": bson.M{
+					"vars": bson.M{"idx": bson.M{"$indexOfArray": bson.A{"$" + conf.As + "." + conf.ForeignField, "$$ref"}}},
+					"in":   bson.M{"$cond": bson.A{bson.M{"$gte": bson.A{"$$idx", 0}}, bson.M{"$arrayElemAt": bson.A{"$" + conf.As, "$$idx"}}, nil}},
+				},
+			},
+		},
+	})
+	if conf.DropDangling || n.constraint != nil {
+		ordered = bson.M{"$filter": bson.M{"input": ordered, "cond": bson.M{"$ne": bson.A{"$$this", nil}}}}
+	}
+	return bson.M{"$addFields": bson.M{conf.As: ordered}}
+}
+
+// PushRef 將 ids 加入 refMany 關聯的 ID 陣列（不重複），並同步更新模型上的欄位；ObjectID 的十六進位字串會以該 ObjectID 寫入。
+// PushRef adds ids to the ID array of a refMany relation (without duplicates) and updates the model field as well;
+// the hex string of an ObjectID is written as that ObjectID.
+func (o *GODM) PushRef(relation string, ids ...interface{}) error {
+	conf, filter, err := o.refRelation(relation)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	ids = pivotValues(ids)
+	update := bson.M{"$addToSet": bson.M{conf.LocalField: bson.M{"$each": ids}}}
+	if _, err := o.Collection.UpdateOne(o.getContext(), filter, update); err != nil {
+		return fmt.Errorf("push ref error: %w", err)
+	}
+	if field, ok := fieldByBsonName(o.Model, conf.LocalField); ok && field.Kind() == reflect.Slice {
+		current := make([]interface{}, field.Len())
+		for i := range current {
+			current[i] = field.Index(i).Interface()
+		}
+		for _, id := range keysNotIn(ids, current) {
+			v := reflect.ValueOf(id)
+			if v.Type().AssignableTo(field.Type().Elem()) {
+				field.Set(reflect.Append(field, v))
+			}
+		}
+	}
+	return nil
+}
+
+// PullRef 從 refMany 關聯的 ID 陣列移除 ids，並同步更新模型上的欄位；ids 的轉換與比較方式與 PushRef 相同。
+// PullRef removes ids from the ID array of a refMany relation and updates the model field as well; ids are
+// converted and compared like PushRef does.
+func (o *GODM) PullRef(relation string, ids ...interface{}) error {
+	conf, filter, err := o.refRelation(relation)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	ids = pivotValues(ids)
+	update := bson.M{"$pullAll": bson.M{conf.LocalField: ids}}
+	if _, err := o.Collection.UpdateOne(o.getContext(), filter, update); err != nil {
+		return fmt.Errorf("pull ref error: %w", err)
+	}
+	if field, ok := fieldByBsonName(o.Model, conf.LocalField); ok && field.Kind() == reflect.Slice {
+		removed := map[interface{}]bool{}
+		for _, id := range ids {
+			if key, ok := pivotKey(id); ok {
+				removed[key] = true
+			}
+		}
+		kept := reflect.MakeSlice(field.Type(), 0, field.Len())
+		for i := 0; i < field.Len(); i++ {
+			if key, ok := pivotKey(field.Index(i).Interface()); !ok || !removed[key] {
+				kept = reflect.Append(kept, field.Index(i))
+			}
+		}
+		field.Set(kept)
+	}
+	return nil
+}
+
+// refRelation 取得 refMany 關聯設定，以及用來定位本模型文檔的 _id 過濾條件。
+// refRelation returns the refMany config and the _id filter locating this model's document.
+func (o *GODM) refRelation(relation string) (RelationConfig, bson.M, error) {
+	conf, ok := o.relationConfig(relation)
+	if !ok {
+		return RelationConfig{}, nil, fmt.Errorf("relation %q is not defined", relation)
+	}
+	if conf.Type != RefMany {
+		return RelationConfig{}, nil, fmt.Errorf("relation %q is not a refMany relation", relation)
+	}
+	id, ok := fieldByBsonName(o.Model, "_id")
+	if !ok || id.IsZero() {
+		return RelationConfig{}, nil, fmt.Errorf("model %T has no _id value", o.Model)
+	}
+	return conf, bson.M{"_id": id.Interface()}, nil
+}
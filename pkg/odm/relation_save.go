@@ -0,0 +1,416 @@
+package odm
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// relation_save.go - 透過關聯寫入關聯模型：自動設定外鍵的 Create / CreateMany、Associate / Dissociate，
+// 以及在同一個交易中保存模型與其巢狀關聯的 SaveWithRelations 與 CreateOptions.Relations
+// Writing related models through relations: Create / CreateMany that set the foreign key, Associate / Dissociate,
+// and SaveWithRelations and CreateOptions.Relations, which persist a model and its nested relation structs in one
+// transaction.
+//
+// 範例 / Example:
+//
+//	post := examples.NewPost()
+//	post.Title = "Hello"
+//	_ = user.Relation("posts").Create(post) // post.UserID = user.ID
+//
+//	_ = post.Relation("user").Associate(user) // post.UserID = user.ID，已存在的 post 會一併更新 / persisted when post exists
+//
+//	user.Posts = []examples.Post{{Title: "A"}, {Title: "B"}}
+//	_ = user.Create(&odm.CreateOptions{Relations: []string{"posts"}}) // 新模型 / a new model
+//	_ = user.SaveWithRelations("posts")                                // 新建或更新 / created or updated
+
+// RelationBuilder - 對單一關聯執行寫入操作，由 Relation 建立
+// Runs write operations on a single relation; created by Relation
+type RelationBuilder struct {
+	parent *GODM
+	name   string
+	conf   RelationConfig
+	err    error
+}
+
+// Relation 回傳模型上名為 name 的關聯寫入器；關聯不存在時，錯誤會在呼叫寫入方法時回傳。
+// Relation returns the writer for the relation called name; an unknown relation is reported by the write methods.
+func (o *GODM) Relation(name string) *RelationBuilder {
+	conf, ok := o.relationConfig(name)
+	r := &RelationBuilder{parent: o, name: name, conf: conf}
+	if !ok {
+		r.err = fmt.Errorf("relation %q is not defined", name)
+	}
+	return r
+}
+
+// Create 透過關聯建立一筆關聯文檔：hasOne / hasMany / morphMany 會自動設定外鍵（與多型類型），
+// belongsToMany 與 refMany 會在建立後連結到本模型。belongsTo 與 morphTo 請使用 Associate。
+// Create inserts a related document through the relation: hasOne / hasMany / morphMany set the foreign key
+// (and morph type) automatically, belongsToMany and refMany link the new document to this model afterwards.
+// Use Associate for belongsTo and morphTo.
+func (r *RelationBuilder) Create(model interface{}) error {
+	return r.CreateMany([]interface{}{model})
+}
+
+// CreateMany 透過關聯建立多筆關聯文檔，規則與 Create 相同，並以 BulkCreate 一次寫入；_id 為零值的 ObjectID 會先產生。
+// 不論筆數，每個模型都會觸發 creating / created（BulkEventsPerModel）。
+// CreateMany inserts several related documents through the relation with one BulkCreate, following the rules of Create;
+// zero ObjectID _id fields are generated first. Whatever the count, every model fires creating / created
+// (BulkEventsPerModel).
+func (r *RelationBuilder) CreateMany(models []interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(models) == 0 {
+		return nil
+	}
+	switch r.conf.Type {
+	case HasOne, HasMany, MorphMany:
+		for _, model := range models {
+			if err := r.setForeignKey(model); err != nil {
+				return err
+			}
+		}
+	case BelongsToMany, RefMany:
+	default:
+		return fmt.Errorf("relation %q is a %s relation, use Associate", r.name, r.conf.Type)
+	}
+	for _, model := range models {
+		ensureObjectID(model)
+	}
+
+	q := r.query(models[0]).SetBulkEventMode(BulkEventsPerModel)
+	if err := q.BulkCreate(models); err != nil {
+		return err
+	}
+	return r.link(models)
+}
+
+// Associate 將 belongsTo / morphTo 關聯指向 related：設定本模型的外鍵（與多型類型）及關聯欄位；
+// 本模型已有 _id 時會一併更新資料庫，否則待之後 Create 時寫入。
+// Associate points a belongsTo / morphTo relation at related: it sets this model's foreign key (and morph type)
+// and the relation field. When this model already has an _id the change is persisted right away, otherwise it
+// is written by the next Create.
+func (r *RelationBuilder) Associate(related interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	set, err := r.associate(related)
+	if err != nil {
+		return err
+	}
+	return r.updateParent(set)
+}
+
+// Dissociate 清除 belongsTo / morphTo 關聯的外鍵與關聯欄位，規則與 Associate 相同。
+// Dissociate clears the foreign key and the relation field of a belongsTo / morphTo relation, like Associate does.
+func (r *RelationBuilder) Dissociate() error {
+	if r.err != nil {
+		return r.err
+	}
+	conf := r.conf
+	set := bson.M{conf.LocalField: nil}
+	if conf.Type == MorphTo {
+		set[conf.MorphName+"_type"] = nil
+	} else if conf.Type != BelongsTo {
+		return fmt.Errorf("relation %q is a %s relation, Dissociate needs belongsTo or morphTo", r.name, conf.Type)
+	}
+	for name := range set {
+		if field, ok := fieldByBsonName(r.parent.Model, name); ok && field.CanSet() {
+			field.Set(reflect.Zero(field.Type()))
+		}
+	}
+	if field, ok := fieldByBsonName(r.parent.Model, conf.As); ok && field.CanSet() {
+		field.Set(reflect.Zero(field.Type()))
+	}
+	return r.updateParent(set)
+}
+
+// SaveWithRelations 在同一個交易中保存模型與其巢狀關聯欄位中的模型：沒有 _id 的模型會被建立，其餘以 $set 更新。
+// belongsTo / morphTo 關聯會先保存並設定外鍵，其他關聯在本模型保存後設定外鍵或建立連結。未指定 relations 時保存所有已宣告的關聯。
+// 只需建立本模型時，可改用 Create 搭配 CreateOptions.Relations。
+// SaveWithRelations persists the model and the models held in its relation fields in one transaction: models
+// without an _id are created, the others updated with $set. belongsTo / morphTo relations are saved first to set
+// the foreign key; the other relations get their foreign key or link after this model is saved.
+// Without relations every declared relation is saved. To always create this model, use Create with
+// CreateOptions.Relations instead.
+func (o *GODM) SaveWithRelations(relations ...string) error {
+	if len(relations) == 0 {
+		relations = o.relationNames()
+	}
+	return o.persistWithRelations(relations, saveModel)
+}
+
+// persistWithRelations 在交易中以 save 寫入本模型，並依 SaveWithRelations 的規則保存 relations 中的模型。
+// persistWithRelations writes this model with save inside a transaction and persists the models of relations
+// following the rules of SaveWithRelations.
+func (o *GODM) persistWithRelations(relations []string, save func(q *GODM) error) error {
+	return o.WithTransaction(func(sessCtx mongo.SessionContext) error {
+		q := *o
+		q.Ctx = sessCtx
+		return q.saveWithRelations(relations, save)
+	})
+}
+
+func (o *GODM) saveWithRelations(relations []string, save func(q *GODM) error) error {
+	var after []*RelationBuilder
+	for _, name := range relations {
+		r := o.Relation(name)
+		if r.err != nil {
+			return r.err
+		}
+		if r.conf.Type != BelongsTo && r.conf.Type != MorphTo {
+			after = append(after, r)
+			continue
+		}
+		items := r.items()
+		if len(items) == 0 {
+			continue
+		}
+		if err := r.save(items[0]); err != nil {
+			return err
+		}
+		if _, err := r.associate(items[0]); err != nil {
+			return err
+		}
+	}
+
+	if err := save(o); err != nil {
+		return err
+	}
+
+	for _, r := range after {
+		items := r.items()
+		for _, item := range items {
+			if r.conf.Type == BelongsToMany || r.conf.Type == RefMany {
+				ensureObjectID(item)
+			} else if err := r.setForeignKey(item); err != nil {
+				return err
+			}
+			if err := r.save(item); err != nil {
+				return err
+			}
+		}
+		if err := r.link(items); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relationNames 依名稱排序回傳模型宣告與 SetRelationConfig 設定的所有關聯。
+// relationNames returns every relation declared on the model or set with SetRelationConfig, sorted by name.
+func (o *GODM) relationNames() []string {
+	seen := map[string]bool{}
+	var names []string
+	for name := range relationsOf(reflect.TypeOf(o.Model)) {
+		seen[name] = true
+		names = append(names, name)
+	}
+	for name := range o.RelationConfigs {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// items 回傳本模型關聯欄位中的模型（皆為結構指標）。
+// items returns the models held in the relation field of this model, as struct pointers.
+func (r *RelationBuilder) items() []interface{} {
+	field, ok := fieldByBsonName(r.parent.Model, r.conf.As)
+	if !ok {
+		return nil
+	}
+	return elementPointers(field)
+}
+
+// associate 在記憶體中設定本模型的外鍵、多型類型與關聯欄位，回傳需要寫入的欄位。
+// associate sets this model's foreign key, morph type and relation field in memory and returns the fields to persist.
+func (r *RelationBuilder) associate(related interface{}) (bson.M, error) {
+	conf := r.conf
+	if conf.Type != BelongsTo && conf.Type != MorphTo {
+		return nil, fmt.Errorf("relation %q is a %s relation, Associate needs belongsTo or morphTo", r.name, conf.Type)
+	}
+	key, ok := fieldByBsonName(related, conf.ForeignField)
+	if !ok || key.IsZero() {
+		return nil, fmt.Errorf("related model %T has no %s yet, create it first", related, conf.ForeignField)
+	}
+	set := bson.M{conf.LocalField: key.Interface()}
+	if conf.Type == MorphTo {
+		set[conf.MorphName+"_type"] = morphAlias(reflect.TypeOf(related))
+	}
+	for name, value := range set {
+		if err := setBsonField(r.parent.Model, name, value); err != nil {
+			return nil, err
+		}
+	}
+	if field, ok := fieldByBsonName(r.parent.Model, conf.As); ok && field.CanSet() {
+		value := reflect.ValueOf(related)
+		if !value.Type().AssignableTo(field.Type()) && value.Kind() == reflect.Ptr {
+			value = value.Elem()
+		}
+		if value.Type().AssignableTo(field.Type()) {
+			field.Set(value)
+		}
+	}
+	return set, nil
+}
+
+// updateParent 本模型已有 _id 時以 $set 寫入 set，否則不做任何事。
+// updateParent writes set with $set when this model already has an _id, and does nothing otherwise.
+func (r *RelationBuilder) updateParent(set bson.M) error {
+	id, ok := fieldByBsonName(r.parent.Model, "_id")
+	if !ok || id.IsZero() {
+		return nil
+	}
+	q := *r.parent
+	q.Filter = bson.D{{Key: "_id", Value: id.Interface()}}
+	q.OrFilter = nil
+	return q.Update(set)
+}
+
+// setForeignKey 將關聯文檔的外鍵設為本模型的鍵值，morphMany 另外設定多型類型。
+// setForeignKey sets the related document's foreign key to this model's key; morphMany sets the morph type as well.
+func (r *RelationBuilder) setForeignKey(model interface{}) error {
+	conf := r.conf
+	local, ok := fieldByBsonName(r.parent.Model, conf.LocalField)
+	if !ok {
+		return fmt.Errorf("model %T has no field %q", r.parent.Model, conf.LocalField)
+	}
+	if local.IsZero() {
+		return fmt.Errorf("model %T has no %s yet, create it first", r.parent.Model, conf.LocalField)
+	}
+	if err := setBsonField(model, conf.ForeignField, local.Interface()); err != nil {
+		return err
+	}
+	if conf.Type == MorphMany {
+		return setBsonField(model, conf.MorphName+"_type", conf.morphTypeName())
+	}
+	return nil
+}
+
+// link 將已建立的關聯文檔連結到本模型：belongsToMany 寫入中介文檔，refMany 加入 ID 陣列。
+// link connects created related documents to this model: pivot documents for belongsToMany, the ID array for refMany.
+func (r *RelationBuilder) link(models []interface{}) error {
+	if r.conf.Type != BelongsToMany && r.conf.Type != RefMany {
+		return nil
+	}
+	keys := relationKeys(models, r.conf.ForeignField)
+	if len(keys) == 0 {
+		return nil
+	}
+	if r.conf.Type == BelongsToMany {
+		return r.parent.Attach(r.name, keys, nil)
+	}
+	return r.parent.PushRef(r.name, keys...)
+}
+
+// query 建立寫入關聯文檔用的查詢：沿用模型內嵌 GODM 的設定（觀察者等），集合與 context 取自關聯與本模型。
+// query builds the builder used to write a related document: it keeps the settings of the GODM embedded in the
+// model (observers, ...) and takes the collection and context from the relation and this model.
+func (r *RelationBuilder) query(model interface{}) *GODM {
+	q := &GODM{}
+	if g := embeddedGODM(model); g != nil {
+		copied := *g
+		q = &copied
+	}
+	collection := r.conf.From
+	if r.conf.Type == MorphTo {
+		collection = defaultCollectionName(reflect.TypeOf(model))
+		morphMu.RLock()
+		if entry, ok := morphByAlias[morphAlias(reflect.TypeOf(model))]; ok {
+			collection = entry.collection
+		}
+		morphMu.RUnlock()
+	}
+	q.Model = model
+	q.Ctx = r.parent.Ctx
+	q.DBName = r.parent.relatedDatabase(r.conf)
+	q.Collection = r.parent.relatedCollection(r.conf, collection)
+	q.Filter = bson.D{}
+	q.OrFilter = nil
+	return q
+}
+
+// save 建立沒有 _id 的關聯文檔，其餘以 $set 更新。
+// save creates a related document without an _id and updates the others with $set.
+func (r *RelationBuilder) save(model interface{}) error {
+	return saveModel(r.query(model))
+}
+
+// saveModel 建立沒有 _id 的模型（並先產生 ObjectID），其餘以 $set 更新除 _id 以外的欄位。
+// saveModel creates a model without an _id (generating an ObjectID first) and otherwise updates every field but _id with $set.
+func saveModel(q *GODM) error {
+	id, ok := fieldByBsonName(q.Model, "_id")
+	if !ok || id.IsZero() {
+		ensureObjectID(q.Model)
+		return q.Create()
+	}
+	doc, err := saveDocument(q.Model)
+	if err != nil {
+		return err
+	}
+	q.Filter = bson.D{{Key: "_id", Value: id.Interface()}}
+	q.OrFilter = nil
+	return q.Update(doc)
+}
+
+// saveDocument 將模型編碼為 $set 使用的文檔並移除 _id；關聯欄位由 Update 排除。
+// saveDocument encodes the model into a document for $set without _id; Update leaves out the relation fields.
+func saveDocument(model interface{}) (bson.M, error) {
+	raw, err := bson.Marshal(model)
+	if err != nil {
+		return nil, fmt.Errorf("encode error: %w", err)
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("encode error: %w", err)
+	}
+	delete(doc, "_id")
+	return doc, nil
+}
+
+// ensureObjectID 模型的 _id 為零值的 primitive.ObjectID 時產生新的 ObjectID。
+// ensureObjectID generates a new ObjectID when the model's _id is a zero primitive.ObjectID.
+func ensureObjectID(model interface{}) {
+	id, ok := fieldByBsonName(model, "_id")
+	if ok && id.CanSet() && id.Type() == reflect.TypeOf(primitive.ObjectID{}) && id.IsZero() {
+		id.Set(reflect.ValueOf(primitive.NewObjectID()))
+	}
+}
+
+// setBsonField 設定模型中 bson 名稱為 name 的欄位，支援可轉換的型別與指標欄位。
+// setBsonField sets the field whose bson key is name, converting the value or wrapping it in a pointer when needed.
+func setBsonField(model interface{}, name string, value interface{}) error {
+	field, ok := fieldByBsonName(model, name)
+	if !ok || !field.CanSet() {
+		return fmt.Errorf("model %T has no field %q", model, name)
+	}
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	switch {
+	case v.Type().AssignableTo(field.Type()):
+		field.Set(v)
+	case v.Kind() == field.Kind() && v.Type().ConvertibleTo(field.Type()):
+		field.Set(v.Convert(field.Type()))
+	case field.Kind() == reflect.Ptr && v.Type().AssignableTo(field.Type().Elem()):
+		ptr := reflect.New(field.Type().Elem())
+		ptr.Elem().Set(v)
+		field.Set(ptr)
+	case field.Kind() == reflect.Interface:
+		field.Set(v)
+	default:
+		return fmt.Errorf("cannot set %s of %T to %T", name, model, value)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package odm
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// relation_tag.go - 從結構標籤（struct tag）解析關聯設定並依型別快取
+// Parses relation metadata from struct tags and caches it per model type.
+//
+// 範例 / Example:
+//
+//	type User struct {
+//		Posts []Post `bson:"posts,omitempty" odm:"hasMany,foreignKey=user_id,onDelete=cascade"`
+//	}
+//	type Post struct {
+//		User *User `bson:"user,omitempty" odm:"belongsTo,localKey=user_id"`
+//	}
+//	type User struct {
+//		Roles []Role `bson:"roles,omitempty" odm:"belongsToMany,pivot=role_user,pivotFields=granted_at|granted_by"`
+//		Tags  []Tag  `bson:"tags,omitempty" odm:"refMany,localKey=tag_ids,dropDangling"`
+//	}
+//	type Comment struct {
+//		Commentable interface{} `bson:"commentable,omitempty" odm:"morphTo"`
+//	}
+//	type Order struct {
+//		Events []Event `bson:"events,omitempty" odm:"hasMany,strategy=preload,db=analytics"`
+//	}
+//
+// 關聯名稱為欄位的 bson 名稱，From 預設為關聯模型的集合名稱（型別名小寫加 "s"），可用 from= 覆寫。
+// The relation name is the field's bson name; From defaults to the related model's collection name
+// (lowercased type name plus "s") and can be overridden with from=.
+
+// RelationType - 關聯類型
+// The kind of a relation
+type RelationType string
+
+const (
+	// HasOne - 一對一，外鍵存放在關聯集合
+	// One-to-one, the foreign key lives on the related collection
+	HasOne RelationType = "hasOne"
+	// HasMany - 一對多，外鍵存放在關聯集合
+	// One-to-many, the foreign key lives on the related collection
+	HasMany RelationType = "hasMany"
+	// BelongsTo - 反向一對一，外鍵存放在本模型
+	// Inverse one-to-one, the foreign key lives on this model
+	BelongsTo RelationType = "belongsTo"
+	// BelongsToMany - 多對多，透過中介集合（pivot）連結
+	// Many-to-many through a pivot collection
+	BelongsToMany RelationType = "belongsToMany"
+	// RefMany - 一對多，本模型以 ID 陣列（例如 tag_ids）參照關聯文檔
+	// One-to-many where this model references the related documents with an array of IDs (e.g. tag_ids)
+	RefMany RelationType = "refMany"
+	// MorphMany - 多型一對多，關聯文檔以 <morphName>_type / <morphName>_id 指向本模型
+	// Polymorphic one-to-many; related documents point back with <morphName>_type / <morphName>_id
+	MorphMany RelationType = "morphMany"
+	// MorphTo - 多型反向關聯，依 <morphName>_type 解析為 RegisterMorph 註冊的模型
+	// Inverse polymorphic relation, resolved through <morphName>_type to a model registered with RegisterMorph
+	MorphTo RelationType = "morphTo"
+)
+
+// relationTagKey - 關聯設定使用的 struct tag 名稱
+// The struct tag key used for relation metadata
+const relationTagKey = "odm"
+
+// relationCache - 依模型型別快取的關聯設定（reflect.Type -> map[string]RelationConfig）
+// Relation metadata cached per model type (reflect.Type -> map[string]RelationConfig)
+var relationCache sync.Map
+
+// relationsOf 回傳模型型別以 struct tag 宣告的所有關聯，結果會被快取。
+// relationsOf returns every relation declared with struct tags on the model type; results are cached.
+func relationsOf(typ reflect.Type) map[string]RelationConfig {
+	typ = indirectType(typ)
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil
+	}
+	if cached, ok := relationCache.Load(typ); ok {
+		return cached.(map[string]RelationConfig)
+	}
+
+	relations := map[string]RelationConfig{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag, ok := field.Tag.Lookup(relationTagKey)
+		if !ok || field.PkgPath != "" {
+			continue
+		}
+		conf, err := parseRelationTag(typ, field, tag)
+		if err != nil {
+			log.Printf("relation tag error on %s.%s: %v\n", typ.Name(), field.Name, err)
+			continue
+		}
+		relations[conf.As] = conf
+	}
+	cached, _ := relationCache.LoadOrStore(typ, relations)
+	return cached.(map[string]RelationConfig)
+}
+
+// parseRelationTag 將單一欄位的 odm tag 解析為 RelationConfig。
+// parseRelationTag parses the odm tag of a single field into a RelationConfig.
+func parseRelationTag(owner reflect.Type, field reflect.StructField, tag string) (RelationConfig, error) {
+	parts := strings.Split(tag, ",")
+	options := map[string]string{}
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		options[key] = value
+	}
+
+	related := indirectType(field.Type)
+	conf := RelationConfig{
+		Type:        RelationType(strings.TrimSpace(parts[0])),
+		As:          bsonFieldName(field),
+		From:        options["from"],
+		Strategy:    LoadStrategy(options["strategy"]),
+		Database:    options["db"],
+		OnDelete:    ReferentialAction(options["onDelete"]),
+		relatedType: related,
+		ownerType:   owner,
+	}
+	if conf.From == "" && related.Kind() == reflect.Struct {
+		conf.From = defaultCollectionName(related)
+	}
+
+	switch conf.Type {
+	case HasOne, HasMany:
+		conf.LocalField = options["localKey"]
+		if conf.LocalField == "" {
+			conf.LocalField = "_id"
+		}
+		conf.ForeignField = options["foreignKey"]
+		if conf.ForeignField == "" {
+			conf.ForeignField = snakeCase(owner.Name()) + "_id"
+		}
+		conf.IsArray = conf.Type == HasMany
+	case BelongsTo:
+		conf.LocalField = options["localKey"]
+		if conf.LocalField == "" {
+			conf.LocalField = snakeCase(field.Name) + "_id"
+		}
+		conf.ForeignField = options["foreignKey"]
+		if conf.ForeignField == "" {
+			conf.ForeignField = "_id"
+		}
+	case BelongsToMany:
+		conf.LocalField = options["localKey"]
+		if conf.LocalField == "" {
+			conf.LocalField = "_id"
+		}
+		conf.ForeignField = options["foreignKey"]
+		if conf.ForeignField == "" {
+			conf.ForeignField = "_id"
+		}
+		conf.Pivot = options["pivot"]
+		if conf.Pivot == "" {
+			names := []string{snakeCase(owner.Name()), snakeCase(related.Name())}
+			sort.Strings(names)
+			conf.Pivot = strings.Join(names, "_")
+		}
+		conf.PivotLocalKey = options["pivotLocalKey"]
+		if conf.PivotLocalKey == "" {
+			conf.PivotLocalKey = snakeCase(owner.Name()) + "_id"
+		}
+		conf.PivotForeignKey = options["pivotForeignKey"]
+		if conf.PivotForeignKey == "" {
+			conf.PivotForeignKey = snakeCase(related.Name()) + "_id"
+		}
+		if fields := options["pivotFields"]; fields != "" {
+			conf.PivotFields = strings.Split(fields, "|")
+		}
+		conf.IsArray = true
+	case RefMany:
+		conf.LocalField = options["localKey"]
+		if conf.LocalField == "" {
+			conf.LocalField = strings.TrimSuffix(snakeCase(field.Name), "s") + "_ids"
+		}
+		conf.ForeignField = options["foreignKey"]
+		if conf.ForeignField == "" {
+			conf.ForeignField = "_id"
+		}
+		_, conf.DropDangling = options["dropDangling"]
+		conf.IsArray = true
+	case MorphMany:
+		conf.MorphName = options["morphName"]
+		if conf.MorphName == "" {
+			return RelationConfig{}, fmt.Errorf("morphMany relation requires morphName")
+		}
+		conf.MorphType = options["morphType"]
+		conf.LocalField = options["localKey"]
+		if conf.LocalField == "" {
+			conf.LocalField = "_id"
+		}
+		conf.ForeignField = conf.MorphName + "_id"
+		conf.IsArray = true
+	case MorphTo:
+		conf.MorphName = options["morphName"]
+		if conf.MorphName == "" {
+			conf.MorphName = conf.As
+		}
+		conf.From = ""
+		conf.LocalField = conf.MorphName + "_id"
+		conf.ForeignField = "_id"
+		conf.relatedType = nil
+	default:
+		return RelationConfig{}, fmt.Errorf("unknown relation type %q", conf.Type)
+	}
+	return conf, nil
+}
+
+// relationConfig 取得關聯設定：優先使用 SetRelationConfig 設定的值，其次為模型 struct tag 宣告的關聯。
+// relationConfig resolves a relation: explicit SetRelationConfig entries win over struct tag declarations.
+func (m *GODM) relationConfig(name string) (RelationConfig, bool) {
+	if conf, ok := m.RelationConfigs[name]; ok {
+		return conf, true
+	}
+	if m.Model == nil {
+		return RelationConfig{}, false
+	}
+	conf, ok := relationsOf(reflect.TypeOf(m.Model))[name]
+	return conf, ok
+}
+
+// defaultCollectionName 回傳模型型別的預設集合名稱（型別名小寫加 "s"）。
+// defaultCollectionName returns the default collection name of a model type (lowercased type name plus "s").
+func defaultCollectionName(typ reflect.Type) string {
+	return strings.ToLower(indirectType(typ).Name()) + "s"
+}
+
+// indirectType 去除指標與切片，取得元素的型別。
+// indirectType strips pointers and slices to get the element type.
+func indirectType(typ reflect.Type) reflect.Type {
+	for typ != nil && (typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array) {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+// snakeCase 將 Go 識別字轉為 snake_case（例如 "BlogPost" -> "blog_post"）。
+// snakeCase converts a Go identifier to snake_case (e.g. "BlogPost" -> "blog_post").
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
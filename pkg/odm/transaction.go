@@ -1,34 +1,158 @@
 package odm
 
 import (
+	"context"
 	"fmt"
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// Tx - 交易中的操作入口，由 Transaction 建立；透過 Model 取得的查詢會自動綁定交易的 session
+// Entry point for operations inside a transaction, created by Transaction; builders obtained from Model are bound
+// to the transaction's session automatically
+type Tx struct {
+	ctx mongo.SessionContext
+}
+
+// Transaction 開啟交易並執行 fn：fn 回傳錯誤時中止，否則提交。tx.Model 取得的查詢、以及任何以 tx.Context()
+// （或由其衍生的 context）執行的 GODM 操作都會加入此交易。
+// Transaction runs fn in a transaction, aborting when fn returns an error and committing otherwise. Builders from
+// tx.Model, and every GODM operation run with tx.Context() (or a context derived from it), join the transaction.
+func Transaction(ctx context.Context, fn func(tx *Tx) error, opts ...*TransactionOptions) error {
+	o := &GODM{Ctx: ctx}
+	return o.WithTransaction(func(sessCtx mongo.SessionContext) error {
+		return fn(&Tx{ctx: sessCtx})
+	}, opts...)
+}
+
+// Context 回傳交易的 context（帶有 session），可傳給 WithContext 或直接用於驅動程式操作。
+// Context returns the transaction's context, which carries the session; pass it to WithContext or to driver calls.
+func (tx *Tx) Context() context.Context {
+	return tx.ctx
+}
+
+// Model 回傳綁定此交易的新查詢：沿用模型內嵌 GODM 的設定（集合、觀察者等，尚未 Use 時自動 Use），
+// 但不帶入其查詢條件，也不修改內嵌的 GODM。
+// Model returns a new builder bound to this transaction: it keeps the settings of the GODM embedded in model
+// (collection, observers, ...; calling Use when it has not been set up yet) but none of its query conditions,
+// and leaves the embedded GODM untouched.
+func (tx *Tx) Model(model interface{}) *GODM {
+	q := modelQuery(tx.ctx, model)
+	q.Filter = bson.D{}
+	q.OrFilter = nil
+	q.LimitCount = 0
+	q.SkipCount = 0
+	q.SortFields = nil
+	q.Projection = nil
+	q.WithRelations = nil
+	q.RelationConstraints = nil
+	q.relationQueries = nil
+	return q
+}
+
 // WithTransaction 為需要原子性操作的業務提供事務支持。
+// 回呼中的操作須使用 sessCtx（例如 WithContext(sessCtx)）才會加入交易；此查詢本身不會被修改。
+// 遇到 TransientTransactionError 時會重新執行整個回呼，UnknownTransactionCommitResult 時只重試提交，
+// 皆以指數退避並受 opts 的最長重試時間限制，因此回呼可能執行多次。
+// 實作 AfterCommitObserver 的觀察者在回呼中觸發的事件，會在提交成功後才執行，中止時捨棄。
 // WithTransaction provides transaction support for operations that require atomicity.
-func (o *GODM) WithTransaction(callback func(sessCtx mongo.SessionContext) error) error {
+// Operations inside the callback join the transaction when they run with sessCtx (WithContext(sessCtx), for
+// example); this builder itself is left untouched.
+// A TransientTransactionError reruns the whole callback and an UnknownTransactionCommitResult retries only the
+// commit, with exponential backoff within the maximum retry duration of opts, so the callback may run more than once.
+// Events raised for AfterCommitObserver observers inside the callback run only after a successful commit
+// and are discarded on abort.
+// context 已在交易中時依 opts 的 Propagation 決定：加入外層交易（預設）、以 savepoint 範圍加入，或開啟新交易。
+// When the context is already in a transaction, the Propagation of opts decides: join the outer transaction
+// (the default), join it as a savepoint scope, or start a new transaction.
+// 以 PropagationRequired 加入的內層交易失敗後，即使回呼回傳 nil 也會中止並回傳 ErrTransactionRollbackOnly。
+// Once an inner transaction joined with PropagationRequired has failed, the transaction aborts with
+// ErrTransactionRollbackOnly even when the callback returns nil.
+func (o *GODM) WithTransaction(callback func(sessCtx mongo.SessionContext) error, opts ...*TransactionOptions) error {
+	txOpts := mergeTransactionOptions(opts)
+	outer := activeSession(o.getContext())
+	if outer != nil && txOpts.Propagation != PropagationRequiresNew {
+		return o.joinTransaction(outer, callback, txOpts.Propagation == PropagationNested)
+	}
+
 	session, err := MongoClient.StartSession()
 	if err != nil {
 		return fmt.Errorf("start session error: %w", err)
 	}
 	defer session.EndSession(o.getContext())
 
-	err = mongo.WithSession(o.getContext(), session, func(sessCtx mongo.SessionContext) error {
-		if err := session.StartTransaction(); err != nil {
-			return fmt.Errorf("start transaction error: %w", err)
+	ctx := o.getContext()
+	if outer != nil {
+		// 獨立的新交易不屬於外層的 savepoint 範圍 / an independent transaction is not part of the outer savepoint scope
+		ctx = context.WithValue(ctx, savepointKey{}, (*savepoint)(nil))
+		ctx = context.WithValue(ctx, rollbackOnlyKey{}, (*rollbackOnly)(nil))
+	}
+	ctx, scope := withRollbackOnly(ctx)
+	ctx, events := withTxEvents(ctx)
+	err = mongo.WithSession(ctx, session, func(sessCtx mongo.SessionContext) error {
+		start := time.Now()
+		for attempt := 0; ; attempt++ {
+			if attempt > 0 {
+				if err := txOpts.backoff(sessCtx, attempt-1); err != nil {
+					return fmt.Errorf("transaction error: %w", err)
+				}
+			}
+			retry, err := runTransaction(sessCtx, session, callback, events, scope, txOpts, start)
+			if !retry {
+				return err
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	events.flush()
+	return nil
+}
+
+// runTransaction 執行一次交易，回傳是否應以 TransientTransactionError 重新執行整個交易。
+// runTransaction runs one attempt of the transaction and reports whether it should be rerun because of a
+// TransientTransactionError.
+func runTransaction(sessCtx mongo.SessionContext, session mongo.Session, callback func(sessCtx mongo.SessionContext) error,
+	events *txEvents, scope *rollbackOnly, txOpts *TransactionOptions, start time.Time) (bool, error) {
+	events.discard()
+	scope.reset()
+	if err := session.StartTransaction(txOpts.driverOptions()); err != nil {
+		return false, fmt.Errorf("start transaction error: %w", err)
+	}
+	err := callback(sessCtx)
+	if err == nil {
+		err = scope.failure()
+	}
+	if err != nil {
+		events.discard()
+		if abortErr := session.AbortTransaction(sessCtx); abortErr != nil {
+			return false, fmt.Errorf("abort transaction error: %w", abortErr)
+		}
+		if hasErrorLabel(err, transientTransactionError) && txOpts.canRetry(start) {
+			return true, nil
+		}
+		return false, fmt.Errorf("transaction error: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := session.CommitTransaction(sessCtx)
+		if err == nil {
+			return false, nil
 		}
-		if err := callback(sessCtx); err != nil {
-			if abortErr := session.AbortTransaction(sessCtx); abortErr != nil {
-				return fmt.Errorf("abort transaction error: %w", abortErr)
+		if retryableCommit(err) && txOpts.canRetry(start) {
+			if err := txOpts.backoff(sessCtx, attempt); err != nil {
+				events.discard()
+				return false, fmt.Errorf("commit transaction error: %w", err)
 			}
-			return fmt.Errorf("transaction error: %w", err)
+			continue
 		}
-		if err := session.CommitTransaction(sessCtx); err != nil {
-			return fmt.Errorf("commit transaction error: %w", err)
+		events.discard()
+		if hasErrorLabel(err, transientTransactionError) && txOpts.canRetry(start) {
+			return true, nil
 		}
-		return nil
-	})
-	return err
+		return false, fmt.Errorf("commit transaction error: %w", err)
+	}
 }
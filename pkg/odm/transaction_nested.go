@@ -0,0 +1,309 @@
+package odm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// transaction_nested.go - 巢狀交易：加入外層交易、開啟獨立的新交易，或以補償動作模擬 savepoint 的部分回滾
+// Nested transactions: joining the outer transaction, starting an independent one, or emulating savepoint-style
+// partial rollback with compensating actions.
+//
+// 範例 / Example:
+//
+//	_ = odm.Transaction(ctx, func(tx *odm.Tx) error {
+//		_ = tx.Model(order).Create()
+//		// 內層失敗只撤銷內層的寫入，外層交易繼續 / a failing inner scope only undoes its own writes
+//		err := odm.Transaction(tx.Context(), func(inner *odm.Tx) error {
+//			return inner.Model(invoice).Create()
+//		}, &odm.TransactionOptions{Propagation: odm.PropagationNested})
+//		if errors.Is(err, odm.ErrTransactionAborted) {
+//			return err // 伺服器已中止交易 / the server aborted the transaction
+//		}
+//		return nil
+//	})
+//
+// MongoDB 不支援 savepoint：PropagationNested 在內層範圍中記錄每個寫入的補償動作（Create 記錄刪除、Update / Delete
+// 記錄原文檔），內層失敗時在外層交易中依相反順序執行。也可用 OnRollback 登記自訂的補償動作。
+// 伺服器回傳的錯誤（例如重複鍵）會讓伺服器中止整個交易，此時無法補償，內層回傳 ErrTransactionAborted，外層也無法提交。
+// MongoDB has no savepoints: PropagationNested records a compensating action for every write in the inner scope
+// (a delete for Create, the original document for Update / Delete) and runs them in reverse order inside the outer
+// transaction when the inner scope fails. OnRollback registers custom compensating actions.
+// An error returned by the server (a duplicate key, for example) makes the server abort the whole transaction;
+// nothing can be compensated then, so the inner scope returns ErrTransactionAborted and the outer one cannot commit.
+
+// TransactionPropagation - 已在交易中時，WithTransaction / Transaction 的行為
+// How WithTransaction / Transaction behave when a transaction is already active
+type TransactionPropagation int
+
+const (
+	// PropagationRequired - 加入外層交易（預設）；內層錯誤會讓外層範圍成為 rollback-only，
+	// 即使外層回呼忽略該錯誤，外層也會中止並回傳 ErrTransactionRollbackOnly
+	// Join the outer transaction (the default); an inner error makes the outer scope rollback-only, so it aborts
+	// with ErrTransactionRollbackOnly even when the outer callback ignores the error
+	PropagationRequired TransactionPropagation = iota
+	// PropagationRequiresNew - 以新的 session 開啟獨立的交易，與外層交易互不影響
+	// Start an independent transaction on a new session, unaffected by the outer one
+	PropagationRequiresNew
+	// PropagationNested - 加入外層交易，內層失敗時以補償動作撤銷內層的寫入，外層交易可繼續
+	// Join the outer transaction; when the inner scope fails its writes are undone with compensating actions and
+	// the outer transaction can go on
+	PropagationNested
+)
+
+var (
+	// ErrTransactionRollbackOnly - 範圍中以 PropagationRequired 加入的內層交易失敗，此範圍只能中止
+	// An inner transaction joined with PropagationRequired failed, so the scope can only abort
+	ErrTransactionRollbackOnly = errors.New("transaction is rollback-only")
+	// ErrTransactionAborted - 伺服器錯誤已中止整個交易，savepoint 範圍無法補償
+	// A server error aborted the whole transaction, so the savepoint scope cannot be compensated
+	ErrTransactionAborted = errors.New("transaction aborted by the server")
+)
+
+// rollbackOnlyKey - rollback-only 狀態在 context 中的鍵
+// Context key of the rollback-only state
+type rollbackOnlyKey struct{}
+
+// rollbackOnly - 交易或 savepoint 範圍的 rollback-only 狀態，記錄第一個讓範圍只能中止的錯誤
+// Rollback-only state of a transaction or savepoint scope, holding the first error that doomed it
+type rollbackOnly struct {
+	mu     sync.Mutex
+	cause  error
+	parent *rollbackOnly
+}
+
+// withRollbackOnly 在 context 中附加新範圍的 rollback-only 狀態，外層範圍的狀態作為 parent。
+// withRollbackOnly attaches the rollback-only state of a new scope to ctx, with the outer scope's as its parent.
+func withRollbackOnly(ctx context.Context) (context.Context, *rollbackOnly) {
+	parent, _ := ctx.Value(rollbackOnlyKey{}).(*rollbackOnly)
+	scope := &rollbackOnly{parent: parent}
+	return context.WithValue(ctx, rollbackOnlyKey{}, scope), scope
+}
+
+// mark 將範圍設為 rollback-only；nil 時不做任何事。
+// mark makes the scope rollback-only; it does nothing on nil.
+func (r *rollbackOnly) mark(err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cause == nil {
+		r.cause = err
+	}
+}
+
+// markAll 將範圍與所有外層範圍設為 rollback-only，用於整個交易已無法提交時。
+// markAll makes the scope and every outer scope rollback-only, for when the whole transaction cannot commit.
+func (r *rollbackOnly) markAll(err error) {
+	for scope := r; scope != nil; scope = scope.parent {
+		scope.mark(err)
+	}
+}
+
+// reset 清除狀態，供交易重試時使用。
+// reset clears the state before a transaction is retried.
+func (r *rollbackOnly) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cause = nil
+}
+
+// failure 範圍為 rollback-only 時回傳包裝原因的 ErrTransactionRollbackOnly，否則返回 nil。
+// failure returns ErrTransactionRollbackOnly wrapping the cause when the scope is rollback-only, and nil otherwise.
+func (r *rollbackOnly) failure() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cause == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrTransactionRollbackOnly, r.cause)
+}
+
+// savepointKey - savepoint 範圍在 context 中的鍵
+// Context key of the savepoint scope
+type savepointKey struct{}
+
+// savepoint - 模擬 savepoint 的範圍，記錄範圍內寫入的補償動作
+// An emulated savepoint scope holding the compensating actions of the writes made inside it
+type savepoint struct {
+	mu      sync.Mutex
+	actions []func(ctx context.Context) error
+}
+
+// OnRollback 在目前的 savepoint 範圍（PropagationNested）登記補償動作，範圍失敗時會在外層交易中執行；
+// 不在 savepoint 範圍中時不做任何事。
+// OnRollback registers a compensating action in the current savepoint scope (PropagationNested); it runs inside
+// the outer transaction when the scope fails. Outside a savepoint scope it does nothing.
+func OnRollback(ctx context.Context, fn func(ctx context.Context) error) {
+	if sp, _ := ctx.Value(savepointKey{}).(*savepoint); sp != nil {
+		sp.add(fn)
+	}
+}
+
+func (s *savepoint) add(fn func(ctx context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.actions = append(s.actions, fn)
+}
+
+// merge 將成功結束的內層範圍的補償動作併入外層範圍，外層失敗時一併撤銷。
+// merge moves the actions of a successful inner scope into this one, so they are undone if this scope fails.
+func (s *savepoint) merge(inner *savepoint) {
+	inner.mu.Lock()
+	actions := inner.actions
+	inner.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.actions = append(s.actions, actions...)
+}
+
+// rollback 依相反順序執行補償動作。
+// rollback runs the compensating actions in reverse order.
+func (s *savepoint) rollback(ctx context.Context) error {
+	s.mu.Lock()
+	actions := s.actions
+	s.actions = nil
+	s.mu.Unlock()
+	for i := len(actions) - 1; i >= 0; i-- {
+		if err := actions[i](ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// activeSession 回傳 context 中正在進行交易的 session，沒有時返回 nil。
+// activeSession returns the session of ctx when it has a transaction in progress, or nil.
+func activeSession(ctx context.Context) mongo.Session {
+	session := mongo.SessionFromContext(ctx)
+	if session == nil {
+		return nil
+	}
+	if x, ok := session.(mongo.XSession); ok && !x.ClientSession().TransactionRunning() {
+		return nil
+	}
+	return session
+}
+
+// joinTransaction 在外層交易中執行回呼。PropagationRequired 失敗時讓最近的範圍成為 rollback-only；
+// PropagationNested 建立 savepoint 範圍，失敗時執行補償動作，伺服器錯誤已中止交易時則讓所有範圍成為 rollback-only。
+// joinTransaction runs the callback inside the outer transaction. A failing PropagationRequired callback makes the
+// nearest scope rollback-only; PropagationNested opens a savepoint scope and runs the compensating actions on failure,
+// or makes every scope rollback-only when a server error has aborted the transaction.
+func (o *GODM) joinTransaction(session mongo.Session, callback func(sessCtx mongo.SessionContext) error, nested bool) error {
+	outer := o.getContext()
+	parentScope, _ := outer.Value(rollbackOnlyKey{}).(*rollbackOnly)
+	if !nested {
+		err := callback(mongo.NewSessionContext(outer, session))
+		if err != nil {
+			parentScope.mark(err)
+		}
+		return err
+	}
+
+	parent, _ := outer.Value(savepointKey{}).(*savepoint)
+	outerEvents := txEventsFromContext(outer)
+	sp := &savepoint{}
+	ctx, scope := withRollbackOnly(context.WithValue(outer, savepointKey{}, sp))
+	ctx, events := withTxEvents(ctx)
+
+	err := callback(mongo.NewSessionContext(ctx, session))
+	if err == nil {
+		err = scope.failure()
+	}
+	if err != nil {
+		events.discard()
+		var server mongo.ServerError
+		if errors.As(err, &server) {
+			parentScope.markAll(err)
+			return fmt.Errorf("savepoint rollback error: %w: %w", ErrTransactionAborted, err)
+		}
+		if rbErr := sp.rollback(mongo.NewSessionContext(outer, session)); rbErr != nil {
+			parentScope.markAll(rbErr)
+			return fmt.Errorf("savepoint rollback error: %w", errors.Join(err, rbErr))
+		}
+		return fmt.Errorf("savepoint rolled back: %w", err)
+	}
+	if parent != nil {
+		parent.merge(sp)
+	}
+	if outerEvents != nil {
+		outerEvents.merge(events)
+	} else {
+		events.flush()
+	}
+	return nil
+}
+
+// savepointScope 回傳此查詢 context 中的 savepoint 範圍，不在範圍中時返回 nil。
+// savepointScope returns the savepoint scope of this builder's context, or nil outside one.
+func (o *GODM) savepointScope() *savepoint {
+	sp, _ := o.getContext().Value(savepointKey{}).(*savepoint)
+	return sp
+}
+
+// snapshot 在 savepoint 範圍中取回即將被修改的文檔，供補償時還原；不在範圍中時不查詢。
+// snapshot fetches the documents about to change inside a savepoint scope so they can be restored; outside a
+// scope it does not query at all.
+func (o *GODM) snapshot(filter interface{}, many bool) ([]bson.M, error) {
+	if o.savepointScope() == nil {
+		return nil, nil
+	}
+	opts := options.Find()
+	if !many {
+		opts.SetLimit(1)
+	}
+	cursor, err := o.Collection.Find(o.getContext(), filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot error: %w", err)
+	}
+	defer cursor.Close(o.getContext())
+	var docs []bson.M
+	if err := cursor.All(o.getContext(), &docs); err != nil {
+		return nil, fmt.Errorf("snapshot error: %w", err)
+	}
+	return docs, nil
+}
+
+// compensateInsert 在 savepoint 範圍中登記刪除新插入文檔的補償動作。
+// compensateInsert registers, inside a savepoint scope, a compensating action deleting the inserted documents.
+func (o *GODM) compensateInsert(ids ...interface{}) {
+	sp := o.savepointScope()
+	if sp == nil || len(ids) == 0 {
+		return
+	}
+	collection := o.Collection
+	sp.add(func(ctx context.Context) error {
+		if _, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			return fmt.Errorf("compensate insert error: %w", err)
+		}
+		return nil
+	})
+}
+
+// compensateRestore 在 savepoint 範圍中登記以原文檔覆蓋（或重新插入）的補償動作。
+// compensateRestore registers, inside a savepoint scope, a compensating action replacing (or re-inserting)
+// the original documents.
+func (o *GODM) compensateRestore(docs []bson.M) {
+	sp := o.savepointScope()
+	if sp == nil || len(docs) == 0 {
+		return
+	}
+	collection := o.Collection
+	sp.add(func(ctx context.Context) error {
+		for _, doc := range docs {
+			opts := options.Replace().SetUpsert(true)
+			if _, err := collection.ReplaceOne(ctx, bson.M{"_id": doc["_id"]}, doc, opts); err != nil {
+				return fmt.Errorf("compensate restore error: %w", err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,161 @@
+package odm
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// transaction_retry.go - 交易選項與重試規則：TransientTransactionError 重新執行整個交易，
+// UnknownTransactionCommitResult 只重試提交，皆以指數退避並受最長重試時間限制
+// Transaction options and retry rules: a TransientTransactionError reruns the whole transaction and an
+// UnknownTransactionCommitResult retries only the commit, both with exponential backoff within a maximum duration.
+
+const (
+	// DefaultTransactionRetryDuration - 預設的最長重試時間（與驅動程式的 WithTransaction 相同）
+	// Default maximum retry duration (the same as the driver's WithTransaction)
+	DefaultTransactionRetryDuration = 120 * time.Second
+	// DefaultTransactionRetryBackoff - 預設的初始退避時間，每次重試加倍
+	// Default initial backoff, doubled on every retry
+	DefaultTransactionRetryBackoff = 10 * time.Millisecond
+
+	maxTransactionRetryBackoff = time.Second
+
+	// 驅動程式錯誤標籤 / driver error labels
+	transientTransactionError      = "TransientTransactionError"
+	unknownTransactionCommitResult = "UnknownTransactionCommitResult"
+
+	// maxTimeMSExpiredCode - 提交逾時（MaxTimeMSExpired）的錯誤碼，此時不重試提交
+	// Error code of an expired commit (MaxTimeMSExpired); the commit is not retried then
+	maxTimeMSExpiredCode = 50
+)
+
+// TransactionOptions - 交易選項，未設定的欄位使用連線的預設值
+// Transaction options; unset fields fall back to the client defaults
+type TransactionOptions struct {
+	ReadConcern    *readconcern.ReadConcern   // 讀取關注 / read concern
+	WriteConcern   *writeconcern.WriteConcern // 寫入關注 / write concern
+	ReadPreference *readpref.ReadPref         // 讀取偏好 / read preference
+	MaxCommitTime  *time.Duration             // 提交的最長時間 / maximum time a commit may take
+
+	// 重試的最長時間與初始退避時間，零值時使用 DefaultTransactionRetryDuration 與 DefaultTransactionRetryBackoff；
+	// MaxRetryDuration 為負值時不重試
+	// Maximum retry duration and initial backoff; zero values use DefaultTransactionRetryDuration and
+	// DefaultTransactionRetryBackoff, and a negative MaxRetryDuration disables retries
+	MaxRetryDuration time.Duration
+	RetryBackoff     time.Duration
+
+	// 已在交易中時的行為，預設 PropagationRequired（加入外層交易）；加入外層交易時其餘選項不生效
+	// Behaviour when a transaction is already active, PropagationRequired (join the outer one) by default;
+	// the other options have no effect when joining
+	Propagation TransactionPropagation
+}
+
+// mergeTransactionOptions 合併多組選項，後面的設定覆寫前面的設定。
+// mergeTransactionOptions merges option sets; later values override earlier ones.
+func mergeTransactionOptions(opts []*TransactionOptions) *TransactionOptions {
+	merged := &TransactionOptions{}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.ReadConcern != nil {
+			merged.ReadConcern = opt.ReadConcern
+		}
+		if opt.WriteConcern != nil {
+			merged.WriteConcern = opt.WriteConcern
+		}
+		if opt.ReadPreference != nil {
+			merged.ReadPreference = opt.ReadPreference
+		}
+		if opt.MaxCommitTime != nil {
+			merged.MaxCommitTime = opt.MaxCommitTime
+		}
+		if opt.MaxRetryDuration != 0 {
+			merged.MaxRetryDuration = opt.MaxRetryDuration
+		}
+		if opt.RetryBackoff != 0 {
+			merged.RetryBackoff = opt.RetryBackoff
+		}
+		if opt.Propagation != PropagationRequired {
+			merged.Propagation = opt.Propagation
+		}
+	}
+	if merged.MaxRetryDuration == 0 {
+		merged.MaxRetryDuration = DefaultTransactionRetryDuration
+	}
+	if merged.RetryBackoff <= 0 {
+		merged.RetryBackoff = DefaultTransactionRetryBackoff
+	}
+	return merged
+}
+
+// driverOptions 轉換為驅動程式的交易選項。
+// driverOptions converts to the driver's transaction options.
+func (t *TransactionOptions) driverOptions() *options.TransactionOptions {
+	opts := options.Transaction()
+	if t.ReadConcern != nil {
+		opts.SetReadConcern(t.ReadConcern)
+	}
+	if t.WriteConcern != nil {
+		opts.SetWriteConcern(t.WriteConcern)
+	}
+	if t.ReadPreference != nil {
+		opts.SetReadPreference(t.ReadPreference)
+	}
+	if t.MaxCommitTime != nil {
+		opts.SetMaxCommitTime(t.MaxCommitTime)
+	}
+	return opts
+}
+
+// canRetry 判斷自 start 起是否仍在最長重試時間內。
+// canRetry reports whether the maximum retry duration since start has not elapsed yet.
+func (t *TransactionOptions) canRetry(start time.Time) bool {
+	return t.MaxRetryDuration > 0 && time.Since(start) < t.MaxRetryDuration
+}
+
+// backoff 等待第 attempt 次重試的退避時間（指數成長，上限一秒），ctx 結束時提前返回錯誤。
+// backoff waits the backoff of retry attempt (growing exponentially, capped at one second); it returns early with
+// an error when ctx is done.
+func (t *TransactionOptions) backoff(ctx context.Context, attempt int) error {
+	delay := t.RetryBackoff
+	for i := 0; i < attempt && delay < maxTransactionRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxTransactionRetryBackoff {
+		delay = maxTransactionRetryBackoff
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// hasErrorLabel 判斷錯誤鏈中是否有帶有 label 的驅動程式錯誤。
+// hasErrorLabel reports whether a driver error in the chain carries label.
+func hasErrorLabel(err error, label string) bool {
+	var labeled mongo.LabeledError
+	return errors.As(err, &labeled) && labeled.HasErrorLabel(label)
+}
+
+// retryableCommit 判斷提交錯誤是否可只重試提交：UnknownTransactionCommitResult 且不是提交逾時。
+// retryableCommit reports whether only the commit should be retried: UnknownTransactionCommitResult that is not
+// an expired commit.
+func retryableCommit(err error) bool {
+	if !hasErrorLabel(err, unknownTransactionCommitResult) {
+		return false
+	}
+	var server mongo.ServerError
+	return !(errors.As(err, &server) && server.HasErrorCode(maxTimeMSExpiredCode))
+}
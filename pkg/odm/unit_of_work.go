@@ -0,0 +1,510 @@
+package odm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// unit_of_work.go - Unit of Work：追蹤新增、修改與刪除的模型，Commit 時依集合分組以 BulkWrite 一次寫入
+// Unit of Work: tracks new, dirty and deleted models and writes them with one BulkWrite per collection on Commit.
+//
+// 範例 / Example:
+//
+//	uow := odm.NewUnitOfWork(ctx, &odm.UnitOfWorkOptions{Transaction: true})
+//	uow.RegisterNew(order, item1, item2)
+//	uow.RegisterDirty(user)
+//	uow.RegisterDeleted(cart)
+//	if err := uow.Commit(); err != nil {
+//		var failed *odm.UnitOfWorkError
+//		if errors.As(err, &failed) {
+//			for _, f := range failed.Failures {
+//				log.Printf("%s %T: %v", f.Operation, f.Model, f.Err)
+//			}
+//		}
+//	}
+//
+// 事件順序：先依登記順序觸發所有模型的前置事件（saving → creating、saving → updating、deleting），
+// 再依集合首次登記的順序寫入，最後依登記順序觸發成功寫入模型的後置事件（created → saved、updated → saved、deleted）。
+// 前置事件回傳錯誤時不會寫入任何資料。交易模式下後置事件於交易提交成功後才觸發，交易重試或中止時不觸發。
+// Event order: the before-events of every model fire first, in registration order (saving → creating,
+// saving → updating, deleting); the collections are then written in the order they were first registered, and
+// finally the after-events of the models that were written fire in registration order (created → saved,
+// updated → saved, deleted). An error from a before-event aborts the commit before anything is written. In
+// transaction mode the after-events fire once the transaction commits, never for a retried or aborted attempt.
+
+// ErrWriteSkipped - 有序寫入時，因先前的寫入失敗而未執行的寫入
+// A write that did not run because an earlier write failed in ordered mode
+var ErrWriteSkipped = errors.New("write skipped after an earlier failure")
+
+// UnitOfWorkOptions - Unit of Work 的選項
+// Options of a unit of work
+type UnitOfWorkOptions struct {
+	// Unordered 為 true 時以無序 BulkWrite 寫入：失敗的寫入不會中止同一集合與其他集合的寫入
+	// When true the BulkWrites are unordered: a failed write stops neither its own collection nor the others
+	Unordered bool
+
+	// Transaction 為 true 時在交易中寫入，任何寫入失敗都會中止整個交易（已在交易中時依 TransactionOptions 的 Propagation 加入）
+	// When true everything is written in a transaction and any failed write aborts it (inside a transaction
+	// the Propagation of TransactionOptions applies)
+	Transaction        bool
+	TransactionOptions *TransactionOptions
+}
+
+// unitState - 模型在 Unit of Work 中的狀態
+// State of a model in a unit of work
+type unitState int
+
+const (
+	unitNew unitState = iota
+	unitDirty
+	unitDeleted
+)
+
+// unitEntry - Unit of Work 追蹤的模型
+// A model tracked by a unit of work
+type unitEntry struct {
+	model interface{}
+	state unitState
+}
+
+// UnitOfWork - 追蹤模型的變更並於 Commit 時批次寫入，由 NewUnitOfWork 建立
+// Tracks model changes and writes them in batches on Commit; created by NewUnitOfWork
+type UnitOfWork struct {
+	ctx     context.Context
+	opts    UnitOfWorkOptions
+	entries []*unitEntry
+	tracked map[interface{}]*unitEntry
+}
+
+// WriteFailure - 單一模型寫入失敗的原因
+// Why the write of a single model failed
+type WriteFailure struct {
+	Model     interface{} // 寫入失敗的模型 / the model whose write failed
+	Operation string      // "insert"、"update" 或 "delete" / "insert", "update" or "delete"
+	Err       error       // 驅動程式回傳的錯誤或 ErrWriteSkipped / the error reported by the driver, or ErrWriteSkipped
+}
+
+// UnitOfWorkError - Commit 有模型寫入失敗時回傳的錯誤
+// Returned by Commit when the writes of some models failed
+type UnitOfWorkError struct {
+	Failures []WriteFailure
+}
+
+func (e *UnitOfWorkError) Error() string {
+	messages := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		messages[i] = fmt.Sprintf("%s %T: %v", f.Operation, f.Model, f.Err)
+	}
+	return fmt.Sprintf("unit of work: %d write(s) failed: %s", len(e.Failures), strings.Join(messages, "; "))
+}
+
+// Unwrap 回傳每個失敗的錯誤，供 errors.Is / errors.As 使用。
+// Unwrap returns the error of every failure, for errors.Is / errors.As.
+func (e *UnitOfWorkError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// NewUnitOfWork 建立以 ctx 執行的 Unit of Work。
+// NewUnitOfWork creates a unit of work running with ctx.
+func NewUnitOfWork(ctx context.Context, opts ...*UnitOfWorkOptions) *UnitOfWork {
+	u := &UnitOfWork{ctx: ctx, tracked: map[interface{}]*unitEntry{}}
+	for _, opt := range opts {
+		if opt != nil {
+			u.opts = *opt
+		}
+	}
+	return u
+}
+
+// RegisterNew 登記要新增的模型（結構指標）；_id 為零值的 ObjectID 會在 Commit 時產生。
+// RegisterNew registers models (struct pointers) to insert; zero ObjectID _id fields are generated on Commit.
+func (u *UnitOfWork) RegisterNew(models ...interface{}) *UnitOfWork {
+	for _, model := range models {
+		u.register(model, unitNew)
+	}
+	return u
+}
+
+// RegisterDirty 登記要更新的模型，Commit 時以 $set 寫入除 _id 與關聯欄位以外的所有欄位；已登記為新增的模型維持新增。
+// RegisterDirty registers models to update; Commit writes every field but _id and the relation fields with $set.
+// Models already registered as new stay new.
+func (u *UnitOfWork) RegisterDirty(models ...interface{}) *UnitOfWork {
+	for _, model := range models {
+		u.register(model, unitDirty)
+	}
+	return u
+}
+
+// RegisterDeleted 登記要刪除的模型；已登記為新增的模型直接取消追蹤。
+// RegisterDeleted registers models to delete; models registered as new are simply no longer tracked.
+func (u *UnitOfWork) RegisterDeleted(models ...interface{}) *UnitOfWork {
+	for _, model := range models {
+		u.register(model, unitDeleted)
+	}
+	return u
+}
+
+func (u *UnitOfWork) register(model interface{}, state unitState) {
+	entry, ok := u.tracked[model]
+	if !ok {
+		entry = &unitEntry{model: model, state: state}
+		u.tracked[model] = entry
+		u.entries = append(u.entries, entry)
+		return
+	}
+	switch {
+	case entry.state == unitNew && state == unitDirty:
+	case entry.state == unitNew && state == unitDeleted:
+		u.forget(entry)
+	default:
+		entry.state = state
+	}
+}
+
+func (u *UnitOfWork) forget(entry *unitEntry) {
+	delete(u.tracked, entry.model)
+	for i, e := range u.entries {
+		if e == entry {
+			u.entries = append(u.entries[:i], u.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// Len 回傳目前追蹤的模型數量。
+// Len returns the number of tracked models.
+func (u *UnitOfWork) Len() int {
+	return len(u.entries)
+}
+
+// Clear 取消追蹤所有模型。
+// Clear stops tracking every model.
+func (u *UnitOfWork) Clear() {
+	u.entries = nil
+	u.tracked = map[interface{}]*unitEntry{}
+}
+
+// Commit 寫入所有追蹤的變更；全部成功時清空追蹤，有模型寫入失敗時回傳 *UnitOfWorkError。
+// 交易模式下整個交易會中止，所有模型維持追蹤；否則已寫入的模型取消追蹤，再次 Commit 只會重試未寫入的模型。
+// 有序寫入時，失敗之後未執行的寫入以 ErrWriteSkipped 列入失敗。
+// Commit writes every tracked change and stops tracking them when all succeed. When some writes fail it returns
+// a *UnitOfWorkError. In transaction mode the whole transaction is aborted and every model stays tracked;
+// otherwise the models already written are no longer tracked, so committing again only retries the others.
+// In ordered mode the writes that did not run after a failure are reported as failures with ErrWriteSkipped.
+func (u *UnitOfWork) Commit() error {
+	if len(u.entries) == 0 {
+		return nil
+	}
+	for _, entry := range u.entries {
+		if entry.state == unitNew {
+			continue
+		}
+		if id, ok := fieldByBsonName(entry.model, "_id"); !ok || id.IsZero() {
+			return fmt.Errorf("unit of work error: %T has no _id", entry.model)
+		}
+	}
+
+	var err error
+	if u.opts.Transaction {
+		o := &GODM{Ctx: u.ctx}
+		err = o.WithTransaction(func(sessCtx mongo.SessionContext) error {
+			written, queries, err := u.write(sessCtx)
+			if err != nil {
+				return err
+			}
+			// 後置事件等交易提交後才觸發，重試或中止時捨棄 / after-events wait for the commit and are dropped on retry or abort
+			entries := u.entries
+			events := txEventsFromContext(sessCtx)
+			if events == nil {
+				return u.fireAfter(entries, queries, written, false)
+			}
+			events.add(func() {
+				_ = u.fireAfter(entries, queries, written, true)
+			})
+			return nil
+		}, u.opts.TransactionOptions)
+	} else {
+		var written []*unitEntry
+		written, err = u.apply(u.ctx)
+		if err != nil {
+			for _, entry := range written {
+				u.forget(entry)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	u.Clear()
+	return nil
+}
+
+// unitGroup - 同一集合中要以一次 BulkWrite 寫入的模型
+// The models of one collection written with a single BulkWrite
+type unitGroup struct {
+	query   *GODM
+	entries []*unitEntry
+	writes  []mongo.WriteModel
+}
+
+// apply 觸發前置事件、依集合寫入並觸發成功寫入模型的後置事件，回傳已寫入的模型。
+// apply fires the before-events, writes each collection and fires the after-events of the written models; it
+// returns the models that were written.
+func (u *UnitOfWork) apply(ctx context.Context) ([]*unitEntry, error) {
+	written, queries, err := u.write(ctx)
+	var failed *UnitOfWorkError
+	if err != nil && !errors.As(err, &failed) {
+		return written, err
+	}
+	if err := u.fireAfter(u.entries, queries, written, false); err != nil {
+		return written, err
+	}
+	return written, err
+}
+
+// write 觸發前置事件並依集合寫入，回傳已寫入的模型與各模型的查詢；有模型寫入失敗時回傳 *UnitOfWorkError。
+// write fires the before-events and writes each collection; it returns the models written and the builder of
+// every model, with a *UnitOfWorkError when some writes failed.
+func (u *UnitOfWork) write(ctx context.Context) ([]*unitEntry, map[*unitEntry]*GODM, error) {
+	queries := make(map[*unitEntry]*GODM, len(u.entries))
+	for _, entry := range u.entries {
+		q := modelQuery(ctx, entry.model)
+		queries[entry] = q
+		for _, stage := range unitStages(entry.state, true) {
+			if err := q.fire(stage, entry.model); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	groups, err := u.groups(queries)
+	if err != nil {
+		return nil, nil, err
+	}
+	var failures []WriteFailure
+	var written []*unitEntry
+	for _, g := range groups {
+		if len(failures) > 0 && !u.opts.Unordered {
+			// 有序寫入在失敗後不再寫入其他集合 / ordered mode writes no further collection after a failure
+			failures = append(failures, g.skipped(0)...)
+			continue
+		}
+		done, failed, err := g.write(!u.opts.Unordered)
+		if err != nil {
+			return written, queries, err
+		}
+		written = append(written, done...)
+		failures = append(failures, failed...)
+	}
+	if len(failures) > 0 {
+		return written, queries, &UnitOfWorkError{Failures: failures}
+	}
+	return written, queries, nil
+}
+
+// fireAfter 依登記順序觸發已寫入模型的後置事件。deferred 為 true 時（交易提交後）以登記時的 context 觸發，
+// 錯誤交由 RegisterObserverErrorHandler 設定的處理函式，並繼續觸發其他模型的事件。
+// fireAfter fires the after-events of the written models in registration order. When deferred (after a commit)
+// they fire with the unit of work's own context, and errors go to the handler set with
+// RegisterObserverErrorHandler while the other models still get their events.
+func (u *UnitOfWork) fireAfter(entries []*unitEntry, queries map[*unitEntry]*GODM, written []*unitEntry, deferred bool) error {
+	done := make(map[*unitEntry]bool, len(written))
+	for _, entry := range written {
+		done[entry] = true
+	}
+	for _, entry := range entries {
+		if !done[entry] {
+			continue
+		}
+		q := queries[entry]
+		if deferred {
+			copied := *q
+			copied.Ctx = u.ctx
+			q = &copied
+		}
+		for _, stage := range unitStages(entry.state, false) {
+			if err := q.fire(stage, entry.model); err != nil {
+				if !deferred {
+					return err
+				}
+				handleObserverError(err, stage, entry.model)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// groups 依集合首次登記的順序將模型分組並建立寫入模型。
+// groups groups the models per collection, in the order each collection was first registered, and builds their writes.
+func (u *UnitOfWork) groups(queries map[*unitEntry]*GODM) ([]*unitGroup, error) {
+	var groups []*unitGroup
+	byCollection := map[string]*unitGroup{}
+	for _, entry := range u.entries {
+		q := queries[entry]
+		key := q.Collection.Database().Name() + "." + q.Collection.Name()
+		g, ok := byCollection[key]
+		if !ok {
+			g = &unitGroup{query: q}
+			byCollection[key] = g
+			groups = append(groups, g)
+		}
+		write, err := unitWrite(q, entry)
+		if err != nil {
+			return nil, err
+		}
+		g.entries = append(g.entries, entry)
+		g.writes = append(g.writes, write)
+	}
+	return groups, nil
+}
+
+// write 以一次 BulkWrite 寫入此集合，回傳成功寫入與寫入失敗的模型；非寫入錯誤（例如連線錯誤）直接回傳。
+// write runs the BulkWrite of this collection and returns the models written and the failed ones; errors that are
+// not write errors (a network error, say) are returned as such.
+func (g *unitGroup) write(ordered bool) ([]*unitEntry, []WriteFailure, error) {
+	q := g.query
+	snapshot, err := g.snapshot()
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = q.Collection.BulkWrite(q.getContext(), g.writes, options.BulkWrite().SetOrdered(ordered))
+	var bulkErr mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bulkErr) {
+		return nil, nil, fmt.Errorf("unit of work error: %w", err)
+	}
+
+	failed := map[int]error{}
+	for _, we := range bulkErr.WriteErrors {
+		failed[we.Index] = we
+	}
+	if bulkErr.WriteConcernError != nil && len(failed) == 0 {
+		return nil, nil, fmt.Errorf("unit of work error: %w", err)
+	}
+	stop := len(g.entries)
+	if ordered && len(bulkErr.WriteErrors) > 0 {
+		stop = bulkErr.WriteErrors[0].Index
+	}
+
+	var done []*unitEntry
+	var failures []WriteFailure
+	var inserted []interface{}
+	for i, entry := range g.entries {
+		if err, ok := failed[i]; ok {
+			failures = append(failures, WriteFailure{Model: entry.model, Operation: unitOperation(entry.state), Err: err})
+			continue
+		}
+		if i > stop {
+			failures = append(failures, g.skipped(i)[0])
+			continue
+		}
+		done = append(done, entry)
+		if entry.state == unitNew {
+			if id, ok := fieldByBsonName(entry.model, "_id"); ok {
+				inserted = append(inserted, id.Interface())
+			}
+		}
+	}
+	q.compensateInsert(inserted...)
+	q.compensateRestore(snapshot)
+	return done, failures, nil
+}
+
+// skipped 回傳第 from 個之後（含）未執行的寫入，皆以 ErrWriteSkipped 表示。
+// skipped reports the writes from index from onwards as not run, with ErrWriteSkipped.
+func (g *unitGroup) skipped(from int) []WriteFailure {
+	var failures []WriteFailure
+	for _, entry := range g.entries[from:] {
+		failures = append(failures, WriteFailure{Model: entry.model, Operation: unitOperation(entry.state), Err: ErrWriteSkipped})
+	}
+	return failures
+}
+
+// snapshot 在 savepoint 範圍中取回即將被更新或刪除的文檔，供補償時還原。
+// snapshot fetches, inside a savepoint scope, the documents about to be updated or deleted so they can be restored.
+func (g *unitGroup) snapshot() ([]bson.M, error) {
+	var ids []interface{}
+	for _, entry := range g.entries {
+		if entry.state == unitNew {
+			continue
+		}
+		if id, ok := fieldByBsonName(entry.model, "_id"); ok {
+			ids = append(ids, id.Interface())
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	docs, err := g.query.snapshot(bson.M{"_id": bson.M{"$in": ids}}, true)
+	if err != nil {
+		return nil, fmt.Errorf("unit of work error: %w", err)
+	}
+	return docs, nil
+}
+
+// unitWrite 建立模型對應的寫入：新增為 InsertOne，修改為以 _id 比對的 $set，刪除為以 _id 比對的 DeleteOne。
+// unitWrite builds the write of a model: InsertOne for new models, a $set matched on _id for dirty ones and a
+// DeleteOne matched on _id for deleted ones.
+func unitWrite(q *GODM, entry *unitEntry) (mongo.WriteModel, error) {
+	if entry.state == unitNew {
+		ensureObjectID(entry.model)
+		doc, err := q.insertDocument(entry.model)
+		if err != nil {
+			return nil, fmt.Errorf("unit of work error: %w", err)
+		}
+		return mongo.NewInsertOneModel().SetDocument(doc), nil
+	}
+	id, _ := fieldByBsonName(entry.model, "_id")
+	filter := bson.D{{Key: "_id", Value: id.Interface()}}
+	if entry.state == unitDeleted {
+		return mongo.NewDeleteOneModel().SetFilter(filter), nil
+	}
+	doc, err := saveDocument(entry.model)
+	if err != nil {
+		return nil, fmt.Errorf("unit of work error: %w", err)
+	}
+	return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(bson.M{"$set": q.withoutRelationFields(doc)}), nil
+}
+
+// unitStages 回傳狀態對應的前置（before 為 true）或後置事件。
+// unitStages returns the before-events (before is true) or the after-events of a state.
+func unitStages(state unitState, before bool) []string {
+	switch state {
+	case unitNew:
+		if before {
+			return []string{"saving", "creating"}
+		}
+		return []string{"created", "saved"}
+	case unitDirty:
+		if before {
+			return []string{"saving", "updating"}
+		}
+		return []string{"updated", "saved"}
+	default:
+		if before {
+			return []string{"deleting"}
+		}
+		return []string{"deleted"}
+	}
+}
+
+func unitOperation(state unitState) string {
+	switch state {
+	case unitNew:
+		return "insert"
+	case unitDirty:
+		return "update"
+	default:
+		return "delete"
+	}
+}
@@ -1,8 +1,11 @@
 package odm
 
 import (
+	"context"
 	"fmt"
+	"maps"
 	"reflect"
+	"slices"
 	"strings"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -11,7 +14,13 @@ import (
 // hasObjectIDField 檢查模型是否包含 _id 欄位且型別為 primitive.ObjectID。
 // hasObjectIDField checks if the model has a _id field of type primitive.ObjectID.
 func (o *GODM) hasObjectIDField() bool {
-	typ := reflect.TypeOf(o.Model).Elem()
+	typ := reflect.TypeOf(o.Model)
+	if typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return false
+	}
 	for i := 0; i < typ.NumField(); i++ {
 		field := typ.Field(i)
 		if strings.HasPrefix(field.Tag.Get("bson"), "_id") && field.Type == reflect.TypeOf(primitive.ObjectID{}) {
@@ -33,3 +42,117 @@ func parseObjectID(id interface{}) (primitive.ObjectID, error) {
 		return primitive.NilObjectID, fmt.Errorf("unsupported id type: %T", id)
 	}
 }
+
+// bsonFieldName 回傳結構欄位對應的 bson 欄位名稱（未設定 tag 時為欄位名小寫）。
+// bsonFieldName returns the bson key of a struct field (the lowercased field name when no tag is set).
+func bsonFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("bson"), ",")[0]
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// fieldByBsonName 在模型（結構指標）中尋找 bson 名稱相符的欄位。
+// fieldByBsonName looks up the field whose bson key matches name on a struct pointer model.
+func fieldByBsonName(model interface{}, name string) (reflect.Value, bool) {
+	val := reflect.ValueOf(model)
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return reflect.Value{}, false
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("bson") == "-" {
+			continue
+		}
+		if bsonFieldName(field) == name {
+			return val.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// elementPointers 將結構、結構指標或其切片展開為結構指標列表。
+// elementPointers flattens a struct, struct pointer or slice of either into a list of struct pointers.
+func elementPointers(val reflect.Value) []interface{} {
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return nil
+		}
+		if val.Kind() == reflect.Ptr && val.Elem().Kind() == reflect.Struct {
+			return []interface{}{val.Interface()}
+		}
+		val = val.Elem()
+	}
+	switch val.Kind() {
+	case reflect.Struct:
+		if val.CanAddr() {
+			return []interface{}{val.Addr().Interface()}
+		}
+	case reflect.Slice, reflect.Array:
+		var items []interface{}
+		for i := 0; i < val.Len(); i++ {
+			items = append(items, elementPointers(val.Index(i))...)
+		}
+		return items
+	}
+	return nil
+}
+
+// godmModel - 內嵌 GODM 的模型（*GODM 本身也符合）
+// A model embedding GODM (*GODM itself qualifies as well)
+type godmModel interface {
+	godm() *GODM
+}
+
+func (o *GODM) godm() *GODM {
+	return o
+}
+
+// embeddedGODM 回傳模型內嵌的 GODM，未內嵌時返回 nil。
+// embeddedGODM returns the GODM embedded in model, or nil when it has none.
+func embeddedGODM(model interface{}) *GODM {
+	if m, ok := model.(godmModel); ok {
+		return m.godm()
+	}
+	return nil
+}
+
+// clone 複製查詢；切片會截斷容量、map 會另行複製，讓副本之後加入的條件與設定不會影響原查詢。
+// clone copies the builder; slices are clipped and maps copied, so conditions and settings added to the copy never
+// leak into the original.
+func (o *GODM) clone() *GODM {
+	q := *o
+	q.Filter = slices.Clip(o.Filter)
+	q.OrFilter = slices.Clip(o.OrFilter)
+	q.WithRelations = slices.Clip(o.WithRelations)
+	q.relationQueries = slices.Clip(o.relationQueries)
+	q.Observers = slices.Clip(o.Observers)
+	q.RelationConfigs = maps.Clone(o.RelationConfigs)
+	q.RelationConstraints = maps.Clone(o.RelationConstraints)
+	return &q
+}
+
+// modelQuery 建立操作模型用的查詢：沿用模型內嵌 GODM 的設定（集合、觀察者等），尚未 Use 時自動 Use。
+// modelQuery builds a builder for model: it keeps the settings of the GODM embedded in the model
+// (collection, observers, ...) and calls Use when it has not been set up yet.
+func modelQuery(ctx context.Context, model interface{}) *GODM {
+	q := &GODM{}
+	if g := embeddedGODM(model); g != nil {
+		copied := *g
+		q = &copied
+	}
+	if q.Collection == nil {
+		q.Use(model)
+	}
+	q.Model = model
+	q.Ctx = ctx
+	return q
+}
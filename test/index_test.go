@@ -0,0 +1,161 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"godm/pkg/odm"
+)
+
+type idxAccount struct {
+	Email     string    `bson:"email" index:"asc,unique,locale=en,strength=2"`
+	Bio       string    `bson:"bio" index:"text"`
+	Headline  string    `bson:"headline" index:"text"`
+	Location  bson.M    `bson:"location" index:"2dsphere,sparse"`
+	TenantID  string    `bson:"tenant_id" index:"asc,name=tenant_created"`
+	CreatedAt time.Time `bson:"created_at" index:"desc,name=tenant_created"`
+	ExpiresAt time.Time `bson:"expires_at" index:"asc,ttl=3600"`
+	Status    string    `bson:"status"`
+}
+
+func (idxAccount) Indexes() []odm.Index {
+	return []odm.Index{{
+		Keys:          bson.D{{Key: "status", Value: "hashed"}},
+		PartialFilter: bson.M{"status": bson.M{"$exists": true}},
+	}}
+}
+
+type idxBroken struct {
+	Name string `bson:"name" index:"fulltext"`
+}
+
+func TestIndexesOf_TagsAndMethod(t *testing.T) {
+	indexes, err := odm.IndexesOf(&idxAccount{})
+	assert.NoError(t, err)
+
+	ttl := time.Hour
+	assert.Equal(t, []odm.Index{
+		{Name: "email_1", Keys: bson.D{{Key: "email", Value: 1}}, Unique: true, Collation: &options.Collation{Locale: "en", Strength: 2}},
+		{Name: "bio_text_headline_text", Keys: bson.D{{Key: "bio", Value: "text"}, {Key: "headline", Value: "text"}}},
+		{Name: "location_2dsphere", Keys: bson.D{{Key: "location", Value: "2dsphere"}}, Sparse: true},
+		{Name: "tenant_created", Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Name: "expires_at_1", Keys: bson.D{{Key: "expires_at", Value: 1}}, ExpireAfter: &ttl},
+		{Name: "status_hashed", Keys: bson.D{{Key: "status", Value: "hashed"}}, PartialFilter: bson.M{"status": bson.M{"$exists": true}}},
+	}, indexes)
+}
+
+func TestIndexesOf_InvalidTag(t *testing.T) {
+	_, err := odm.IndexesOf(&idxBroken{})
+	assert.EqualError(t, err, `index tag error on idxBroken.Name: unknown index kind "fulltext"`)
+}
+
+type idxMember struct {
+	Email     string    `bson:"email" index:"asc,unique"`
+	ExpiresAt time.Time `bson:"expires_at" index:"asc,ttl=3600"`
+}
+
+// indexListResponse 回傳 listIndexes 的模擬回應。
+// indexListResponse returns a mocked listIndexes reply.
+func indexListResponse(specs ...bson.D) bson.D {
+	return mtest.CreateCursorResponse(0, mockDB+".idxmembers", mtest.FirstBatch, specs...)
+}
+
+func TestEnsureIndexes_MatchingIndexesReportNothing(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		mt.AddMockResponses(indexListResponse(
+			bson.D{{Key: "v", Value: 2}, {Key: "key", Value: bson.D{{Key: "_id", Value: 1}}}, {Key: "name", Value: "_id_"}},
+			bson.D{{Key: "v", Value: 2}, {Key: "key", Value: bson.D{{Key: "email", Value: 1}}}, {Key: "name", Value: "email_1"}, {Key: "unique", Value: true}},
+			bson.D{{Key: "v", Value: 2}, {Key: "key", Value: bson.D{{Key: "expires_at", Value: 1}}}, {Key: "name", Value: "expires_at_1"}, {Key: "expireAfterSeconds", Value: int32(3600)}},
+		))
+
+		report, err := odm.EnsureIndexes(context.Background(), &idxMember{})
+		assert.NoError(t, err)
+		assert.Equal(t, &odm.IndexReport{}, report)
+
+		commands := sentCommands(mt)
+		assert.Len(t, commands, 1, "only listIndexes is sent")
+	})
+}
+
+func TestEnsureIndexes_ReportsDriftAndCreatesMissing(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		mt.AddMockResponses(
+			indexListResponse(
+				bson.D{{Key: "v", Value: 2}, {Key: "key", Value: bson.D{{Key: "email", Value: 1}}}, {Key: "name", Value: "email_1"}},
+			),
+			mtest.CreateSuccessResponse(),
+		)
+
+		report, err := odm.EnsureIndexes(context.Background(), &idxMember{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"idxmembers.expires_at_1"}, report.Created)
+		assert.Equal(t, []odm.IndexDrift{{Collection: "idxmembers", Name: "email_1", Existing: "email_1", Reason: "unique is false"}}, report.Drifted)
+
+		commands := sentCommands(mt)
+		if assert.Len(t, commands, 2) {
+			assert.Equal(t, "idxmembers", commands[1].Lookup("createIndexes").StringValue())
+			created, _ := commands[1].Lookup("indexes").Array().Values()
+			if assert.Len(t, created, 1) {
+				assert.Equal(t, "expires_at_1", created[0].Document().Lookup("name").StringValue())
+			}
+		}
+	})
+}
+
+func TestEnsureIndexes_ReportsTTLDrift(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		mt.AddMockResponses(indexListResponse(
+			bson.D{{Key: "v", Value: 2}, {Key: "key", Value: bson.D{{Key: "email", Value: 1}}}, {Key: "name", Value: "email_1"}, {Key: "unique", Value: true}},
+			bson.D{{Key: "v", Value: 2}, {Key: "key", Value: bson.D{{Key: "expires_at", Value: 1}}}, {Key: "name", Value: "expires_at_1"}, {Key: "expireAfterSeconds", Value: int32(60)}},
+		))
+
+		report, err := odm.EnsureIndexes(context.Background(), &idxMember{})
+		assert.NoError(t, err)
+		assert.Empty(t, report.Created)
+		assert.Equal(t, []odm.IndexDrift{{Collection: "idxmembers", Name: "expires_at_1", Existing: "expires_at_1", Reason: "ttl is 60s, declared 3600s"}}, report.Drifted)
+	})
+}
+
+func TestSyncIndexes_DropUndeclared(t *testing.T) {
+	existing := []bson.D{
+		{{Key: "v", Value: 2}, {Key: "key", Value: bson.D{{Key: "_id", Value: 1}}}, {Key: "name", Value: "_id_"}},
+		{{Key: "v", Value: 2}, {Key: "key", Value: bson.D{{Key: "email", Value: 1}}}, {Key: "name", Value: "email_1"}, {Key: "unique", Value: true}},
+		{{Key: "v", Value: 2}, {Key: "key", Value: bson.D{{Key: "expires_at", Value: 1}}}, {Key: "name", Value: "expires_at_1"}, {Key: "expireAfterSeconds", Value: int32(3600)}},
+		{{Key: "v", Value: 2}, {Key: "key", Value: bson.D{{Key: "nickname", Value: 1}}}, {Key: "name", Value: "nickname_1"}},
+		{{Key: "v", Value: 2}, {Key: "key", Value: bson.D{{Key: "age", Value: -1}}}, {Key: "name", Value: "age_-1"}},
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		withMockClient(t, func(mt *mtest.T) {
+			mt.AddMockResponses(indexListResponse(existing...))
+
+			report, err := odm.SyncIndexes(context.Background(), odm.IndexSyncOptions{DropUndeclared: true, DryRun: true}, &idxMember{})
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"idxmembers.age_-1", "idxmembers.nickname_1"}, report.Dropped)
+			assert.Empty(t, report.Drifted)
+			assert.Len(t, sentCommands(mt), 1, "a dry run drops nothing")
+		})
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		withMockClient(t, func(mt *mtest.T) {
+			mt.AddMockResponses(indexListResponse(existing...), mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+			report, err := odm.SyncIndexes(context.Background(), odm.IndexSyncOptions{DropUndeclared: true}, &idxMember{})
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"idxmembers.age_-1", "idxmembers.nickname_1"}, report.Dropped)
+
+			commands := sentCommands(mt)
+			if assert.Len(t, commands, 3) {
+				assert.Equal(t, "age_-1", commands[1].Lookup("index").StringValue())
+				assert.Equal(t, "nickname_1", commands[2].Lookup("index").StringValue())
+			}
+		})
+	})
+}
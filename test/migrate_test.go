@@ -0,0 +1,192 @@
+package test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"godm/pkg/migrate"
+)
+
+func noopMigration(ctx context.Context, db *mongo.Database) error { return nil }
+
+func TestMigrate_RegisteredInIDOrder(t *testing.T) {
+	migrate.Register(migrate.Migration{ID: "test_20250301_second", Up: noopMigration})
+	migrate.Register(migrate.Migration{ID: "test_20250101_first", Up: noopMigration, Down: noopMigration})
+
+	var ids []string
+	for _, m := range migrate.Registered() {
+		ids = append(ids, m.ID)
+	}
+	assert.Subset(t, ids, []string{"test_20250101_first", "test_20250301_second"})
+	assert.IsIncreasing(t, ids)
+}
+
+func TestMigrate_RegisterRejectsInvalid(t *testing.T) {
+	migrate.Register(migrate.Migration{ID: "test_20250401_once", Up: noopMigration})
+
+	assert.PanicsWithValue(t, "migrate: migration test_20250401_once registered twice", func() {
+		migrate.Register(migrate.Migration{ID: "test_20250401_once", Up: noopMigration})
+	})
+	assert.PanicsWithValue(t, `migrate: invalid migration ID ""`, func() {
+		migrate.Register(migrate.Migration{Up: noopMigration})
+	})
+	assert.PanicsWithValue(t, "migrate: migration test_20250402_no_up has no Up", func() {
+		migrate.Register(migrate.Migration{ID: "test_20250402_no_up"})
+	})
+}
+
+// migrationRecords 回傳 ids 的執行紀錄，套用時間依序遞增。
+// migrationRecords returns applied records for ids, with increasing applied times.
+func migrationRecords(start time.Time, ids ...string) []bson.D {
+	docs := make([]bson.D, len(ids))
+	for i, id := range ids {
+		docs[i] = bson.D{{Key: "_id", Value: id}, {Key: "applied_at", Value: start.Add(time.Duration(i) * time.Minute)}}
+	}
+	return docs
+}
+
+// registeredExcept 回傳 ids 以外所有已註冊遷移的 ID，讓測試只處理自己的遷移。
+// registeredExcept returns the IDs of every registered migration but ids, so a test only deals with its own.
+func registeredExcept(ids ...string) []string {
+	var others []string
+	for _, m := range migrate.Registered() {
+		if !slices.Contains(ids, m.ID) {
+			others = append(others, m.ID)
+		}
+	}
+	return others
+}
+
+var migrationLog eventLog
+
+func loggedMigration(name string) func(context.Context, *mongo.Database) error {
+	return func(context.Context, *mongo.Database) error {
+		migrationLog.add(name)
+		return nil
+	}
+}
+
+func init() {
+	migrate.Register(migrate.Migration{ID: "test_20250601_a", Up: loggedMigration("a:up"), Down: loggedMigration("a:down")})
+	migrate.Register(migrate.Migration{ID: "test_20250602_b", Up: loggedMigration("b:up"), Down: loggedMigration("b:down")})
+	// 直到 context 取消才結束 / runs until its context is cancelled
+	migrate.Register(migrate.Migration{ID: "test_20250701_slow", Up: func(ctx context.Context, db *mongo.Database) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	}})
+}
+
+func commandNames(commands []bson.Raw) []string {
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.Index(0).Key())
+	}
+	return names
+}
+
+func TestMigrate_Locked(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key"}))
+		applied, err := migrate.New(mt.DB).Up(context.Background())
+		assert.ErrorIs(t, err, migrate.ErrLocked)
+		assert.Empty(t, applied)
+		assert.Equal(t, []string{"update"}, commandNames(sentCommands(mt)))
+	})
+}
+
+func TestMigrate_UpAppliesPending(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		migrationLog.entries = nil
+		ns := mt.DB.Name() + "." + migrate.DefaultCollection
+		others := registeredExcept("test_20250601_a", "test_20250602_b")
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}), // lock
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, migrationRecords(time.Now(), others...)...),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}), // record a
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}), // record b
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}), // unlock
+		)
+		applied, err := migrate.New(mt.DB, &migrate.Options{Owner: "deploy-1"}).Up(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"test_20250601_a", "test_20250602_b"}, applied)
+		assert.Equal(t, []string{"a:up", "b:up"}, migrationLog.list())
+
+		commands := sentCommands(mt)
+		assert.Equal(t, []string{"update", "find", "insert", "insert", "update"}, commandNames(commands))
+		assert.Equal(t, "deploy-1", commands[0].Lookup("updates", "0", "u", "$set", "owner").StringValue())
+		assert.Equal(t, "test_20250601_a", commands[2].Lookup("documents", "0", "_id").StringValue())
+		assert.Equal(t, "deploy-1", commands[4].Lookup("updates", "0", "q", "owner").StringValue())
+	})
+}
+
+func TestMigrate_DownRevertsMostRecentlyApplied(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		migrationLog.entries = nil
+		ns := mt.DB.Name() + "." + migrate.DefaultCollection
+		// b 先套用、a 後套用（例如 a 晚一步合併）/ b was applied before a (a was merged later, say)
+		start := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+		records := append(migrationRecords(start.Add(-time.Hour), registeredExcept("test_20250601_a", "test_20250602_b")...),
+			migrationRecords(start, "test_20250602_b", "test_20250601_a")...)
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}), // lock
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, records...),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}), // delete record a
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}), // unlock
+		)
+		reverted, err := migrate.New(mt.DB).Down(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"test_20250601_a"}, reverted)
+		assert.Equal(t, []string{"a:down"}, migrationLog.list())
+
+		commands := sentCommands(mt)
+		assert.Equal(t, []string{"update", "find", "delete", "update"}, commandNames(commands))
+		assert.Equal(t, "test_20250601_a", commands[2].Lookup("deletes", "0", "q", "_id").StringValue())
+	})
+}
+
+func TestMigrate_Status(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		ns := mt.DB.Name() + "." + migrate.DefaultCollection
+		start := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+			migrationRecords(start, "test_20250601_a", "test_20250603_removed")...))
+
+		statuses, err := migrate.New(mt.DB).Status(context.Background())
+		assert.NoError(t, err)
+		byID := map[string]migrate.Status{}
+		for _, s := range statuses {
+			byID[s.ID] = s
+		}
+		assert.Equal(t, migrate.Status{ID: "test_20250601_a", Applied: true, AppliedAt: start}, byID["test_20250601_a"])
+		assert.Equal(t, migrate.Status{ID: "test_20250602_b"}, byID["test_20250602_b"])
+		assert.Equal(t, migrate.Status{ID: "test_20250603_removed", Applied: true, AppliedAt: start.Add(time.Minute), Missing: true},
+			byID["test_20250603_removed"])
+	})
+}
+
+func TestMigrate_LockLostCancelsMigration(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		ns := mt.DB.Name() + "." + migrate.DefaultCollection
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}), // lock
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, migrationRecords(time.Now(), registeredExcept("test_20250701_slow")...)...),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}), // renewal: the lock belongs to someone else now
+		)
+		_, err := migrate.New(mt.DB, &migrate.Options{LockTTL: 150 * time.Millisecond}).Up(context.Background())
+		assert.ErrorIs(t, err, migrate.ErrLockLost)
+		assert.ErrorIs(t, err, context.Canceled)
+		// 失去鎖定後不寫入紀錄也不解鎖 / no record and no unlock once the lock is lost
+		assert.Equal(t, []string{"update", "find", "update"}, commandNames(sentCommands(mt)))
+	})
+}
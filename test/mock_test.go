@@ -0,0 +1,110 @@
+package test
+
+import (
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"godm/pkg/odm"
+)
+
+const mockDB = "godm_test"
+
+// withMockClient 以驅動程式的模擬部署取代 odm.MongoClient 執行 fn，伺服器回應由 mt.AddMockResponses 依序提供，
+// 不需要實際的 MongoDB。
+// withMockClient runs fn with odm.MongoClient replaced by the driver's mock deployment; server replies are queued
+// with mt.AddMockResponses, so no MongoDB is needed.
+func withMockClient(t *testing.T, fn func(mt *mtest.T)) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("mock", func(mt *mtest.T) {
+		client, dbName := odm.MongoClient, odm.DBName
+		odm.MongoClient, odm.DBName = mt.Client, mockDB
+		defer func() { odm.MongoClient, odm.DBName = client, dbName }()
+		fn(mt)
+	})
+}
+
+// sentCommands 回傳模擬部署收到的命令（依送出順序），並清除已記錄的事件。
+// sentCommands returns the commands the mock deployment received, in order, and clears the recorded events.
+func sentCommands(mt *mtest.T) []bson.Raw {
+	var commands []bson.Raw
+	for e := mt.GetStartedEvent(); e != nil; e = mt.GetStartedEvent() {
+		commands = append(commands, e.Command)
+	}
+	return commands
+}
+
+// eventLog - 可同時寫入的事件紀錄
+// An event log that can be written concurrently
+type eventLog struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (l *eventLog) add(entry string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, entry)
+}
+
+func (l *eventLog) list() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.entries...)
+}
+
+// recorder - 將收到的事件以 "名稱:階段" 記錄到 eventLog 的觀察者，實作所有選用的觀察者介面
+// An observer logging every event it receives as "name:stage"; it implements every optional observer interface
+type recorder struct {
+	name     string
+	log      *eventLog
+	priority int
+	stages   map[string]bool        // 非空時只關心這些階段 / only these stages when set
+	accepts  func(interface{}) bool // 非 nil 時只接受回傳 true 的模型 / only models it returns true for when set
+	bulk     []*odm.BulkEvent       // 收到的批次事件 / bulk events received
+	bulkMu   sync.Mutex
+}
+
+func (r *recorder) record(stage string) error {
+	r.log.add(r.name + ":" + stage)
+	return nil
+}
+
+func (r *recorder) recordBulk(stage string, event *odm.BulkEvent) error {
+	r.bulkMu.Lock()
+	r.bulk = append(r.bulk, event)
+	r.bulkMu.Unlock()
+	return r.record(stage)
+}
+
+func (r *recorder) Creating(interface{}) error  { return r.record("creating") }
+func (r *recorder) Created(interface{}) error   { return r.record("created") }
+func (r *recorder) Updating(interface{}) error  { return r.record("updating") }
+func (r *recorder) Updated(interface{}) error   { return r.record("updated") }
+func (r *recorder) Deleting(interface{}) error  { return r.record("deleting") }
+func (r *recorder) Deleted(interface{}) error   { return r.record("deleted") }
+func (r *recorder) Saving(interface{}) error    { return r.record("saving") }
+func (r *recorder) Saved(interface{}) error     { return r.record("saved") }
+func (r *recorder) Upserting(interface{}) error { return r.record("upserting") }
+func (r *recorder) Upserted(interface{}) error  { return r.record("upserted") }
+func (r *recorder) Retrieved(interface{}) error { return r.record("retrieved") }
+
+func (r *recorder) BulkCreating(e *odm.BulkEvent) error { return r.recordBulk("bulkCreating", e) }
+func (r *recorder) BulkCreated(e *odm.BulkEvent) error  { return r.recordBulk("bulkCreated", e) }
+func (r *recorder) BulkUpdating(e *odm.BulkEvent) error { return r.recordBulk("bulkUpdating", e) }
+func (r *recorder) BulkUpdated(e *odm.BulkEvent) error  { return r.recordBulk("bulkUpdated", e) }
+func (r *recorder) BulkDeleting(e *odm.BulkEvent) error { return r.recordBulk("bulkDeleting", e) }
+func (r *recorder) BulkDeleted(e *odm.BulkEvent) error  { return r.recordBulk("bulkDeleted", e) }
+
+func (r *recorder) Priority() int        { return r.priority }
+func (r *recorder) ObserverName() string { return r.name }
+
+func (r *recorder) InterestedIn(stage string) bool {
+	return len(r.stages) == 0 || r.stages[stage]
+}
+
+func (r *recorder) Accepts(model interface{}) bool {
+	return r.accepts == nil || r.accepts(model)
+}
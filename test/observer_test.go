@@ -0,0 +1,372 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"godm/pkg/odm"
+)
+
+type obsAccount struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Name      string             `bson:"name"`
+	observers []odm.ModelObserver
+}
+
+func (a *obsAccount) Observers() []odm.ModelObserver {
+	return a.observers
+}
+
+func TestObserver_PriorityAndFilters(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		log := &eventLog{}
+		account := &obsAccount{Name: "a", observers: []odm.ModelObserver{&recorder{name: "model", log: log, priority: 5}}}
+		q := &odm.GODM{Observers: []odm.ModelObserver{
+			&recorder{name: "low", log: log, priority: 1},
+			&recorder{name: "high", log: log, priority: 10},
+			&recorder{name: "createdOnly", log: log, stages: map[string]bool{"created": true}},
+			&recorder{name: "postsOnly", log: log, priority: 20, accepts: func(m interface{}) bool {
+				_, ok := m.(*relPost)
+				return ok
+			}},
+		}}
+		q.Use(account)
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		assert.NoError(t, q.Create())
+		assert.False(t, account.ID.IsZero())
+		assert.Equal(t, []string{
+			"high:saving", "model:saving", "low:saving",
+			"high:creating", "model:creating", "low:creating",
+			"high:created", "model:created", "low:created", "createdOnly:created",
+			"high:saved", "model:saved", "low:saved",
+		}, log.list())
+	})
+}
+
+func TestObserver_UpsertAndRetrievedEvents(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		log := &eventLog{}
+		account := &obsAccount{}
+		q := (&odm.GODM{Observers: []odm.ModelObserver{&recorder{name: "r", log: log}}}).Use(account)
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+		assert.NoError(t, q.Where("name", "=", "a").Upsert(bson.M{"name": "a"}))
+		assert.Equal(t, []string{"r:saving", "r:upserting", "r:upserted", "r:saved"}, log.list())
+
+		log.entries = nil
+		id := primitive.NewObjectID()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mockDB+".obsaccounts", mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: id}, {Key: "name", Value: "a"}}))
+		assert.NoError(t, q.First())
+		assert.Equal(t, id, account.ID)
+		assert.Equal(t, []string{"r:retrieved"}, log.list())
+	})
+}
+
+type hookAccount struct {
+	ID    primitive.ObjectID `bson:"_id,omitempty"`
+	Name  string             `bson:"name"`
+	log   *eventLog
+	abort error
+}
+
+func (a *hookAccount) BeforeSave(ctx context.Context) error {
+	a.log.add("hook:saving")
+	return nil
+}
+
+func (a *hookAccount) BeforeCreate(ctx context.Context) error {
+	a.log.add("hook:creating")
+	return a.abort
+}
+
+func (a *hookAccount) AfterCreate(ctx context.Context) error {
+	a.log.add("hook:created")
+	return nil
+}
+
+func (a *hookAccount) AfterSave(ctx context.Context) error {
+	a.log.add("hook:saved")
+	return nil
+}
+
+func TestHooks_RunBeforeObservers(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		log := &eventLog{}
+		account := &hookAccount{Name: "a", log: log}
+		q := (&odm.GODM{Observers: []odm.ModelObserver{&recorder{name: "obs", log: log}}}).Use(account)
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		assert.NoError(t, q.Create())
+		assert.Equal(t, []string{
+			"hook:saving", "obs:saving", "hook:creating", "obs:creating",
+			"hook:created", "obs:created", "hook:saved", "obs:saved",
+		}, log.list())
+	})
+}
+
+func TestHooks_BeforeHookErrorAborts(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		log := &eventLog{}
+		account := &hookAccount{Name: "a", log: log, abort: errors.New("name taken")}
+		q := (&odm.GODM{Observers: []odm.ModelObserver{&recorder{name: "obs", log: log}}}).Use(account)
+
+		assert.EqualError(t, q.Create(), "creating hook error: name taken")
+		assert.Equal(t, []string{"hook:saving", "obs:saving", "hook:creating"}, log.list())
+		assert.Empty(t, sentCommands(mt))
+	})
+}
+
+type asyncRecorder struct{ *recorder }
+
+func (asyncRecorder) Async() bool { return true }
+
+type afterCommitRecorder struct{ *recorder }
+
+func (afterCommitRecorder) AfterCommit() bool { return true }
+
+// blockingRecorder - 在 release 關閉前阻塞 Created 的非同步觀察者
+// An async observer whose Created blocks until release is closed
+type blockingRecorder struct {
+	*recorder
+	release chan struct{}
+}
+
+func (blockingRecorder) Async() bool { return true }
+
+func (b blockingRecorder) Created(model interface{}) error {
+	<-b.release
+	return b.recorder.Created(model)
+}
+
+var createdOnly = map[string]bool{"created": true}
+
+func TestObserver_AsyncAndShutdown(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		odm.ConfigureAsyncObservers(2, 8)
+		defer odm.ConfigureAsyncObservers(4, 256)
+		var reported []error
+		odm.RegisterObserverErrorHandler(func(err error, stage string, model interface{}) {
+			reported = append(reported, err)
+		})
+		defer odm.RegisterObserverErrorHandler(nil)
+
+		log := &eventLog{}
+		q := (&odm.GODM{Observers: []odm.ModelObserver{
+			asyncRecorder{&recorder{name: "async", log: log, stages: createdOnly}},
+		}}).Use(&obsAccount{})
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		assert.NoError(t, q.Create())
+		assert.NoError(t, odm.ShutdownObservers(context.Background()))
+		assert.Equal(t, []string{"async:created"}, log.list())
+
+		// 關閉後的事件不再執行，也不會重新建立 pool / events after shutdown neither run nor reopen the pool
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		assert.NoError(t, q.Create())
+		assert.Equal(t, []string{"async:created"}, log.list())
+		assert.Len(t, reported, 1)
+		assert.ErrorIs(t, reported[0], odm.ErrObserverPoolClosed)
+	})
+}
+
+func TestObserver_ShutdownHonoursContext(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		odm.ConfigureAsyncObservers(1, 1)
+		defer odm.ConfigureAsyncObservers(4, 256)
+
+		log := &eventLog{}
+		release := make(chan struct{})
+		q := (&odm.GODM{Observers: []odm.ModelObserver{
+			blockingRecorder{recorder: &recorder{name: "slow", log: log, stages: createdOnly}, release: release},
+		}}).Use(&obsAccount{})
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		assert.NoError(t, q.Create())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.ErrorIs(t, odm.ShutdownObservers(ctx), context.Canceled)
+		close(release)
+	})
+}
+
+func TestObserver_ConfigureDoesNotBlockNewEvents(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		odm.ConfigureAsyncObservers(1, 1)
+		defer odm.ConfigureAsyncObservers(4, 256)
+
+		log := &eventLog{}
+		release := make(chan struct{})
+		slow := (&odm.GODM{Observers: []odm.ModelObserver{
+			blockingRecorder{recorder: &recorder{name: "slow", log: log, stages: createdOnly}, release: release},
+		}}).Use(&obsAccount{})
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		assert.NoError(t, slow.Create())
+
+		// 重新設定會等待舊 pool 的事件，但不應擋住新事件 / reconfiguring waits for the old pool without holding up new events
+		configured := make(chan struct{})
+		go func() {
+			odm.ConfigureAsyncObservers(1, 1)
+			close(configured)
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		fast := (&odm.GODM{Observers: []odm.ModelObserver{
+			asyncRecorder{&recorder{name: "fast", log: log, stages: createdOnly}},
+		}}).Use(&obsAccount{})
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		created := make(chan error, 1)
+		go func() { created <- fast.Create() }()
+		select {
+		case err := <-created:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			close(release)
+			t.Fatal("Create blocked while the old observer pool drained")
+		}
+		assert.Eventually(t, func() bool { return len(log.list()) == 1 }, 5*time.Second, 5*time.Millisecond)
+		assert.Equal(t, []string{"fast:created"}, log.list())
+
+		close(release)
+		<-configured
+		assert.Equal(t, []string{"fast:created", "slow:created"}, log.list())
+	})
+}
+
+func TestObserver_AfterCommitWaitsForCommit(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		log := &eventLog{}
+		observers := []odm.ModelObserver{
+			&recorder{name: "sync", log: log, stages: createdOnly},
+			afterCommitRecorder{&recorder{name: "commit", log: log, stages: createdOnly}},
+		}
+		create := func(tx *odm.Tx) error {
+			q := tx.Model(&obsAccount{})
+			q.Observers = observers
+			return q.Create()
+		}
+
+		// insert, commitTransaction
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+		err := odm.Transaction(context.Background(), func(tx *odm.Tx) error {
+			if err := create(tx); err != nil {
+				return err
+			}
+			assert.Equal(t, []string{"sync:created"}, log.list())
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"sync:created", "commit:created"}, log.list())
+
+		// insert, abortTransaction：中止時捨棄 / discarded on abort
+		log.entries = nil
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+		err = odm.Transaction(context.Background(), func(tx *odm.Tx) error {
+			if err := create(tx); err != nil {
+				return err
+			}
+			return errors.New("out of stock")
+		})
+		assert.EqualError(t, err, "transaction error: out of stock")
+		assert.Equal(t, []string{"sync:created"}, log.list())
+	})
+}
+
+func TestObserver_BulkEventModes(t *testing.T) {
+	cases := []struct {
+		mode     odm.BulkEventMode
+		expected []string
+	}{
+		{odm.BulkEventsBatch, []string{"r:bulkCreating", "r:bulkCreated"}},
+		{odm.BulkEventsPerModel, []string{"r:bulkCreating", "r:creating", "r:creating", "r:created", "r:created", "r:bulkCreated"}},
+		{odm.BulkEventsNone, nil},
+	}
+	for _, c := range cases {
+		withMockClient(t, func(mt *mtest.T) {
+			log := &eventLog{}
+			r := &recorder{name: "r", log: log}
+			q := (&odm.GODM{Observers: []odm.ModelObserver{r}}).Use(&obsAccount{}).SetBulkEventMode(c.mode)
+
+			mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}))
+			err := q.BulkCreate([]interface{}{&obsAccount{Name: "a"}, &obsAccount{Name: "b"}})
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, log.list())
+			if c.mode != odm.BulkEventsNone {
+				assert.Len(t, r.bulk[1].Models, 2)
+				assert.Equal(t, int64(2), r.bulk[1].Affected)
+			}
+		})
+	}
+}
+
+func TestObserver_BulkCreatePartialFailure(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		log := &eventLog{}
+		r := &recorder{name: "r", log: log}
+		q := (&odm.GODM{Observers: []odm.ModelObserver{r}}).Use(&obsAccount{}).SetBulkEventMode(odm.BulkEventsPerModel)
+
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 1, Code: 11000, Message: "duplicate key"}))
+		accounts := []*obsAccount{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+		ids, err := q.BulkCreateWithIDs([]interface{}{accounts[0], accounts[1], accounts[2]}, &odm.BulkOptions{Unordered: true})
+		assert.Equal(t, []interface{}{accounts[0].ID, nil, accounts[2].ID}, ids)
+		assert.False(t, accounts[2].ID.IsZero())
+
+		var createErr *odm.BulkCreateError
+		assert.True(t, errors.As(err, &createErr))
+		assert.Equal(t, []int{1}, createErr.FailedIndexes())
+		assert.Equal(t, []string{
+			"r:bulkCreating", "r:creating", "r:creating", "r:creating", "r:created", "r:created", "r:bulkCreated",
+		}, log.list())
+		assert.Equal(t, int64(2), r.bulk[1].Affected)
+	})
+}
+
+func TestObserver_BulkUpdateAndDeleteEvents(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		log := &eventLog{}
+		r := &recorder{name: "r", log: log}
+		q := (&odm.GODM{Observers: []odm.ModelObserver{r}}).Use(&obsAccount{})
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 3}, bson.E{Key: "nModified", Value: 2}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 4}),
+		)
+		assert.NoError(t, q.Where("name", "=", "a").UpdateMany(bson.M{"name": "b"}))
+		assert.NoError(t, q.DeleteMany())
+
+		assert.Equal(t, []string{"r:bulkUpdating", "r:bulkUpdated", "r:bulkDeleting", "r:bulkDeleted"}, log.list())
+		filter := bson.D{{Key: "name", Value: "a"}}
+		assert.Equal(t, &odm.BulkEvent{Filter: filter, Update: bson.M{"name": "b"}, Affected: 2}, r.bulk[1])
+		assert.Equal(t, &odm.BulkEvent{Filter: filter, Affected: 4}, r.bulk[3])
+	})
+}
+
+func TestObserver_WithoutEventsReturnsCopy(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		log := &eventLog{}
+		q := (&odm.GODM{Observers: []odm.ModelObserver{
+			&recorder{name: "audit", log: log, stages: createdOnly},
+			&recorder{name: "mail", log: log, stages: createdOnly},
+		}}).Use(&obsAccount{})
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+		assert.NoError(t, q.WithoutEvents().Create())
+		assert.Empty(t, log.list())
+
+		assert.NoError(t, q.WithoutEvents("mail").Create())
+		assert.Equal(t, []string{"audit:created"}, log.list())
+
+		// 原查詢不受影響 / the original builder is not silenced
+		assert.NoError(t, q.Create())
+		assert.Equal(t, []string{"audit:created", "audit:created", "mail:created"}, log.list())
+	})
+}
@@ -1,10 +1,15 @@
 package test
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
 
 	"godm/pkg/odm"
 )
@@ -40,3 +45,151 @@ func TestGODM_ToBson_WhereAndOr(t *testing.T) {
 	}}
 	assert.Equal(t, expected, q.ToBson())
 }
+
+func TestGODM_Bulk_QueuesBuilderFilters(t *testing.T) {
+	b := (&odm.GODM{}).Bulk().
+		UpdateOne(func(q *odm.GODM) { q.Where("email", "=", "a@example.com") }, bson.M{"name": "A"}).
+		Upsert(func(q *odm.GODM) { q.Where("age", ">", 18).OrWhere("vip", "=", true) }, bson.M{"adult": true}).
+		DeleteMany(nil)
+
+	pending := b.Pending()
+	assert.Len(t, pending, 3)
+
+	update := pending[0].(*mongo.UpdateOneModel)
+	assert.Equal(t, bson.D{{Key: "email", Value: "a@example.com"}}, update.Filter)
+	assert.Equal(t, bson.M{"$set": bson.M{"name": "A"}}, update.Update)
+
+	upsert := pending[1].(*mongo.UpdateOneModel)
+	assert.True(t, *upsert.Upsert)
+	assert.Equal(t, bson.D{{Key: "$and", Value: []bson.M{
+		{"age": bson.M{"$gt": 18}},
+		{"$or": []bson.M{{"vip": true}}},
+	}}}, upsert.Filter)
+
+	assert.Equal(t, bson.D{}, pending[2].(*mongo.DeleteManyModel).Filter)
+}
+
+func TestGODM_Bulk_FiltersOnTheModel(t *testing.T) {
+	id := primitive.NewObjectID()
+	b := (&odm.GODM{Model: &relTag{}}).Bulk().DeleteOne(func(q *odm.GODM) { q.WhereID(id.Hex()) })
+	assert.Equal(t, bson.D{{Key: "_id", Value: id}}, b.Pending()[0].(*mongo.DeleteOneModel).Filter)
+
+	// 無效的 ID / an invalid ID
+	_, err := (&odm.GODM{Model: &relTag{}}).Bulk().
+		UpdateOne(func(q *odm.GODM) { q.WhereID("not-an-id") }, bson.M{"name": "x"}).
+		Execute()
+	assert.ErrorContains(t, err, "bulk write error: WhereID error:")
+
+	// 關聯條件需要聚合 / relation conditions need an aggregation
+	b = (&odm.GODM{Model: &relUser{}}).Bulk().
+		DeleteMany(func(q *odm.GODM) { q.Has("posts", ">", 0) }).
+		DeleteMany(nil)
+	_, err = b.Execute()
+	assert.EqualError(t, err, "bulk write error: relation conditions cannot filter bulk writes")
+	assert.Empty(t, b.Pending())
+}
+
+func TestGODM_Bulk_WhereIDWithoutModel(t *testing.T) {
+	_, err := (&odm.GODM{}).Bulk().DeleteOne(func(q *odm.GODM) { q.WhereID(primitive.NewObjectID()) }).Execute()
+	assert.EqualError(t, err, "bulk write error: WhereID error: model is not set")
+}
+
+func TestGODM_Bulk_OrderedReportsSkippedOperations(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key"}))
+		b := (&odm.GODM{}).Use(&relTag{}).Bulk(&odm.BulkOptions{BatchSize: 2})
+		for i := 0; i < 5; i++ {
+			b.InsertOne(&relTag{Name: fmt.Sprint(i)})
+		}
+		_, err := b.Execute()
+
+		var bulkErr *odm.BulkError
+		if assert.True(t, errors.As(err, &bulkErr)) {
+			var indexes []int
+			for _, f := range bulkErr.Failures {
+				indexes = append(indexes, f.Index)
+			}
+			assert.Equal(t, []int{0, 1, 2, 3, 4}, indexes)
+			assert.Equal(t, 11000, bulkErr.Failures[0].Code)
+		}
+		assert.ErrorIs(t, err, odm.ErrWriteSkipped)
+		assert.Len(t, sentCommands(mt), 1, "nothing is sent after the failed batch")
+	})
+}
+
+func TestGODM_Bulk_SurfacesWriteConcernErrorWithWriteErrors(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{
+			{Key: "ok", Value: 1},
+			{Key: "n", Value: 1},
+			{Key: "writeErrors", Value: bson.A{bson.D{{Key: "index", Value: 1}, {Key: "code", Value: 11000}, {Key: "errmsg", Value: "duplicate key"}}}},
+			{Key: "writeConcernError", Value: bson.D{{Key: "code", Value: 64}, {Key: "errmsg", Value: "waiting for replication timed out"}}},
+		})
+		_, err := (&odm.GODM{}).Use(&relTag{}).Bulk(&odm.BulkOptions{Unordered: true}).
+			InsertOne(&relTag{Name: "a"}).
+			InsertOne(&relTag{Name: "b"}).
+			Execute()
+
+		var bulkErr *odm.BulkError
+		assert.True(t, errors.As(err, &bulkErr))
+		var wce *mongo.WriteConcernError
+		if assert.True(t, errors.As(err, &wce)) {
+			assert.Equal(t, 64, wce.Code)
+		}
+	})
+}
+
+func TestGODM_CreateWritesBackIDs(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		tag := &relTag{Name: "go"}
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		assert.NoError(t, (&odm.GODM{}).Use(tag).Create())
+		assert.False(t, tag.ID.IsZero())
+		sent := sentCommands(mt)[0].Lookup("documents", "0", "_id").ObjectID()
+		assert.Equal(t, sent, tag.ID)
+
+		// BulkCreate 不回傳 ID，但同樣寫回模型 / BulkCreate returns no IDs but writes them back all the same
+		pair := []*relTag{{Name: "x"}, {Name: "y"}}
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}))
+		assert.NoError(t, (&odm.GODM{}).Use(&relTag{}).BulkCreate([]interface{}{pair[0], pair[1]}))
+		assert.Equal(t, sentCommands(mt)[0].Lookup("documents", "1", "_id").ObjectID(), pair[1].ID)
+		assert.False(t, pair[0].ID.IsZero())
+
+		// 有序模式下失敗的文檔與其後的文檔沒有 ID / when ordered, the failed document and the ones after it get no ID
+		tags := []*relTag{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 1, Code: 11000, Message: "duplicate key"}))
+		ids, err := (&odm.GODM{}).Use(&relTag{}).BulkCreateWithIDs([]interface{}{tags[0], tags[1], tags[2]})
+
+		var createErr *odm.BulkCreateError
+		assert.True(t, errors.As(err, &createErr))
+		assert.Equal(t, 1, createErr.Inserted)
+		assert.Equal(t, sentCommands(mt)[0].Lookup("documents", "0", "_id").ObjectID(), ids[0])
+		assert.Equal(t, []interface{}{tags[0].ID, nil, nil}, ids)
+		assert.True(t, tags[2].ID.IsZero())
+	})
+}
+
+func TestBulkError(t *testing.T) {
+	err := &odm.BulkError{Failures: []odm.BulkOperationError{{Index: 1203, Code: 11000, Message: "duplicate key"}}}
+
+	assert.EqualError(t, err, "bulk write: 1 operation(s) failed: operation 1203: duplicate key (code 11000)")
+	var opErr odm.BulkOperationError
+	assert.True(t, errors.As(err, &opErr))
+	assert.Equal(t, 1203, opErr.Index)
+}
+
+func TestBulkCreateError(t *testing.T) {
+	err := fmt.Errorf("import users: %w", &odm.BulkCreateError{
+		Inserted: 2,
+		Failures: []odm.BulkOperationError{
+			{Index: 1, Code: 11000, Message: "duplicate key"},
+			{Index: 3, Code: 11000, Message: "duplicate key"},
+		},
+	})
+
+	var createErr *odm.BulkCreateError
+	assert.True(t, errors.As(err, &createErr))
+	assert.Equal(t, []int{1, 3}, createErr.FailedIndexes())
+	assert.EqualError(t, createErr, "bulk create error: 2 inserted, bulk write: 2 operation(s) failed: "+
+		"operation 1: duplicate key (code 11000); operation 3: duplicate key (code 11000)")
+}
@@ -0,0 +1,969 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"godm/pkg/odm"
+)
+
+type relUser struct {
+	ID    primitive.ObjectID `bson:"_id,omitempty"`
+	Name  string             `bson:"name"`
+	Posts []relPost          `bson:"posts,omitempty" odm:"hasMany,foreignKey=user_id"`
+	Roles []relRole          `bson:"roles,omitempty" odm:"belongsToMany,from=roles,pivot=role_user,pivotLocalKey=user_id,pivotForeignKey=role_id,pivotFields=granted_at"`
+}
+
+type relTag struct {
+	ID   primitive.ObjectID `bson:"_id,omitempty"`
+	Name string             `bson:"name"`
+}
+
+type relRole struct {
+	ID   primitive.ObjectID `bson:"_id,omitempty"`
+	Name string             `bson:"name"`
+}
+
+type relPost struct {
+	ID       primitive.ObjectID   `bson:"_id,omitempty"`
+	UserID   primitive.ObjectID   `bson:"user_id"`
+	Title    string               `bson:"title"`
+	TagIDs   []primitive.ObjectID `bson:"tag_ids"`
+	Tags     []relTag             `bson:"tags,omitempty" odm:"refMany,from=tags,dropDangling"`
+	User     *relUser             `bson:"user,omitempty" odm:"belongsTo,localKey=user_id,from=users"`
+	Comments []relComment         `bson:"comments,omitempty" odm:"hasMany,foreignKey=post_id,from=comments"`
+}
+
+type relComment struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	PostID   primitive.ObjectID `bson:"post_id"`
+	AuthorID primitive.ObjectID `bson:"author_id"`
+	Author   *relUser           `bson:"author,omitempty" odm:"belongsTo,from=users"`
+}
+
+func TestGODM_ToPipeline_HasManyTag(t *testing.T) {
+	q := &odm.GODM{Model: &relUser{}}
+	q.With("posts")
+	expected := []bson.M{
+		{"$match": bson.D{}},
+		{"$lookup": bson.M{
+			"from":         "relposts",
+			"localField":   "_id",
+			"foreignField": "user_id",
+			"as":           "posts",
+		}},
+	}
+	assert.Equal(t, expected, q.ToPipeline())
+}
+
+func TestGODM_ToPipeline_BelongsToTag(t *testing.T) {
+	q := &odm.GODM{Model: &relPost{}}
+	q.With("user").Limit(5)
+	expected := []bson.M{
+		{"$match": bson.D{}},
+		{"$lookup": bson.M{
+			"from":         "users",
+			"localField":   "user_id",
+			"foreignField": "_id",
+			"as":           "user",
+		}},
+		{"$unwind": bson.M{
+			"path":                       "$user",
+			"preserveNullAndEmptyArrays": true,
+		}},
+		{"$limit": int64(5)},
+	}
+	assert.Equal(t, expected, q.ToPipeline())
+}
+
+func TestGODM_ToPipeline_ExplicitConfigOverridesTag(t *testing.T) {
+	q := &odm.GODM{Model: &relUser{}}
+	q.SetRelationConfig(map[string]odm.RelationConfig{
+		"posts": {From: "articles", LocalField: "_id", ForeignField: "author_id", As: "posts", IsArray: true},
+	}).With("posts")
+	expected := []bson.M{
+		{"$match": bson.D{}},
+		{"$lookup": bson.M{
+			"from":         "articles",
+			"localField":   "_id",
+			"foreignField": "author_id",
+			"as":           "posts",
+		}},
+	}
+	assert.Equal(t, expected, q.ToPipeline())
+}
+
+func TestGODM_ToPipeline_NestedRelations(t *testing.T) {
+	q := &odm.GODM{Model: &relUser{}}
+	q.With("posts.comments.author")
+	expected := []bson.M{
+		{"$match": bson.D{}},
+		{"$lookup": bson.M{
+			"from": "relposts",
+			"let":  bson.M{"local": "$_id"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$user_id", "$$local"}}}},
+				{"$lookup": bson.M{
+					"from": "comments",
+					"let":  bson.M{"local": "$_id"},
+					"pipeline": []bson.M{
+						{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$post_id", "$$local"}}}},
+						{"$lookup": bson.M{
+							"from":         "users",
+							"localField":   "author_id",
+							"foreignField": "_id",
+							"as":           "author",
+						}},
+						{"$unwind": bson.M{
+							"path":                       "$author",
+							"preserveNullAndEmptyArrays": true,
+						}},
+					},
+					"as": "comments",
+				}},
+			},
+			"as": "posts",
+		}},
+	}
+	assert.Equal(t, expected, q.ToPipeline())
+}
+
+func TestGODM_ToPipeline_ConstrainedRelation(t *testing.T) {
+	q := &odm.GODM{Model: &relUser{}}
+	q.WithFn("posts", func(q *odm.GODM) {
+		q.Where("published", "=", true).OrderBy("created_at", false).Limit(5).Select("title")
+	})
+	expected := []bson.M{
+		{"$match": bson.D{}},
+		{"$lookup": bson.M{
+			"from": "relposts",
+			"let":  bson.M{"local": "$_id"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$user_id", "$$local"}}}},
+				{"$match": bson.D{{Key: "published", Value: true}}},
+				{"$sort": bson.D{{Key: "created_at", Value: -1}}},
+				{"$limit": int64(5)},
+				{"$project": bson.M{"title": 1}},
+			},
+			"as": "posts",
+		}},
+	}
+	assert.Equal(t, expected, q.ToPipeline())
+}
+
+func TestGODM_ToPipeline_BelongsToMany(t *testing.T) {
+	q := &odm.GODM{Model: &relUser{}}
+	q.With("roles")
+	expected := []bson.M{
+		{"$match": bson.D{}},
+		{"$lookup": bson.M{
+			"from": "role_user",
+			"let":  bson.M{"local": "$_id"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$user_id", "$$local"}}}},
+				{"$lookup": bson.M{
+					"from": "roles",
+					"let":  bson.M{"related": "$role_id"},
+					"pipeline": []bson.M{
+						{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$_id", "$$related"}}}},
+					},
+					"as": "__related",
+				}},
+				{"$unwind": "$__related"},
+				{"$replaceRoot": bson.M{"newRoot": bson.M{"$mergeObjects": bson.A{
+					"$__related",
+					bson.M{"pivot": bson.M{"granted_at": "$granted_at"}},
+				}}}},
+			},
+			"as": "roles",
+		}},
+	}
+	assert.Equal(t, expected, q.ToPipeline())
+}
+
+func TestGODM_ToPipeline_RefMany(t *testing.T) {
+	q := &odm.GODM{Model: &relPost{}}
+	q.With("tags")
+	expected := []bson.M{
+		{"$match": bson.D{}},
+		{"$lookup": bson.M{
+			"from":         "tags",
+			"localField":   "tag_ids",
+			"foreignField": "_id",
+			"as":           "tags",
+		}},
+		{"$addFields": bson.M{"tags": bson.M{"$filter": bson.M{
+			"input": bson.M{"$map": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$tag_ids", bson.A{}}},
+				"as":    "ref",
+				"in": bson.M{"$let": bson.M{
+					"vars": bson.M{"idx": bson.M{"$indexOfArray": bson.A{"$tags._id", "$$ref"}}},
+					"in":   bson.M{"$cond": bson.A{bson.M{"$gte": bson.A{"$$idx", 0}}, bson.M{"$arrayElemAt": bson.A{"$tags", "$$idx"}}, nil}},
+				}},
+			}},
+			"cond": bson.M{"$ne": bson.A{"$$this", nil}},
+		}}}},
+	}
+	assert.Equal(t, expected, q.ToPipeline())
+}
+
+func TestGODM_ToPipeline_RefManySortedConstraint(t *testing.T) {
+	q := &odm.GODM{Model: &relPost{}}
+	q.WithFn("tags", func(q *odm.GODM) { q.OrderBy("name", true) })
+	expected := []bson.M{
+		{"$match": bson.D{}},
+		{"$lookup": bson.M{
+			"from": "tags",
+			"let":  bson.M{"local": "$tag_ids"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$in": bson.A{"$_id", bson.M{"$ifNull": bson.A{"$$local", bson.A{}}}}}}},
+				{"$sort": bson.D{{Key: "name", Value: 1}}},
+			},
+			"as": "tags",
+		}},
+	}
+	assert.Equal(t, expected, q.ToPipeline())
+}
+
+type relVideo struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	Comments []relMorphComment  `bson:"comments,omitempty" odm:"morphMany,morphName=commentable,from=comments"`
+}
+
+type relMorphComment struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty"`
+	CommentableType string             `bson:"commentable_type"`
+	CommentableID   primitive.ObjectID `bson:"commentable_id"`
+	Commentable     interface{}        `bson:"commentable,omitempty" odm:"morphTo"`
+}
+
+func TestGODM_ToPipeline_MorphRelations(t *testing.T) {
+	// 註冊此檔案使用的所有類型，結果才不受測試順序影響 / register every type this file uses so the order of tests does not matter
+	odm.RegisterMorph("video", &relVideo{})
+	odm.RegisterMorph("article", &relArticle{})
+
+	q := &odm.GODM{Model: &relVideo{}}
+	q.With("comments")
+	expected := []bson.M{
+		{"$match": bson.D{}},
+		{"$lookup": bson.M{
+			"from": "comments",
+			"let":  bson.M{"local": "$_id"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$commentable_id", "$$local"}},
+					bson.M{"$eq": bson.A{"$commentable_type", "video"}},
+				}}}},
+			},
+			"as": "comments",
+		}},
+	}
+	assert.Equal(t, expected, q.ToPipeline())
+
+	q = &odm.GODM{Model: &relMorphComment{}}
+	q.With("commentable")
+	morphLookup := func(alias, from string) bson.M {
+		return bson.M{"$lookup": bson.M{
+			"from": from,
+			"let":  bson.M{"id": "$commentable_id", "type": "$commentable_type"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$$type", alias}},
+					bson.M{"$eq": bson.A{"$_id", "$$id"}},
+				}}}},
+			},
+			"as": "__morph_" + alias,
+		}}
+	}
+	expected = []bson.M{
+		{"$match": bson.D{}},
+		morphLookup("article", "relarticles"),
+		morphLookup("video", "relvideos"),
+		{"$addFields": bson.M{"commentable": bson.M{"$arrayElemAt": bson.A{bson.M{"$concatArrays": bson.A{"$__morph_article", "$__morph_video"}}, 0}}}},
+		{"$project": bson.M{"__morph_article": 0, "__morph_video": 0}},
+	}
+	assert.Equal(t, expected, q.ToPipeline())
+}
+
+type relArticle struct {
+	ID    primitive.ObjectID `bson:"_id,omitempty"`
+	Title string             `bson:"title"`
+}
+
+func TestLoadMany_MorphToGroupsByType(t *testing.T) {
+	odm.RegisterMorph("video", &relVideo{})
+	odm.RegisterMorph("article", &relArticle{})
+
+	withMockClient(t, func(mt *mtest.T) {
+		video, article, missing := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+		comments := []relMorphComment{
+			{CommentableType: "video", CommentableID: video},
+			{CommentableType: "article", CommentableID: article},
+			{CommentableType: "video", CommentableID: missing},
+		}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, mockDB+".relarticles", mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: article}, {Key: "title", Value: "news"}}),
+			mtest.CreateCursorResponse(0, mockDB+".relvideos", mtest.FirstBatch, bson.D{{Key: "_id", Value: video}}),
+		)
+		assert.NoError(t, odm.LoadMany(comments, "commentable"))
+
+		assert.Equal(t, &relVideo{ID: video}, comments[0].Commentable)
+		assert.Equal(t, &relArticle{ID: article, Title: "news"}, comments[1].Commentable)
+		assert.Nil(t, comments[2].Commentable)
+
+		// 每個類型一次查詢 / one query per type
+		commands := sentCommands(mt)
+		if assert.Len(t, commands, 2) {
+			assert.Equal(t, "relarticles", commands[0].Lookup("find").StringValue())
+			assert.Equal(t, "relvideos", commands[1].Lookup("find").StringValue())
+			ids, _ := commands[1].Lookup("filter", "_id", "$in").Array().Values()
+			assert.Len(t, ids, 2)
+		}
+	})
+
+	withMockClient(t, func(mt *mtest.T) {
+		comments := []relMorphComment{{CommentableType: "podcast", CommentableID: primitive.NewObjectID()}}
+		assert.EqualError(t, odm.LoadMany(comments, "commentable"), `morph type "podcast" is not registered`)
+		assert.Empty(t, sentCommands(mt))
+	})
+}
+
+func TestGODM_MorphToResolvesLookupResults(t *testing.T) {
+	odm.RegisterMorph("article", &relArticle{})
+
+	withMockClient(t, func(mt *mtest.T) {
+		article := primitive.NewObjectID()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mockDB+".relmorphcomments", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "commentable_type", Value: "article"},
+			{Key: "commentable_id", Value: article},
+			{Key: "commentable", Value: bson.D{{Key: "_id", Value: article}, {Key: "title", Value: "news"}}},
+		}))
+		comment := &relMorphComment{}
+		assert.NoError(t, (&odm.GODM{}).Use(comment).With("commentable").First())
+		assert.Equal(t, &relArticle{ID: article, Title: "news"}, comment.Commentable)
+	})
+
+	withMockClient(t, func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mockDB+".relmorphcomments", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "commentable_type", Value: "podcast"},
+			{Key: "commentable", Value: bson.D{{Key: "_id", Value: primitive.NewObjectID()}}},
+		}))
+		err := (&odm.GODM{}).Use(&relMorphComment{}).With("commentable").First()
+		assert.EqualError(t, err, `morph type "podcast" is not registered`)
+	})
+}
+
+func TestGODM_ToPipeline_RelationExistence(t *testing.T) {
+	q := &odm.GODM{Model: &relUser{}}
+	q.WhereHas("posts", func(p *odm.GODM) {
+		p.Where("published", "=", true)
+	}).Has("posts", ">=", 3).OrderBy("name", true)
+	expected := []bson.M{
+		{"$match": bson.D{}},
+		{"$lookup": bson.M{
+			"from": "relposts",
+			"let":  bson.M{"local": "$_id"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$user_id", "$$local"}}}},
+				{"$match": bson.D{{Key: "published", Value: true}}},
+				{"$project": bson.M{"_id": 1, "user_id": 1}},
+			},
+			"as": "__rel_0",
+		}},
+		{"$match": bson.M{"$expr": bson.M{"$gte": bson.A{bson.M{"$size": "$__rel_0"}, 1}}}},
+		{"$lookup": bson.M{
+			"from": "relposts",
+			"let":  bson.M{"local": "$_id"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$user_id", "$$local"}}}},
+				{"$project": bson.M{"_id": 1, "user_id": 1}},
+			},
+			"as": "__rel_1",
+		}},
+		{"$match": bson.M{"$expr": bson.M{"$gte": bson.A{bson.M{"$size": "$__rel_1"}, 3}}}},
+		{"$project": bson.M{"__rel_0": 0, "__rel_1": 0}},
+		{"$sort": bson.D{{Key: "name", Value: 1}}},
+	}
+	assert.Equal(t, expected, q.ToPipeline())
+}
+
+func TestGODM_ToPipeline_RelationAggregates(t *testing.T) {
+	q := &odm.GODM{Model: &relUser{}}
+	q.DoesntHave("roles").WithCount("posts").WithMax("posts", "views")
+	pipeline := q.ToPipeline()
+
+	assert.Len(t, pipeline, 8)
+	assert.Equal(t, "role_user", pipeline[1]["$lookup"].(bson.M)["from"])
+	assert.Equal(t, bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{bson.M{"$size": "$__rel_0"}, 0}}}}, pipeline[2])
+	assert.Equal(t, bson.M{"$addFields": bson.M{"posts_count": bson.M{"$size": "$__rel_1"}}}, pipeline[4])
+	assert.Equal(t, bson.M{"$addFields": bson.M{"posts_max_views": bson.M{"$max": "$__rel_2.views"}}}, pipeline[6])
+	assert.Equal(t, bson.M{"$project": bson.M{"__rel_0": 0, "__rel_1": 0, "__rel_2": 0}}, pipeline[7])
+}
+
+type relOrder struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty"`
+	Events []relEvent         `bson:"events,omitempty" odm:"hasMany,from=events,foreignKey=order_id,strategy=preload,db=analytics"`
+	Posts  []relPost          `bson:"posts,omitempty" odm:"hasMany,foreignKey=order_id"`
+}
+
+type relEvent struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty"`
+	OrderID primitive.ObjectID `bson:"order_id"`
+}
+
+func TestGODM_ToPipeline_PreloadStrategySkipsLookup(t *testing.T) {
+	q := &odm.GODM{Model: &relOrder{}}
+	q.With("events", "posts")
+	expected := []bson.M{
+		{"$match": bson.D{}},
+		{"$lookup": bson.M{
+			"from":         "relposts",
+			"localField":   "_id",
+			"foreignField": "order_id",
+			"as":           "posts",
+		}},
+	}
+	assert.Equal(t, expected, q.ToPipeline())
+}
+
+func TestGODM_LoadWithoutModels(t *testing.T) {
+	assert.Error(t, (&odm.GODM{}).Load("posts"))
+	assert.NoError(t, odm.LoadMany([]relUser{}, "posts"))
+
+	_, err := (&odm.GODM{Model: &relUser{}}).Related("missing")
+	assert.EqualError(t, err, `relation "missing" is not defined`)
+}
+
+func TestRelationBuilder_WithoutDatabase(t *testing.T) {
+	user := &relUser{}
+	err := (&odm.GODM{Model: user}).Relation("posts").Create(&relPost{})
+	assert.EqualError(t, err, "model *test.relUser has no _id yet, create it first")
+
+	err = (&odm.GODM{Model: user}).Relation("missing").Create(&relPost{})
+	assert.EqualError(t, err, `relation "missing" is not defined`)
+
+	err = (&odm.GODM{Model: user}).Relation("posts").Associate(&relPost{})
+	assert.EqualError(t, err, `relation "posts" is a hasMany relation, Associate needs belongsTo or morphTo`)
+
+	// 尚未建立的模型只在記憶體中設定外鍵 / unsaved models only get the foreign key set in memory
+	owner := &relUser{ID: primitive.NewObjectID(), Name: "owner"}
+	post := &relPost{}
+	assert.NoError(t, (&odm.GODM{Model: post}).Relation("user").Associate(owner))
+	assert.Equal(t, owner.ID, post.UserID)
+	assert.Same(t, owner, post.User)
+
+	assert.NoError(t, (&odm.GODM{Model: post}).Relation("user").Dissociate())
+	assert.True(t, post.UserID.IsZero())
+	assert.Nil(t, post.User)
+}
+
+func TestRestrictedDeleteError(t *testing.T) {
+	err := fmt.Errorf("transaction error: %w", &odm.RestrictedDeleteError{Model: "*test.relUser", Relation: "posts", Count: 2})
+
+	var restricted *odm.RestrictedDeleteError
+	assert.True(t, errors.As(err, &restricted))
+	assert.Equal(t, "posts", restricted.Relation)
+	assert.EqualError(t, restricted, `cannot delete *test.relUser: relation "posts" still has 2 related document(s)`)
+}
+
+func TestGODM_SyncComparesHexAndObjectIDKeys(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		user := &relUser{ID: primitive.NewObjectID()}
+		q := (&odm.GODM{}).Use(user)
+		editor, viewer, admin := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{editor, viewer}}), // distinct
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),                           // delete
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),                           // update (upsert)
+			mtest.CreateSuccessResponse(),                                                     // commitTransaction
+		)
+		result, err := q.Sync("roles", []interface{}{editor.Hex(), admin})
+		assert.NoError(t, err)
+		assert.Equal(t, []interface{}{admin}, result.Attached)
+		assert.Equal(t, []interface{}{viewer}, result.Detached)
+
+		commands := sentCommands(mt)
+		assert.Len(t, commands, 4)
+		assert.Equal(t, "commitTransaction", commands[3].Index(0).Key())
+		detached := commands[1].Lookup("deletes", "0", "q", "role_id", "$in").Array().Index(0).Value().ObjectID()
+		assert.Equal(t, viewer, detached)
+		for _, cmd := range commands[:3] {
+			assert.False(t, cmd.Lookup("autocommit").Boolean(), "%s runs inside the transaction", cmd.Index(0).Key())
+		}
+	})
+}
+
+func TestGODM_PivotAndRefWritesConvertHexKeys(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		user := &relUser{ID: primitive.NewObjectID()}
+		role := primitive.NewObjectID()
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "upserted", Value: bson.A{}}))
+		assert.NoError(t, (&odm.GODM{}).Use(user).Attach("roles", []interface{}{role.Hex()}, nil))
+
+		upsert := sentCommands(mt)[0]
+		assert.Equal(t, role, upsert.Lookup("updates", "0", "q", "role_id").ObjectID())
+
+		kept, dropped := primitive.NewObjectID(), primitive.NewObjectID()
+		post := &relPost{ID: primitive.NewObjectID(), TagIDs: []primitive.ObjectID{kept, dropped}}
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+		assert.NoError(t, (&odm.GODM{}).Use(post).PullRef("tags", dropped.Hex()))
+
+		assert.Equal(t, []primitive.ObjectID{kept}, post.TagIDs)
+		pulled := sentCommands(mt)[0].Lookup("updates", "0", "u", "$pullAll", "tag_ids").Array().Index(0).Value()
+		assert.Equal(t, dropped, pulled.ObjectID())
+	})
+}
+
+type relInvoice struct {
+	ID    primitive.ObjectID `bson:"_id,omitempty"`
+	Lines []relEvent         `bson:"lines,omitempty" odm:"hasMany,from=lines,foreignKey=order_id,db=billing"`
+}
+
+type relMember struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty"`
+	Groups []relGroup         `bson:"groups,omitempty" odm:"belongsToMany,from=groups,pivot=group_member,pivotLocalKey=member_id,pivotForeignKey=group_id,pivotFields=role,strategy=preload,db=auth"`
+}
+
+type relGroup struct {
+	ID    primitive.ObjectID `bson:"_id,omitempty"`
+	Name  string             `bson:"name"`
+	Pivot bson.M             `bson:"pivot,omitempty"`
+}
+
+func TestGODM_LookupRejectsOtherDatabase(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		err := (&odm.GODM{}).Use(&relInvoice{}).With("lines").First()
+		assert.EqualError(t, err, `relation "lines" is in database "billing", which $lookup cannot reach from "godm_test"; use the preload strategy`)
+
+		_, err = (&odm.GODM{}).Use(&relOrder{}).Has("events", ">", 0).Count()
+		assert.EqualError(t, err, `relation "events" is in database "analytics", which $lookup cannot reach from "godm_test"`)
+		assert.Empty(t, sentCommands(mt))
+	})
+}
+
+func TestGODM_RelationQueriesRejectUnknownAndMorphTo(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		_, err := (&odm.GODM{}).Use(&relUser{}).Has("missing", ">", 0).Count()
+		assert.EqualError(t, err, `relation "missing" is not defined`)
+
+		err = (&odm.GODM{}).Use(&relUser{}).WhereHas("missing", nil).First()
+		assert.EqualError(t, err, `relation "missing" is not defined`)
+
+		var comments []relMorphComment
+		err = (&odm.GODM{}).Use(&relMorphComment{}).WithCount("commentable").All(&comments)
+		assert.EqualError(t, err, `relation "commentable" is a morphTo relation, which relation conditions and aggregates cannot query`)
+		assert.Empty(t, sentCommands(mt))
+	})
+}
+
+func TestGODM_PreloadStitchesAcrossDatabases(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		first, second := primitive.NewObjectID(), primitive.NewObjectID()
+		event := func(order primitive.ObjectID) bson.D {
+			return bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "order_id", Value: order}}
+		}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, mockDB+".relorders", mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: first}}, bson.D{{Key: "_id", Value: second}}),
+			mtest.CreateCursorResponse(0, "analytics.events", mtest.FirstBatch, event(first), event(second), event(first)),
+		)
+		var orders []relOrder
+		assert.NoError(t, (&odm.GODM{}).Use(&relOrder{}).With("events").All(&orders))
+
+		assert.Len(t, orders, 2)
+		assert.Len(t, orders[0].Events, 2)
+		assert.Len(t, orders[1].Events, 1)
+		assert.Equal(t, second, orders[1].Events[0].OrderID)
+		commands := sentCommands(mt)
+		assert.Equal(t, "analytics", commands[1].Lookup("$db").StringValue())
+	})
+}
+
+type relShipment struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty"`
+	Parcels []relParcel        `bson:"parcels,omitempty" odm:"hasMany,from=parcels,foreignKey=shipment_id,strategy=preload,db=logistics"`
+}
+
+type relParcel struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	ShipmentID primitive.ObjectID `bson:"shipment_id"`
+	Scans      []relScan          `bson:"scans,omitempty" odm:"hasMany,from=scans,foreignKey=parcel_id,strategy=preload"`
+}
+
+type relScan struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	ParcelID primitive.ObjectID `bson:"parcel_id"`
+}
+
+func TestGODM_PreloadResolvesDatabasePerLevel(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		shipment, parcel := primitive.NewObjectID(), primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, mockDB+".relshipments", mtest.FirstBatch, bson.D{{Key: "_id", Value: shipment}}),
+			mtest.CreateCursorResponse(0, "logistics.parcels", mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: parcel}, {Key: "shipment_id", Value: shipment}}),
+			mtest.CreateCursorResponse(0, "logistics.scans", mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "parcel_id", Value: parcel}}),
+		)
+		s := &relShipment{}
+		assert.NoError(t, (&odm.GODM{}).Use(s).With("parcels.scans").First())
+
+		assert.Len(t, s.Parcels, 1)
+		assert.Len(t, s.Parcels[0].Scans, 1)
+		// 巢狀關聯沿用上一層關聯的資料庫 / the nested relation uses its parent relation's database
+		commands := sentCommands(mt)
+		if assert.Len(t, commands, 3) {
+			assert.Equal(t, "logistics", commands[1].Lookup("$db").StringValue())
+			assert.Equal(t, "scans", commands[2].Lookup("aggregate").StringValue())
+			assert.Equal(t, "logistics", commands[2].Lookup("$db").StringValue())
+		}
+	})
+}
+
+func TestGODM_PreloadUsesTheCollectionDatabase(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		parcel := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "depot.parcels", mtest.FirstBatch, bson.D{{Key: "_id", Value: parcel}}),
+			mtest.CreateCursorResponse(0, "depot.scans", mtest.FirstBatch),
+		)
+		// 只設定 Collection、未設定 DBName 的查詢 / a builder with a Collection but no DBName
+		q := &odm.GODM{Collection: mt.Client.Database("depot").Collection("parcels"), Model: &relParcel{}}
+		assert.NoError(t, q.With("scans").First())
+
+		commands := sentCommands(mt)
+		if assert.Len(t, commands, 2) {
+			assert.Equal(t, "depot", commands[1].Lookup("$db").StringValue())
+		}
+	})
+}
+
+func TestGODM_PreloadPivotsFromRelationDatabase(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		member, owner, viewer := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, mockDB+".relmembers", mtest.FirstBatch, bson.D{{Key: "_id", Value: member}}),
+			mtest.CreateCursorResponse(0, "auth.group_member", mtest.FirstBatch,
+				bson.D{{Key: "member_id", Value: member}, {Key: "group_id", Value: owner}, {Key: "role", Value: "owner"}},
+				bson.D{{Key: "member_id", Value: member}, {Key: "group_id", Value: viewer}, {Key: "role", Value: "viewer"}}),
+			mtest.CreateCursorResponse(0, "auth.groups", mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: viewer}, {Key: "name", Value: "readers"}},
+				bson.D{{Key: "_id", Value: owner}, {Key: "name", Value: "admins"}}),
+		)
+		m := &relMember{}
+		assert.NoError(t, (&odm.GODM{}).Use(m).With("groups").First())
+
+		assert.Equal(t, []relGroup{
+			{ID: viewer, Name: "readers", Pivot: bson.M{"role": "viewer"}},
+			{ID: owner, Name: "admins", Pivot: bson.M{"role": "owner"}},
+		}, m.Groups)
+		commands := sentCommands(mt)
+		assert.Equal(t, "group_member", commands[1].Lookup("find").StringValue())
+		assert.Equal(t, "auth", commands[1].Lookup("$db").StringValue())
+	})
+}
+
+func TestGODM_WritesLeaveOutRelationFields(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		user := &relUser{Name: "ann", Posts: []relPost{{Title: "loaded"}}, Roles: []relRole{{Name: "admin"}}}
+		q := (&odm.GODM{}).Use(user)
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+		assert.NoError(t, q.Create())
+		assert.NoError(t, q.WhereID(user.ID).Update(bson.M{"name": "bob", "posts": bson.A{}, "posts.0.title": "x"}))
+
+		commands := sentCommands(mt)
+		inserted, err := commands[0].Lookup("documents", "0").Document().Elements()
+		assert.NoError(t, err)
+		var keys []string
+		for _, e := range inserted {
+			keys = append(keys, e.Key())
+		}
+		assert.Equal(t, []string{"_id", "name"}, keys)
+
+		set := commands[1].Lookup("updates", "0", "u", "$set").Document()
+		var update bson.M
+		assert.NoError(t, bson.Unmarshal(set, &update))
+		assert.Equal(t, bson.M{"name": "bob"}, update)
+	})
+}
+
+type relAuthor struct {
+	ID    primitive.ObjectID `bson:"_id,omitempty"`
+	Posts []relPost          `bson:"posts,omitempty" odm:"hasMany,foreignKey=user_id,onDelete=restrict"`
+}
+
+type relWriter struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	MentorID primitive.ObjectID `bson:"mentor_id"`
+	Comments []relComment       `bson:"comments,omitempty" odm:"hasMany,foreignKey=author_id,from=comments,onDelete=setNull"`
+	Roles    []relRole          `bson:"roles,omitempty" odm:"belongsToMany,from=roles,pivot=role_writer,pivotLocalKey=writer_id,pivotForeignKey=role_id,onDelete=cascade"`
+	Posts    []relPost          `bson:"posts,omitempty" odm:"hasMany,foreignKey=user_id,onDelete=noAction"`
+	Mentor   *relUser           `bson:"mentor,omitempty" odm:"belongsTo,localKey=mentor_id,from=users,onDelete=cascade"`
+}
+
+func TestGODM_DeleteRestrictedByRelation(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		author := &relAuthor{ID: primitive.NewObjectID()}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, mockDB+".relauthors", mtest.FirstBatch, bson.D{{Key: "_id", Value: author.ID}}),
+			mtest.CreateCursorResponse(0, mockDB+".relposts", mtest.FirstBatch, bson.D{{Key: "n", Value: 2}}), // count
+			mtest.CreateSuccessResponse(), // abortTransaction
+		)
+		err := (&odm.GODM{}).Use(author).WhereID(author.ID).Delete()
+
+		var restricted *odm.RestrictedDeleteError
+		assert.True(t, errors.As(err, &restricted))
+		assert.Equal(t, &odm.RestrictedDeleteError{Model: "*test.relAuthor", Relation: "posts", Count: 2}, restricted)
+		var names []string
+		for _, cmd := range sentCommands(mt) {
+			names = append(names, cmd.Index(0).Key())
+		}
+		assert.Equal(t, []string{"find", "aggregate", "abortTransaction"}, names)
+	})
+}
+
+func TestGODM_DeleteAppliesReferentialActions(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		writer := &relWriter{ID: primitive.NewObjectID(), MentorID: primitive.NewObjectID()}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, mockDB+".relwriters", mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: writer.ID}, {Key: "mentor_id", Value: writer.MentorID}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 3}, bson.E{Key: "nModified", Value: 3}), // comments
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}),                                     // role_writer
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),                                     // the writer
+			mtest.CreateSuccessResponse(),                                                               // commitTransaction
+		)
+		assert.NoError(t, (&odm.GODM{}).Use(writer).WhereID(writer.ID).Delete())
+
+		// noAction 與 belongsTo 不處理 / noAction and belongsTo relations are left alone
+		commands := sentCommands(mt)
+		assert.Len(t, commands, 5)
+		var targets []string
+		for _, cmd := range commands[:4] {
+			targets = append(targets, cmd.Index(0).Key()+" "+cmd.Index(0).Value().StringValue())
+		}
+		assert.Equal(t, []string{"find relwriters", "update comments", "delete role_writer", "delete relwriters"}, targets)
+		assert.Equal(t, "commitTransaction", commands[4].Index(0).Key())
+
+		var set bson.M
+		assert.NoError(t, bson.Unmarshal(commands[1].Lookup("updates", "0", "u", "$set").Document(), &set))
+		assert.Equal(t, bson.M{"author_id": nil}, set)
+		assert.Equal(t, writer.ID, commands[2].Lookup("deletes", "0", "q", "writer_id", "$in").Array().Index(0).Value().ObjectID())
+	})
+}
+
+type relBlogger struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty"`
+	Name   string             `bson:"name"`
+	Drafts []relDraft         `bson:"drafts,omitempty" odm:"hasMany,foreignKey=blogger_id,from=drafts"`
+}
+
+type relDraft struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	BloggerID primitive.ObjectID `bson:"blogger_id"`
+	Title     string             `bson:"title"`
+	observers []odm.ModelObserver
+}
+
+func (d *relDraft) Observers() []odm.ModelObserver {
+	return d.observers
+}
+
+func TestRelationBuilder_CreateSetsForeignKey(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		log := &eventLog{}
+		blogger := &relBlogger{ID: primitive.NewObjectID()}
+		perModel := map[string]bool{"creating": true, "created": true}
+		one := &relDraft{Title: "one", observers: []odm.ModelObserver{&recorder{name: "one", log: log, stages: perModel}}}
+		two := &relDraft{Title: "two", observers: []odm.ModelObserver{&recorder{name: "two", log: log, stages: perModel}}}
+		three := &relDraft{Title: "three", observers: []odm.ModelObserver{&recorder{name: "three", log: log, stages: perModel}}}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+		assert.NoError(t, (&odm.GODM{}).Use(blogger).Relation("drafts").Create(one))
+		assert.NoError(t, (&odm.GODM{}).Use(blogger).Relation("drafts").CreateMany([]interface{}{two, three}))
+
+		for _, draft := range []*relDraft{one, two, three} {
+			assert.Equal(t, blogger.ID, draft.BloggerID)
+			assert.False(t, draft.ID.IsZero())
+		}
+		// 單筆與多筆走同一條路徑，每個模型都觸發事件 / one and many take the same path, every model fires its events
+		assert.Equal(t, []string{"one:creating", "one:created", "two:creating", "three:creating", "two:created", "three:created"}, log.list())
+
+		commands := sentCommands(mt)
+		if assert.Len(t, commands, 2) {
+			assert.Equal(t, "drafts", commands[0].Lookup("insert").StringValue())
+			assert.Equal(t, blogger.ID, commands[0].Lookup("documents", "0", "blogger_id").ObjectID())
+			assert.Equal(t, blogger.ID, commands[1].Lookup("documents", "1", "blogger_id").ObjectID())
+		}
+	})
+}
+
+func TestGODM_SaveWithRelations(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		existing := primitive.NewObjectID()
+		blogger := &relBlogger{Name: "ann", Drafts: []relDraft{{Title: "new"}, {ID: existing, Title: "edited"}}}
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(),                           // insert the blogger
+			mtest.CreateSuccessResponse(),                           // insert the new draft
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}), // update the existing draft
+			mtest.CreateSuccessResponse(),                           // commitTransaction
+		)
+		assert.NoError(t, (&odm.GODM{}).Use(blogger).SaveWithRelations())
+
+		assert.False(t, blogger.ID.IsZero())
+		assert.Equal(t, blogger.ID, blogger.Drafts[0].BloggerID)
+		assert.Equal(t, blogger.ID, blogger.Drafts[1].BloggerID)
+
+		commands := sentCommands(mt)
+		var targets []string
+		for _, cmd := range commands[:3] {
+			targets = append(targets, cmd.Index(0).Key()+" "+cmd.Index(0).Value().StringValue())
+		}
+		assert.Equal(t, []string{"insert relbloggers", "insert drafts", "update drafts"}, targets)
+		assert.Equal(t, "commitTransaction", commands[3].Index(0).Key())
+		assert.Equal(t, blogger.ID, commands[1].Lookup("documents", "0", "blogger_id").ObjectID())
+		assert.Equal(t, existing, commands[2].Lookup("updates", "0", "q", "_id").ObjectID())
+		assert.Equal(t, blogger.ID, commands[2].Lookup("updates", "0", "u", "$set", "blogger_id").ObjectID())
+		for _, cmd := range commands[:3] {
+			assert.False(t, cmd.Lookup("autocommit").Boolean(), "%s runs inside the transaction", cmd.Index(0).Key())
+		}
+	})
+}
+
+func TestGODM_CreateWithRelations(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		// 已有 _id 的模型仍會被建立 / a model that already has an _id is still created
+		blogger := &relBlogger{ID: primitive.NewObjectID(), Drafts: []relDraft{{Title: "first"}}}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+		assert.NoError(t, (&odm.GODM{}).Use(blogger).Create(&odm.CreateOptions{Relations: []string{"drafts"}}))
+
+		var names []string
+		for _, cmd := range sentCommands(mt) {
+			names = append(names, cmd.Index(0).Key())
+		}
+		assert.Equal(t, []string{"insert", "insert", "commitTransaction"}, names)
+		assert.Equal(t, blogger.ID, blogger.Drafts[0].BloggerID)
+	})
+}
+
+func TestLoadMany_OneQueryPerRelation(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		users := []relUser{{ID: primitive.NewObjectID()}, {ID: primitive.NewObjectID()}, {ID: primitive.NewObjectID()}}
+		post := func(user primitive.ObjectID, title string, comments int) bson.D {
+			id := primitive.NewObjectID()
+			joined := bson.A{}
+			for i := 0; i < comments; i++ {
+				joined = append(joined, bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "post_id", Value: id}})
+			}
+			return bson.D{{Key: "_id", Value: id}, {Key: "user_id", Value: user}, {Key: "title", Value: title}, {Key: "comments", Value: joined}}
+		}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mockDB+".relposts", mtest.FirstBatch,
+			post(users[2].ID, "c1", 1), post(users[0].ID, "a1", 0), post(users[0].ID, "a2", 2)))
+		assert.NoError(t, odm.LoadMany(users, "posts", "posts.comments"))
+
+		// 子文檔接回各自的父文檔 / children are stitched into their own parents
+		assert.Equal(t, []string{"a1", "a2"}, []string{users[0].Posts[0].Title, users[0].Posts[1].Title})
+		assert.Empty(t, users[1].Posts)
+		assert.Equal(t, "c1", users[2].Posts[0].Title)
+		assert.Empty(t, users[0].Posts[0].Comments)
+		assert.Len(t, users[0].Posts[1].Comments, 2)
+		assert.Len(t, users[2].Posts[0].Comments, 1)
+
+		// 三個父文檔只查詢一次，巢狀關聯在同一個查詢中以 $lookup 取回
+		// three parents take one query, the nested relation is joined with $lookup in that same query
+		commands := sentCommands(mt)
+		if assert.Len(t, commands, 1) {
+			assert.Equal(t, "relposts", commands[0].Lookup("aggregate").StringValue())
+			parents, _ := commands[0].Lookup("pipeline", "0", "$match", "user_id", "$in").Array().Values()
+			assert.Len(t, parents, 3)
+			assert.Equal(t, "comments", commands[0].Lookup("pipeline", "1", "$lookup", "from").StringValue())
+		}
+	})
+}
+
+func TestGODM_LoadFillsTheModel(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		user := &relUser{ID: primitive.NewObjectID()}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mockDB+".relposts", mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "user_id", Value: user.ID}, {Key: "title", Value: "only"}}))
+		assert.NoError(t, (&odm.GODM{}).Use(user).Load("posts"))
+
+		assert.Len(t, user.Posts, 1)
+		assert.Equal(t, "only", user.Posts[0].Title)
+		commands := sentCommands(mt)
+		assert.Equal(t, user.ID, commands[0].Lookup("pipeline", "0", "$match", "user_id", "$in").Array().Index(0).Value().ObjectID())
+	})
+}
+
+func TestGODM_RelatedScopesTheQuery(t *testing.T) {
+	odm.RegisterMorph("video", &relVideo{})
+
+	t.Run("hasMany", func(t *testing.T) {
+		withMockClient(t, func(mt *mtest.T) {
+			user := &relUser{ID: primitive.NewObjectID()}
+			q, err := (&odm.GODM{}).Use(user).Related("posts")
+			assert.NoError(t, err)
+
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, mockDB+".relposts", mtest.FirstBatch))
+			var posts []relPost
+			assert.NoError(t, q.Where("title", "=", "draft").All(&posts))
+
+			filter := sentCommands(mt)[0].Lookup("filter")
+			assert.Equal(t, user.ID, filter.Document().Lookup("user_id", "$in").Array().Index(0).Value().ObjectID())
+			assert.Equal(t, "draft", filter.Document().Lookup("title").StringValue())
+		})
+	})
+
+	t.Run("belongsToMany", func(t *testing.T) {
+		withMockClient(t, func(mt *mtest.T) {
+			user := &relUser{ID: primitive.NewObjectID()}
+			admin, editor := primitive.NewObjectID(), primitive.NewObjectID()
+			mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{admin, editor}}))
+			q, err := (&odm.GODM{}).Use(user).Related("roles")
+			assert.NoError(t, err)
+
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, mockDB+".roles", mtest.FirstBatch))
+			var roles []relRole
+			assert.NoError(t, q.All(&roles))
+
+			commands := sentCommands(mt)
+			if assert.Len(t, commands, 2) {
+				assert.Equal(t, "role_user", commands[0].Lookup("distinct").StringValue())
+				assert.Equal(t, "role_id", commands[0].Lookup("key").StringValue())
+				assert.Equal(t, user.ID, commands[0].Lookup("query", "user_id").ObjectID())
+				assert.Equal(t, "roles", commands[1].Lookup("find").StringValue())
+				ids, _ := commands[1].Lookup("filter", "_id", "$in").Array().Values()
+				assert.Equal(t, []primitive.ObjectID{admin, editor}, []primitive.ObjectID{ids[0].ObjectID(), ids[1].ObjectID()})
+			}
+		})
+	})
+
+	t.Run("morphMany", func(t *testing.T) {
+		withMockClient(t, func(mt *mtest.T) {
+			video := &relVideo{ID: primitive.NewObjectID()}
+			q, err := (&odm.GODM{}).Use(video).Related("comments")
+			assert.NoError(t, err)
+
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, mockDB+".comments", mtest.FirstBatch))
+			var comments []relMorphComment
+			assert.NoError(t, q.All(&comments))
+
+			filter := sentCommands(mt)[0].Lookup("filter").Document()
+			assert.Equal(t, video.ID, filter.Lookup("commentable_id", "$in").Array().Index(0).Value().ObjectID())
+			assert.Equal(t, "video", filter.Lookup("commentable_type").StringValue())
+		})
+	})
+}
@@ -0,0 +1,239 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+
+	"godm/pkg/odm"
+)
+
+type txAccount struct {
+	odm.GODM `bson:"-"`
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	Name     string             `bson:"name"`
+}
+
+func newTxAccount() *txAccount {
+	a := &txAccount{}
+	a.Use(a)
+	return a
+}
+
+func TestTx_ModelReturnsFreshCopy(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		ctx := context.Background()
+		account := newTxAccount()
+		account.Ctx = ctx
+		account.Where("name", "=", "leftover").Limit(3)
+
+		// update, commitTransaction
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}), mtest.CreateSuccessResponse())
+		err := odm.Transaction(ctx, func(tx *odm.Tx) error {
+			return tx.Model(account).Where("name", "=", "a").Update(bson.M{"name": "b"})
+		})
+		assert.NoError(t, err)
+
+		// 內嵌的 GODM 不變 / the embedded GODM is untouched
+		assert.Equal(t, ctx, account.Ctx)
+		assert.Equal(t, bson.D{{Key: "name", Value: "leftover"}}, account.Filter)
+		assert.Equal(t, int64(3), account.LimitCount)
+
+		commands := sentCommands(mt)
+		assert.Equal(t, "update", commands[0].Index(0).Key())
+		assert.False(t, commands[0].Lookup("autocommit").Boolean())
+		var q bson.M
+		assert.NoError(t, bson.Unmarshal(commands[0].Lookup("updates", "0", "q").Document(), &q))
+		assert.Equal(t, bson.M{"name": "a"}, q)
+	})
+}
+
+func TestGODM_WithTransactionLeavesBuilderContext(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		ctx := context.Background()
+		account := newTxAccount()
+		account.Ctx = ctx
+
+		// insert, commitTransaction
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+		err := account.WithTransaction(func(sessCtx mongo.SessionContext) error {
+			assert.Equal(t, ctx, account.Ctx)
+			return (&odm.GODM{}).Use(&txAccount{Name: "a"}).WithContext(sessCtx).Create()
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, ctx, account.Ctx)
+
+		commands := sentCommands(mt)
+		assert.Equal(t, "insert", commands[0].Index(0).Key())
+		assert.False(t, commands[0].Lookup("autocommit").Boolean())
+		assert.Equal(t, "commitTransaction", commands[1].Index(0).Key())
+	})
+}
+
+func TestTransaction_RetriesTransientErrors(t *testing.T) {
+	transient := mongo.CommandError{Code: 112, Message: "write conflict", Labels: []string{"TransientTransactionError"}}
+	withMockClient(t, func(mt *mtest.T) {
+		runs := 0
+		err := odm.Transaction(context.Background(), func(tx *odm.Tx) error {
+			runs++
+			if runs == 1 {
+				return transient
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, runs)
+
+		// 負的 MaxRetryDuration 不重試 / a negative MaxRetryDuration disables retries
+		runs = 0
+		err = odm.Transaction(context.Background(), func(tx *odm.Tx) error {
+			runs++
+			return transient
+		}, &odm.TransactionOptions{MaxRetryDuration: -1})
+		assert.EqualError(t, err, "transaction error: write conflict")
+		assert.Equal(t, 1, runs)
+	})
+}
+
+func TestTransaction_RetriesOnlyTheCommit(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(), // insert
+			mtest.CreateCommandErrorResponse(mtest.CommandError{
+				Code: 1, Message: "commit unknown", Labels: []string{"UnknownTransactionCommitResult"},
+			}),
+			mtest.CreateSuccessResponse(), // commitTransaction again
+		)
+		runs := 0
+		err := odm.Transaction(context.Background(), func(tx *odm.Tx) error {
+			runs++
+			return tx.Model(&txAccount{Name: "a"}).Create()
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, runs)
+
+		var names []string
+		for _, cmd := range sentCommands(mt) {
+			names = append(names, cmd.Index(0).Key())
+		}
+		assert.Equal(t, []string{"insert", "commitTransaction", "commitTransaction"}, names)
+	})
+}
+
+func TestTransaction_MergesOptions(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		var outerSession, innerSession bson.Raw
+		run := func(opts ...*odm.TransactionOptions) {
+			// insert (outer), insert (inner), [commitTransaction (inner)], commitTransaction (outer)
+			mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(),
+				mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+			err := odm.Transaction(context.Background(), func(tx *odm.Tx) error {
+				if err := tx.Model(&txAccount{Name: "outer"}).Create(); err != nil {
+					return err
+				}
+				return odm.Transaction(tx.Context(), func(inner *odm.Tx) error {
+					return inner.Model(&txAccount{Name: "inner"}).Create()
+				}, opts...)
+			}, &odm.TransactionOptions{ReadConcern: readconcern.Majority()}, &odm.TransactionOptions{})
+			assert.NoError(t, err)
+
+			commands := sentCommands(mt)
+			// 後面的空選項不會重設讀取關注 / a later empty option set does not reset the read concern
+			assert.Equal(t, "majority", commands[0].Lookup("readConcern", "level").StringValue())
+			outerSession = commands[0].Lookup("lsid").Document()
+			innerSession = commands[1].Lookup("lsid").Document()
+			mt.ClearMockResponses()
+		}
+
+		// 後面的 Propagation 覆寫前面的設定 / a later Propagation overrides an earlier one
+		run(&odm.TransactionOptions{Propagation: odm.PropagationRequiresNew}, &odm.TransactionOptions{Propagation: odm.PropagationNested})
+		assert.Equal(t, outerSession, innerSession)
+
+		// 後面的空選項不會重設 Propagation / a later empty option set does not reset the Propagation
+		run(&odm.TransactionOptions{Propagation: odm.PropagationRequiresNew}, &odm.TransactionOptions{})
+		assert.NotEqual(t, outerSession, innerSession)
+	})
+}
+
+func TestTransaction_RequiredInnerErrorMakesOuterRollbackOnly(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		cause := errors.New("card declined")
+		err := odm.Transaction(context.Background(), func(tx *odm.Tx) error {
+			innerErr := odm.Transaction(tx.Context(), func(inner *odm.Tx) error {
+				return cause
+			})
+			assert.ErrorIs(t, innerErr, cause)
+			return nil // 忽略內層錯誤 / the inner error is ignored
+		})
+		assert.ErrorIs(t, err, odm.ErrTransactionRollbackOnly)
+		assert.ErrorIs(t, err, cause)
+	})
+}
+
+func TestTransaction_NestedRollsBackInReverseOrder(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		log := &eventLog{}
+		undo := func(name string) func(context.Context) error {
+			return func(context.Context) error {
+				log.add(name)
+				return nil
+			}
+		}
+		nested := &odm.TransactionOptions{Propagation: odm.PropagationNested}
+
+		// 範圍外的 OnRollback 不做任何事 / OnRollback outside a scope does nothing
+		odm.OnRollback(context.Background(), undo("outside"))
+
+		err := odm.Transaction(context.Background(), func(tx *odm.Tx) error {
+			odm.OnRollback(tx.Context(), undo("outer"))
+			err := odm.Transaction(tx.Context(), func(inner *odm.Tx) error {
+				odm.OnRollback(inner.Context(), undo("first"))
+				// 成功的內層範圍併入父範圍 / a successful inner scope is merged into its parent
+				if err := odm.Transaction(inner.Context(), func(child *odm.Tx) error {
+					odm.OnRollback(child.Context(), undo("merged"))
+					return nil
+				}, nested); err != nil {
+					return err
+				}
+				odm.OnRollback(inner.Context(), undo("last"))
+				return errors.New("boom")
+			}, nested)
+			assert.EqualError(t, err, "savepoint rolled back: boom")
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"last", "merged", "first"}, log.list())
+	})
+}
+
+func TestTransaction_NestedServerErrorAbortsTransaction(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key"}),
+			mtest.CreateSuccessResponse(), // abortTransaction
+		)
+		compensated := false
+		err := odm.Transaction(context.Background(), func(tx *odm.Tx) error {
+			innerErr := odm.Transaction(tx.Context(), func(inner *odm.Tx) error {
+				odm.OnRollback(inner.Context(), func(context.Context) error {
+					compensated = true
+					return nil
+				})
+				return inner.Model(&txAccount{Name: "taken"}).Create()
+			}, &odm.TransactionOptions{Propagation: odm.PropagationNested})
+			assert.ErrorIs(t, innerErr, odm.ErrTransactionAborted)
+			return nil
+		})
+		assert.ErrorIs(t, err, odm.ErrTransactionRollbackOnly)
+		var writeErr mongo.WriteException
+		assert.True(t, errors.As(err, &writeErr))
+		assert.False(t, compensated)
+	})
+}
@@ -0,0 +1,150 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"godm/pkg/odm"
+)
+
+func TestUnitOfWork_RegisterStates(t *testing.T) {
+	kept := &relTag{Name: "kept"}
+	dropped := &relTag{Name: "dropped"}
+
+	uow := odm.NewUnitOfWork(context.Background())
+	uow.RegisterNew(kept, dropped).RegisterDirty(kept)
+	assert.Equal(t, 2, uow.Len())
+
+	// 新增後又刪除的模型不再追蹤 / a new model registered as deleted is no longer tracked
+	uow.RegisterDeleted(dropped)
+	assert.Equal(t, 1, uow.Len())
+
+	uow.Clear()
+	assert.Equal(t, 0, uow.Len())
+	assert.NoError(t, uow.Commit())
+}
+
+func TestUnitOfWork_CommitRequiresID(t *testing.T) {
+	uow := odm.NewUnitOfWork(context.Background()).RegisterDirty(&relTag{Name: "no id"})
+
+	err := uow.Commit()
+	assert.EqualError(t, err, "unit of work error: *test.relTag has no _id")
+	assert.Equal(t, 1, uow.Len())
+}
+
+func TestUnitOfWorkError(t *testing.T) {
+	dup := mongo.WriteError{Index: 0, Code: 11000, Message: "duplicate key"}
+	err := &odm.UnitOfWorkError{Failures: []odm.WriteFailure{
+		{Model: &relTag{}, Operation: "insert", Err: dup},
+	}}
+
+	assert.EqualError(t, err, "unit of work: 1 write(s) failed: insert *test.relTag: duplicate key")
+	var writeErr mongo.WriteError
+	assert.True(t, errors.As(err, &writeErr))
+	assert.Equal(t, 11000, writeErr.Code)
+}
+
+func TestUnitOfWork_OrderedFailureSkipsLaterWrites(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		first, taken, last := &relTag{Name: "first"}, &relTag{Name: "taken"}, &relTag{Name: "last"}
+		post := &relPost{ID: primitive.NewObjectID(), Title: "later collection"}
+		uow := odm.NewUnitOfWork(context.Background()).RegisterNew(first, taken, last).RegisterDirty(post)
+
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 1, Code: 11000, Message: "duplicate key"}))
+		err := uow.Commit()
+
+		var failed *odm.UnitOfWorkError
+		assert.True(t, errors.As(err, &failed))
+		assert.Len(t, failed.Failures, 3)
+		assert.Equal(t, taken, failed.Failures[0].Model)
+		assert.Equal(t, []interface{}{last, post}, []interface{}{failed.Failures[1].Model, failed.Failures[2].Model})
+		assert.ErrorIs(t, failed.Failures[1].Err, odm.ErrWriteSkipped)
+		assert.ErrorIs(t, failed.Failures[2].Err, odm.ErrWriteSkipped)
+		assert.Len(t, sentCommands(mt), 1)
+
+		// 已寫入的模型不再追蹤，再次 Commit 不會重複新增 / written models are untracked, so a retry does not insert them again
+		assert.Equal(t, 3, uow.Len())
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+		)
+		assert.NoError(t, uow.Commit())
+		assert.Equal(t, 0, uow.Len())
+
+		commands := sentCommands(mt)
+		assert.Len(t, commands, 2)
+		docs, _ := commands[0].Lookup("documents").Array().Values()
+		assert.Len(t, docs, 2)
+		assert.Equal(t, "update", commands[1].Index(0).Key())
+	})
+}
+
+func TestUnitOfWork_TransactionFailureKeepsTracking(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		uow := odm.NewUnitOfWork(context.Background(), &odm.UnitOfWorkOptions{Transaction: true}).
+			RegisterNew(&relTag{Name: "a"}, &relTag{Name: "b"})
+
+		mt.AddMockResponses(
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 1, Code: 11000, Message: "duplicate key"}),
+			mtest.CreateSuccessResponse(), // abortTransaction
+		)
+		err := uow.Commit()
+		var failed *odm.UnitOfWorkError
+		assert.True(t, errors.As(err, &failed))
+		assert.Equal(t, 2, uow.Len())
+	})
+}
+
+func TestUnitOfWork_TransactionFiresAfterEventsOnCommit(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		log := &eventLog{}
+		account := &obsAccount{Name: "a", observers: []odm.ModelObserver{&recorder{name: "model", log: log}}}
+		uow := odm.NewUnitOfWork(context.Background(), &odm.UnitOfWorkOptions{Transaction: true}).RegisterNew(account)
+
+		// 第一次提交遇到暫時性錯誤，整個交易重試 / the first commit hits a transient error, so the whole transaction is retried
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{
+				Code: 112, Message: "write conflict", Labels: []string{"TransientTransactionError"},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(), // commitTransaction
+		)
+		assert.NoError(t, uow.Commit())
+
+		var names []string
+		for _, cmd := range sentCommands(mt) {
+			names = append(names, cmd.Index(0).Key())
+		}
+		assert.Equal(t, []string{"insert", "commitTransaction", "insert", "commitTransaction"}, names)
+		// 後置事件只在提交後觸發一次 / the after-events fire once, after the commit
+		assert.Equal(t, []string{
+			"model:saving", "model:creating",
+			"model:saving", "model:creating",
+			"model:created", "model:saved",
+		}, log.list())
+	})
+}
+
+func TestUnitOfWork_FailedCommitFiresNoAfterEvents(t *testing.T) {
+	withMockClient(t, func(mt *mtest.T) {
+		log := &eventLog{}
+		account := &obsAccount{Name: "a", observers: []odm.ModelObserver{&recorder{name: "model", log: log}}}
+		uow := odm.NewUnitOfWork(context.Background(), &odm.UnitOfWorkOptions{Transaction: true}).RegisterNew(account)
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 8000, Message: "commit failed"}),
+		)
+		assert.Error(t, uow.Commit())
+		assert.Equal(t, []string{"model:saving", "model:creating"}, log.list())
+		assert.Equal(t, 1, uow.Len())
+	})
+}